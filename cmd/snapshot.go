@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mopeyjellyfish/hookr/runtime"
+	"github.com/mopeyjellyfish/hookr/runtime/snapshot"
+)
+
+// snapshotCmd initializes a plugin and writes it out as a pre-initialized module.
+func snapshotCmd(args []string, stderr io.Writer) error {
+	fs := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	out := fs.String("o", "", "output file, defaults to the input file with the extension .init.wasm")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: hookr snapshot [-o output.wasm] plugin.wasm")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected a single plugin")
+	}
+	in := fs.Arg(0)
+	if *out == "" {
+		*out = strings.TrimSuffix(in, ".wasm") + ".init.wasm"
+	}
+
+	bin, err := os.ReadFile(in)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	rt, err := runtime.New(ctx,
+		runtime.WithFile(in),
+		runtime.WithSnapshot(),
+		runtime.WithLogger(func(msg string) { fmt.Fprintln(stderr, msg) }),
+		runtime.WithStdout(stderr),
+		runtime.WithStderr(stderr),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to initialize plugin: %w", err)
+	}
+	s := rt.Snapshot()
+	if err := rt.Close(ctx); err != nil {
+		return err
+	}
+
+	pre, err := snapshot.Preinitialize(bin, s)
+	if err != nil {
+		return fmt.Errorf("failed to pre-initialize plugin: %w", err)
+	}
+	return os.WriteFile(*out, pre, 0o644)
+}
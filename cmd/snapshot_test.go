@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mopeyjellyfish/hookr/runtime"
+	"github.com/stretchr/testify/require"
+)
+
+const SNAPSHOT_WASM = "../testdata/snapshot/bin/snapshot.wasm"
+
+// copyPlugin copies the plugin to a temporary directory and returns its path.
+func copyPlugin(t *testing.T, src string) string {
+	t.Helper()
+	bin, err := os.ReadFile(src)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), filepath.Base(src))
+	require.NoError(t, os.WriteFile(path, bin, 0o600))
+	return path
+}
+
+func TestSnapshotCmd(t *testing.T) {
+	ctx := context.Background()
+	in := copyPlugin(t, SNAPSHOT_WASM)
+
+	var stderr bytes.Buffer
+	require.NoError(t, snapshotCmd([]string{in}, &stderr), "failed to snapshot plugin")
+	require.Equal(t, "init\n", stderr.String(), "the plugin should be initialized once")
+
+	out := filepath.Join(filepath.Dir(in), "snapshot.init.wasm")
+	inits := 0
+	rt, err := runtime.New(ctx, runtime.WithFile(out), runtime.WithLogger(func(string) { inits++ }))
+	require.NoError(t, err, "failed to load the pre-initialized plugin")
+	defer func() {
+		require.NoError(t, rt.Close(ctx), "failed to close module")
+	}()
+	require.Equal(t, 0, inits, "a pre-initialized plugin should not be initialized again")
+
+	state, err := rt.Invoke(ctx, "read", nil)
+	require.NoError(t, err, "failed to invoke the pre-initialized plugin")
+	require.Equal(t, []byte{42, 'a', 'b', 'c', 1}, state, "the plugin should start in its initialized state")
+}
+
+func TestSnapshotCmdOutput(t *testing.T) {
+	in := copyPlugin(t, SNAPSHOT_WASM)
+	out := filepath.Join(t.TempDir(), "out.wasm")
+
+	require.NoError(t, snapshotCmd([]string{"-o", out, in}, &bytes.Buffer{}), "failed to snapshot plugin")
+	require.FileExists(t, out)
+	require.NoFileExists(t, filepath.Join(filepath.Dir(in), "snapshot.init.wasm"))
+}
+
+func TestSnapshotCmdErrors(t *testing.T) {
+	var stderr bytes.Buffer
+	require.ErrorContains(t, snapshotCmd(nil, &stderr), "expected a single plugin")
+	require.Contains(t, stderr.String(), "usage: hookr snapshot")
+
+	require.Error(t, snapshotCmd([]string{filepath.Join(t.TempDir(), "missing.wasm")}, &stderr))
+	require.ErrorContains(t, snapshotCmd([]string{"../testdata/invalid/invalidformat.wasm"}, &stderr), "failed to initialize plugin")
+}
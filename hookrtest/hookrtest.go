@@ -0,0 +1,189 @@
+// Package hookrtest provides utilities for testing hosts and plugins.
+//
+// A Builder creates a plugin which is closed when the test finishes, capturing
+// its logs and output:
+//
+//	host := hookrtest.NewHost(t)
+//	host.ExpectCall("hello").ReturnMsg(&api.HelloResponse{Msg: "Hello hookr"})
+//
+//	plugin := hookrtest.NewBuilder(t, "./plugin.wasm").Host(host).Build()
+//	out := plugin.MustInvoke("echo", payload)
+//	plugin.AssertLogged("received echo")
+//
+// The host fails the test when an expected call was not made, or the plugin
+// made a call which was not expected.
+package hookrtest
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mopeyjellyfish/hookr/runtime"
+)
+
+// Builder creates a plugin for a test.
+type Builder struct {
+	t    testing.TB
+	file string
+	opts []runtime.Option
+	host *Host
+}
+
+// NewBuilder returns a Builder for the plugin in file.
+func NewBuilder(t testing.TB, file string) *Builder {
+	return &Builder{t: t, file: file}
+}
+
+// With adds options used to create the runtime.
+func (b *Builder) With(opts ...runtime.Option) *Builder {
+	b.opts = append(b.opts, opts...)
+	return b
+}
+
+// Host answers the plugin's host calls with the host, replacing any host
+// functions registered with the options.
+func (b *Builder) Host(host *Host) *Builder {
+	b.host = host
+	return b
+}
+
+// Build creates the plugin, failing the test when it cannot be created.
+// The plugin is closed when the test finishes.
+func (b *Builder) Build() *Plugin {
+	b.t.Helper()
+
+	p := &Plugin{t: b.t}
+	opts := []runtime.Option{
+		runtime.WithFile(b.file),
+		runtime.WithLogger(p.log),
+		runtime.WithStdout(&p.stdout),
+		runtime.WithStderr(&p.stderr),
+	}
+	opts = append(opts, b.opts...)
+	if b.host != nil {
+		opts = append(opts, runtime.WithCallHandler(b.host.Handle))
+	}
+
+	rt, err := runtime.New(context.Background(), opts...)
+	if err != nil {
+		b.t.Fatalf("hookrtest: failed to create plugin %s: %v", b.file, err)
+		return nil
+	}
+	p.Runtime = rt
+	b.t.Cleanup(func() {
+		if err := rt.Close(context.Background()); err != nil {
+			b.t.Errorf("hookrtest: failed to close plugin %s: %v", b.file, err)
+		}
+	})
+	return p
+}
+
+// Plugin is a plugin created for a test, it captures the plugin's logs,
+// stdout and stderr.
+type Plugin struct {
+	*runtime.Runtime
+
+	t      testing.TB
+	mu     sync.Mutex
+	logs   []string
+	stdout buffer
+	stderr buffer
+}
+
+// MustInvoke invokes the operation, failing the test on error.
+func (p *Plugin) MustInvoke(operation string, payload []byte) []byte {
+	p.t.Helper()
+	out, err := p.Invoke(context.Background(), operation, payload)
+	if err != nil {
+		p.t.Fatalf("hookrtest: failed to invoke %q: %v", operation, err)
+	}
+	return out
+}
+
+func (p *Plugin) log(msg string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.logs = append(p.logs, msg)
+}
+
+// Logs returns the messages the plugin logged.
+func (p *Plugin) Logs() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string{}, p.logs...)
+}
+
+// Stdout returns what the plugin wrote to stdout.
+func (p *Plugin) Stdout() string {
+	return p.stdout.String()
+}
+
+// Stderr returns what the plugin wrote to stderr.
+func (p *Plugin) Stderr() string {
+	return p.stderr.String()
+}
+
+// AssertLogged fails the test unless the plugin logged a message containing substr.
+func (p *Plugin) AssertLogged(substr string) bool {
+	p.t.Helper()
+	for _, msg := range p.Logs() {
+		if strings.Contains(msg, substr) {
+			return true
+		}
+	}
+	p.t.Errorf("hookrtest: plugin did not log %q, logs: %q", substr, p.Logs())
+	return false
+}
+
+// AssertNotLogged fails the test when the plugin logged a message containing substr.
+func (p *Plugin) AssertNotLogged(substr string) bool {
+	p.t.Helper()
+	for _, msg := range p.Logs() {
+		if strings.Contains(msg, substr) {
+			p.t.Errorf("hookrtest: plugin logged %q", msg)
+			return false
+		}
+	}
+	return true
+}
+
+// AssertStdout fails the test unless the plugin's stdout contains substr.
+func (p *Plugin) AssertStdout(substr string) bool {
+	p.t.Helper()
+	if out := p.Stdout(); !strings.Contains(out, substr) {
+		p.t.Errorf("hookrtest: plugin stdout does not contain %q, stdout: %q", substr, out)
+		return false
+	}
+	return true
+}
+
+// AssertStderr fails the test unless the plugin's stderr contains substr.
+func (p *Plugin) AssertStderr(substr string) bool {
+	p.t.Helper()
+	if out := p.Stderr(); !strings.Contains(out, substr) {
+		p.t.Errorf("hookrtest: plugin stderr does not contain %q, stderr: %q", substr, out)
+		return false
+	}
+	return true
+}
+
+// buffer is a bytes.Buffer safe for concurrent use.
+type buffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *buffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *buffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
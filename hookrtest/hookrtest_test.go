@@ -0,0 +1,139 @@
+package hookrtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/mopeyjellyfish/hookr/testdata/api"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	SIMPLE_WASM = "../testdata/simple/bin/simple.wasm"
+	PRINT_WASM  = "../testdata/print/bin/print.wasm"
+)
+
+// fakeT records failures instead of failing the test.
+type fakeT struct {
+	testing.TB
+	errors   []string
+	cleanups []func()
+}
+
+func (f *fakeT) Helper() {}
+
+func (f *fakeT) Errorf(format string, args ...any) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeT) Fatalf(format string, args ...any) {
+	f.Errorf(format, args...)
+}
+
+func (f *fakeT) Cleanup(fn func()) {
+	f.cleanups = append(f.cleanups, fn)
+}
+
+func (f *fakeT) finish() {
+	for i := len(f.cleanups) - 1; i >= 0; i-- {
+		f.cleanups[i]()
+	}
+}
+
+func TestExpectCall(t *testing.T) {
+	host := NewHost(t)
+	host.ExpectCall("hello").ReturnMsg(&api.HelloResponse{Msg: "mocked"})
+	host.ExpectCall("helloByte").WithPayload([]byte("hi")).Return([]byte("bye"))
+
+	plugin := NewBuilder(t, SIMPLE_WASM).Host(host).Build()
+
+	payload, err := (&api.EchoRequest{Data: "hello"}).MarshalMsg(nil)
+	require.NoError(t, err)
+	resp := &api.EchoResponse{}
+	_, err = resp.UnmarshalMsg(plugin.MustInvoke("echo", payload))
+	require.NoError(t, err)
+	require.Equal(t, "mocked", resp.Data)
+
+	require.Equal(t, []byte("bye"), plugin.MustInvoke("echoByte", []byte("hi")))
+
+	calls := host.Calls()
+	require.Len(t, calls, 2)
+	require.Equal(t, "hello", calls[0].Operation)
+	require.Equal(t, Call{Operation: "helloByte", Payload: []byte("hi")}, calls[1])
+}
+
+func TestReturnError(t *testing.T) {
+	host := NewHost(t)
+	host.ExpectCall("helloByte").ReturnError(errors.New("host unavailable")).Times(2)
+
+	plugin := NewBuilder(t, SIMPLE_WASM).Host(host).Build()
+	for range 2 {
+		_, err := plugin.Invoke(context.Background(), "echoByte", []byte("hi"))
+		require.ErrorContains(t, err, "host unavailable")
+	}
+	plugin.AssertLogged("host unavailable")
+	plugin.AssertNotLogged("planned Failure")
+}
+
+func TestDo(t *testing.T) {
+	host := NewHost(t)
+	host.ExpectCall("helloByte").AnyTimes().Do(func(_ context.Context, payload []byte) ([]byte, error) {
+		return append([]byte("re: "), payload...), nil
+	})
+
+	plugin := NewBuilder(t, SIMPLE_WASM).Host(host).Build()
+	require.Equal(t, []byte("re: a"), plugin.MustInvoke("echoByte", []byte("a")))
+	require.Equal(t, []byte("re: b"), plugin.MustInvoke("echoByte", []byte("b")))
+}
+
+func TestUnmetExpectations(t *testing.T) {
+	ft := &fakeT{TB: t}
+	host := NewHost(ft)
+	host.ExpectCall("hello")
+	host.ExpectCall("helloByte").Times(2)
+
+	plugin := NewBuilder(ft, SIMPLE_WASM).Host(host).Build()
+	plugin.MustInvoke("echoByte", []byte("a"))
+	require.Empty(t, ft.errors)
+
+	ft.finish()
+	require.Equal(t, []string{
+		`hookrtest: expected host call "hello" 1 times, got 0`,
+		`hookrtest: expected host call "helloByte" 2 times, got 1`,
+	}, ft.errors)
+	require.Equal(t, "closed", plugin.State().String())
+}
+
+func TestUnexpectedCall(t *testing.T) {
+	ft := &fakeT{TB: t}
+	host := NewHost(ft)
+
+	plugin := NewBuilder(ft, SIMPLE_WASM).Host(host).Build()
+	_, err := plugin.Invoke(context.Background(), "echoByte", []byte("a"))
+	require.ErrorContains(t, err, `unexpected host call "helloByte"`)
+	require.Equal(t, []string{`hookrtest: unexpected host call "helloByte" with payload "a"`}, ft.errors)
+
+	plugin.AssertLogged("nothing like this")
+	require.Len(t, ft.errors, 2)
+	ft.finish()
+}
+
+func TestBuildFailure(t *testing.T) {
+	ft := &fakeT{TB: t}
+	require.Nil(t, NewBuilder(ft, "missing.wasm").Build())
+	require.Len(t, ft.errors, 1)
+	require.Contains(t, ft.errors[0], "failed to create plugin missing.wasm")
+}
+
+func TestOutput(t *testing.T) {
+	plugin := NewBuilder(t, PRINT_WASM).Build()
+	plugin.MustInvoke("out", []byte("to stdout"))
+	plugin.MustInvoke("err", []byte("to stderr"))
+
+	plugin.AssertStdout("to stdout")
+	plugin.AssertStderr("to stderr")
+	require.Equal(t, "to stdout", plugin.Stdout())
+	require.Equal(t, "to stderr", plugin.Stderr())
+}
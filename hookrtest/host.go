@@ -0,0 +1,165 @@
+package hookrtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/mopeyjellyfish/hookr/runtime"
+)
+
+// Call is a host call made by the plugin.
+type Call struct {
+	Operation string
+	Payload   []byte
+}
+
+// Host answers the host calls of a plugin with the expected calls registered
+// with ExpectCall and records every call it receives. The expectations are
+// asserted when the test finishes.
+type Host struct {
+	t            testing.TB
+	mu           sync.Mutex
+	expectations []*Expectation
+	calls        []Call
+}
+
+// NewHost returns a Host for the test.
+func NewHost(t testing.TB) *Host {
+	h := &Host{t: t}
+	t.Cleanup(func() {
+		h.AssertExpectations()
+	})
+	return h
+}
+
+// ExpectCall expects the plugin to call the host function once, answering it
+// with an empty response unless told otherwise.
+func (h *Host) ExpectCall(operation string) *Expectation {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e := &Expectation{operation: operation, times: 1}
+	h.expectations = append(h.expectations, e)
+	return e
+}
+
+// Handle answers a host call of the plugin, it is the runtime.CallHandler of
+// the plugins created with the host.
+func (h *Host) Handle(ctx context.Context, operation string, payload []byte) ([]byte, error) {
+	h.mu.Lock()
+	h.calls = append(h.calls, Call{Operation: operation, Payload: bytes.Clone(payload)})
+	e := h.match(operation, payload)
+	h.mu.Unlock()
+
+	if e == nil {
+		h.t.Errorf("hookrtest: unexpected host call %q with payload %q", operation, payload)
+		return nil, fmt.Errorf("unexpected host call %q", operation)
+	}
+	return e.answer(ctx, payload)
+}
+
+// match returns the first expectation for the call which may still be called.
+func (h *Host) match(operation string, payload []byte) *Expectation {
+	for _, e := range h.expectations {
+		if e.operation != operation || (e.payload != nil && !bytes.Equal(e.payload, payload)) {
+			continue
+		}
+		if e.times < 0 || e.calls < e.times {
+			e.calls++
+			return e
+		}
+	}
+	return nil
+}
+
+// Calls returns the host calls the plugin made.
+func (h *Host) Calls() []Call {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Call{}, h.calls...)
+}
+
+// AssertExpectations fails the test when an expected call was not made as
+// often as expected.
+func (h *Host) AssertExpectations() bool {
+	h.t.Helper()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ok := true
+	for _, e := range h.expectations {
+		if e.times >= 0 && e.calls != e.times {
+			h.t.Errorf("hookrtest: expected host call %q %d times, got %d", e.operation, e.times, e.calls)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// Expectation is an expected host call.
+type Expectation struct {
+	operation string
+	payload   []byte
+	times     int // -1 for any number of calls
+	calls     int
+
+	response []byte
+	msg      runtime.Marshaler
+	err      error
+	fn       runtime.CallFn
+}
+
+// WithPayload only matches calls with the payload.
+func (e *Expectation) WithPayload(payload []byte) *Expectation {
+	e.payload = payload
+	return e
+}
+
+// Return answers the call with the response.
+func (e *Expectation) Return(response []byte) *Expectation {
+	e.response = response
+	return e
+}
+
+// ReturnMsg answers the call with the marshalled message.
+func (e *Expectation) ReturnMsg(msg runtime.Marshaler) *Expectation {
+	e.msg = msg
+	return e
+}
+
+// ReturnError answers the call with the error.
+func (e *Expectation) ReturnError(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+// Do answers the call with fn.
+func (e *Expectation) Do(fn runtime.CallFn) *Expectation {
+	e.fn = fn
+	return e
+}
+
+// Times expects the call n times.
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+// AnyTimes allows the call any number of times, including never.
+func (e *Expectation) AnyTimes() *Expectation {
+	e.times = -1
+	return e
+}
+
+func (e *Expectation) answer(ctx context.Context, payload []byte) ([]byte, error) {
+	switch {
+	case e.fn != nil:
+		return e.fn(ctx, payload)
+	case e.err != nil:
+		return nil, e.err
+	case e.msg != nil:
+		return e.msg.MarshalMsg(nil)
+	}
+	return e.response, nil
+}
@@ -0,0 +1,386 @@
+// Package hooks dispatches named events to many plugins.
+//
+// Each plugin is loaded as its own runtime.Runtime and registered with a Hooks
+// registry. Plugins built with the PDK declare the hooks they handle with
+// pdk.Hook, other plugins can be registered for hooks explicitly:
+//
+//	h := hooks.New()
+//	if err := h.Register(ctx, "audit", auditRuntime, hooks.WithPriority(10)); err != nil {
+//		log.Fatal(err)
+//	}
+//	if err := h.Register(ctx, "legacy", legacyRuntime, hooks.WithHandles("user.created")); err != nil {
+//		log.Fatal(err)
+//	}
+//
+//	result, err := h.Dispatch(ctx, "user.created", payload, hooks.Broadcast)
+//
+// Handlers are called in priority order, highest first. How the handlers are
+// combined is decided by the Mode passed to Dispatch.
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mopeyjellyfish/hookr/pdk"
+	"github.com/mopeyjellyfish/hookr/runtime"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// Mode decides how an event is dispatched to the handlers of a hook.
+type Mode int
+
+const (
+	// Broadcast calls every handler concurrently and collects all responses.
+	Broadcast Mode = iota
+
+	// FirstSuccess calls the handlers in priority order and stops at the first
+	// handler which succeeds.
+	FirstSuccess
+
+	// Chain calls the handlers in priority order, the output of each handler
+	// becomes the payload of the next. A handler can stop the chain with pdk.Veto,
+	// handlers which fail are skipped and leave the payload unchanged.
+	Chain
+)
+
+func (m Mode) String() string {
+	switch m {
+	case Broadcast:
+		return "broadcast"
+	case FirstSuccess:
+		return "first-success"
+	case Chain:
+		return "chain"
+	default:
+		return fmt.Sprintf("mode(%d)", int(m))
+	}
+}
+
+var (
+	// ErrNoHandlers is returned when an event is dispatched to a hook without handlers.
+	ErrNoHandlers = errors.New("no handlers registered for hook")
+
+	// ErrVetoed is matched by the VetoError returned when a handler vetoed the
+	// event in a chain, it is runtime.ErrVetoed.
+	ErrVetoed = runtime.ErrVetoed
+)
+
+// Invoker calls a plugin function, it is implemented by *runtime.Runtime.
+type Invoker interface {
+	Invoke(ctx context.Context, operation string, payload []byte) ([]byte, error)
+}
+
+// Response is the outcome of calling a single handler.
+type Response struct {
+	Plugin string
+	Output []byte
+	Err    error
+}
+
+// Result is the outcome of dispatching an event.
+type Result struct {
+	// Responses of every handler that was called, in priority order.
+	Responses []Response
+
+	// Payload is the output of the successful handler for FirstSuccess and the
+	// final payload for Chain. It is nil for Broadcast.
+	Payload []byte
+}
+
+// HandlerError is the error of a single handler.
+type HandlerError struct {
+	Hook   string
+	Plugin string
+	Err    error
+}
+
+func (e *HandlerError) Error() string {
+	return fmt.Sprintf("hook %q handler %q: %v", e.Hook, e.Plugin, e.Err)
+}
+
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// DispatchError aggregates the errors of the handlers of a dispatched event.
+type DispatchError struct {
+	Hook   string
+	Errors []*HandlerError
+}
+
+func (e *DispatchError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d handler(s) of hook %q failed: %s", len(e.Errors), e.Hook, strings.Join(msgs, "; "))
+}
+
+func (e *DispatchError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// VetoError is returned when a handler vetoed the event in a chain.
+type VetoError struct {
+	Hook   string
+	Plugin string
+	Reason string
+}
+
+func (e *VetoError) Error() string {
+	return fmt.Sprintf("hook %q vetoed by %q: %s", e.Hook, e.Plugin, e.Reason)
+}
+
+func (e *VetoError) Unwrap() error {
+	return ErrVetoed
+}
+
+type handler struct {
+	plugin   string
+	priority int
+	invoker  Invoker
+}
+
+// Hooks is a registry of plugins and the hooks they handle.
+// It is safe for concurrent use.
+type Hooks struct {
+	mu       sync.RWMutex
+	plugins  map[string][]string // plugin name to the hooks it handles
+	handlers map[string][]*handler
+}
+
+// New returns an empty registry.
+func New() *Hooks {
+	return &Hooks{
+		plugins:  make(map[string][]string),
+		handlers: make(map[string][]*handler),
+	}
+}
+
+type registerOptions struct {
+	priority int
+	handles  []string
+}
+
+type RegisterOption func(*registerOptions)
+
+// WithPriority sets the priority of the plugin's handlers, higher priorities are called first.
+func WithPriority(priority int) RegisterOption {
+	return func(o *registerOptions) {
+		o.priority = priority
+	}
+}
+
+// WithHandles declares the hooks the plugin handles instead of asking the plugin.
+// The plugin function with the name of the hook is called for each event.
+func WithHandles(hooks ...string) RegisterOption {
+	return func(o *registerOptions) {
+		o.handles = append(o.handles, hooks...)
+	}
+}
+
+// Register adds the plugin under the given name. Unless WithHandles is used the
+// plugin is asked which hooks it handles, which requires it to register at least
+// one hook with pdk.Hook.
+func (h *Hooks) Register(ctx context.Context, name string, invoker Invoker, opts ...RegisterOption) error {
+	if name == "" {
+		return errors.New("name cannot be empty")
+	}
+	if invoker == nil {
+		return errors.New("invoker cannot be nil")
+	}
+
+	o := registerOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	handles := o.handles
+	if len(handles) == 0 {
+		var err error
+		if handles, err = declaredHooks(ctx, invoker); err != nil {
+			return fmt.Errorf("failed to get hooks of plugin %q: %w", name, err)
+		}
+	}
+	if len(handles) == 0 {
+		return fmt.Errorf("plugin %q does not handle any hooks", name)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.plugins[name]; ok {
+		return fmt.Errorf("plugin %q already registered", name)
+	}
+	h.plugins[name] = handles
+	for _, hook := range handles {
+		// copy the handlers, a concurrent Dispatch may still be iterating the old slice.
+		handlers := make([]*handler, 0, len(h.handlers[hook])+1)
+		handlers = append(handlers, h.handlers[hook]...)
+		handlers = append(handlers, &handler{plugin: name, priority: o.priority, invoker: invoker})
+		sort.SliceStable(handlers, func(i, j int) bool {
+			return handlers[i].priority > handlers[j].priority
+		})
+		h.handlers[hook] = handlers
+	}
+	return nil
+}
+
+// Unregister removes the plugin and all of its handlers.
+func (h *Hooks) Unregister(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, hook := range h.plugins[name] {
+		handlers := make([]*handler, 0, len(h.handlers[hook]))
+		for _, hd := range h.handlers[hook] {
+			if hd.plugin != name {
+				handlers = append(handlers, hd)
+			}
+		}
+		if len(handlers) == 0 {
+			delete(h.handlers, hook)
+		} else {
+			h.handlers[hook] = handlers
+		}
+	}
+	delete(h.plugins, name)
+}
+
+// Handlers returns the names of the plugins handling the hook in priority order.
+func (h *Hooks) Handlers(hook string) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	names := make([]string, len(h.handlers[hook]))
+	for i, hd := range h.handlers[hook] {
+		names[i] = hd.plugin
+	}
+	return names
+}
+
+// Dispatch sends the event to the handlers of the hook using the given mode.
+// The Result holds the response of every handler that was called, the error
+// is a *DispatchError aggregating the handler errors, or a *VetoError when a
+// chain was vetoed.
+func (h *Hooks) Dispatch(ctx context.Context, hook string, payload []byte, mode Mode) (*Result, error) {
+	h.mu.RLock()
+	handlers := h.handlers[hook]
+	h.mu.RUnlock()
+
+	if len(handlers) == 0 {
+		return nil, fmt.Errorf("%w %q", ErrNoHandlers, hook)
+	}
+
+	switch mode {
+	case Broadcast:
+		return broadcast(ctx, hook, handlers, payload)
+	case FirstSuccess:
+		return firstSuccess(ctx, hook, handlers, payload)
+	case Chain:
+		return chain(ctx, hook, handlers, payload)
+	default:
+		return nil, fmt.Errorf("unknown dispatch mode %s", mode)
+	}
+}
+
+func broadcast(ctx context.Context, hook string, handlers []*handler, payload []byte) (*Result, error) {
+	result := &Result{Responses: make([]Response, len(handlers))}
+
+	var wg sync.WaitGroup
+	for i, hd := range handlers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, err := hd.invoker.Invoke(ctx, hook, payload)
+			result.Responses[i] = Response{Plugin: hd.plugin, Output: out, Err: err}
+		}()
+	}
+	wg.Wait()
+
+	return result, collect(hook, result.Responses)
+}
+
+func firstSuccess(ctx context.Context, hook string, handlers []*handler, payload []byte) (*Result, error) {
+	result := &Result{}
+	for _, hd := range handlers {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		out, err := hd.invoker.Invoke(ctx, hook, payload)
+		result.Responses = append(result.Responses, Response{Plugin: hd.plugin, Output: out, Err: err})
+		if err == nil {
+			result.Payload = out
+			return result, nil
+		}
+	}
+	return result, collect(hook, result.Responses)
+}
+
+func chain(ctx context.Context, hook string, handlers []*handler, payload []byte) (*Result, error) {
+	result := &Result{Payload: payload}
+	for _, hd := range handlers {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		out, err := hd.invoker.Invoke(ctx, hook, result.Payload)
+		result.Responses = append(result.Responses, Response{Plugin: hd.plugin, Output: out, Err: err})
+		if err != nil {
+			var veto *runtime.VetoError
+			if errors.As(err, &veto) {
+				return result, &VetoError{Hook: hook, Plugin: hd.plugin, Reason: veto.Reason}
+			}
+			continue
+		}
+		result.Payload = out
+	}
+	return result, collect(hook, result.Responses)
+}
+
+// collect aggregates the errors of the responses, nil is returned when there
+// are none.
+func collect(hook string, responses []Response) error {
+	var errs []*HandlerError
+	for _, resp := range responses {
+		if resp.Err != nil {
+			errs = append(errs, &HandlerError{Hook: hook, Plugin: resp.Plugin, Err: resp.Err})
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return &DispatchError{Hook: hook, Errors: errs}
+}
+
+// declaredHooks asks the plugin for the hooks it handles. The size of the
+// array is checked against the bytes left before allocating, every hook takes
+// at least one.
+func declaredHooks(ctx context.Context, invoker Invoker) ([]string, error) {
+	data, err := invoker.Invoke(ctx, pdk.HooksFn, nil)
+	if err != nil {
+		return nil, err
+	}
+	size, data, err := msgp.ReadArrayHeaderBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(size) > uint64(len(data)) {
+		return nil, msgp.ErrShortBytes
+	}
+	hooks := make([]string, size)
+	for i := range hooks {
+		if hooks[i], data, err = msgp.ReadStringBytes(data); err != nil {
+			return nil, err
+		}
+	}
+	return hooks, nil
+}
@@ -0,0 +1,170 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mopeyjellyfish/hookr/pdk"
+	"github.com/mopeyjellyfish/hookr/runtime"
+	"github.com/stretchr/testify/require"
+	"github.com/tinylib/msgp/msgp"
+)
+
+const SIMPLE_WASM = "../testdata/simple/bin/simple.wasm"
+
+// fakePlugin handles hooks with Go functions.
+type fakePlugin map[string]func(payload []byte) ([]byte, error)
+
+func (f fakePlugin) Invoke(_ context.Context, operation string, payload []byte) ([]byte, error) {
+	fn, ok := f[operation]
+	if !ok {
+		return nil, errors.New("unknown operation " + operation)
+	}
+	return fn(payload)
+}
+
+func declares(hooks ...string) func([]byte) ([]byte, error) {
+	return func([]byte) ([]byte, error) {
+		b := msgp.AppendArrayHeader(nil, uint32(len(hooks)))
+		for _, hook := range hooks {
+			b = msgp.AppendString(b, hook)
+		}
+		return b, nil
+	}
+}
+
+func appendFn(s string) func([]byte) ([]byte, error) {
+	return func(payload []byte) ([]byte, error) {
+		return append(append([]byte{}, payload...), s...), nil
+	}
+}
+
+func failFn(err error) func([]byte) ([]byte, error) {
+	return func([]byte) ([]byte, error) {
+		return nil, err
+	}
+}
+
+func TestRegister(t *testing.T) {
+	ctx := context.Background()
+	h := New()
+
+	err := h.Register(ctx, "", fakePlugin{}, WithHandles("a"))
+	require.Error(t, err, "expected error when registering without a name")
+	err = h.Register(ctx, "nil", nil, WithHandles("a"))
+	require.Error(t, err, "expected error when registering a nil invoker")
+	err = h.Register(ctx, "undeclared", fakePlugin{})
+	require.Error(t, err, "expected error when the plugin does not declare hooks")
+	err = h.Register(ctx, "empty", fakePlugin{pdk.HooksFn: declares()})
+	require.Error(t, err, "expected error when the plugin handles no hooks")
+	forged := func([]byte) ([]byte, error) { return []byte{0xdd, 0xff, 0xff, 0xff, 0xff}, nil }
+	err = h.Register(ctx, "forged", fakePlugin{pdk.HooksFn: forged})
+	require.ErrorIs(t, err, msgp.ErrShortBytes, "expected error when the plugin declares more hooks than it sent")
+
+	require.NoError(t, h.Register(ctx, "low", fakePlugin{pdk.HooksFn: declares("a", "b")}, WithPriority(1)))
+	require.NoError(t, h.Register(ctx, "high", fakePlugin{}, WithHandles("a"), WithPriority(10)))
+	require.NoError(t, h.Register(ctx, "default", fakePlugin{}, WithHandles("a")))
+	err = h.Register(ctx, "low", fakePlugin{}, WithHandles("a"))
+	require.Error(t, err, "expected error when registering a plugin twice")
+
+	require.Equal(t, []string{"high", "low", "default"}, h.Handlers("a"), "handlers should be in priority order")
+	require.Equal(t, []string{"low"}, h.Handlers("b"))
+
+	h.Unregister("low")
+	require.Equal(t, []string{"high", "default"}, h.Handlers("a"))
+	require.Empty(t, h.Handlers("b"))
+
+	_, err = h.Dispatch(ctx, "b", nil, Broadcast)
+	require.ErrorIs(t, err, ErrNoHandlers)
+	_, err = h.Dispatch(ctx, "a", nil, Mode(42))
+	require.Error(t, err, "expected error for an unknown mode")
+}
+
+func TestBroadcast(t *testing.T) {
+	ctx := context.Background()
+	h := New()
+	planned := errors.New("planned failure")
+	require.NoError(t, h.Register(ctx, "one", fakePlugin{"event": appendFn("1")}, WithHandles("event"), WithPriority(2)))
+	require.NoError(t, h.Register(ctx, "two", fakePlugin{"event": failFn(planned)}, WithHandles("event"), WithPriority(1)))
+	require.NoError(t, h.Register(ctx, "three", fakePlugin{"event": appendFn("3")}, WithHandles("event")))
+
+	result, err := h.Dispatch(ctx, "event", []byte("x"), Broadcast)
+	require.ErrorIs(t, err, planned, "handler errors should be aggregated")
+	var dispatchErr *DispatchError
+	require.ErrorAs(t, err, &dispatchErr)
+	require.Len(t, dispatchErr.Errors, 1)
+	require.Equal(t, "two", dispatchErr.Errors[0].Plugin)
+
+	require.Len(t, result.Responses, 3, "all handlers should be called")
+	require.Equal(t, "x1", string(result.Responses[0].Output))
+	require.Equal(t, "x3", string(result.Responses[2].Output))
+	require.Nil(t, result.Payload)
+}
+
+func TestFirstSuccess(t *testing.T) {
+	ctx := context.Background()
+	h := New()
+	planned := errors.New("planned failure")
+	require.NoError(t, h.Register(ctx, "one", fakePlugin{"event": failFn(planned)}, WithHandles("event"), WithPriority(2)))
+	require.NoError(t, h.Register(ctx, "two", fakePlugin{"event": appendFn("2")}, WithHandles("event"), WithPriority(1)))
+	require.NoError(t, h.Register(ctx, "three", fakePlugin{"event": appendFn("3")}, WithHandles("event")))
+
+	result, err := h.Dispatch(ctx, "event", []byte("x"), FirstSuccess)
+	require.NoError(t, err, "a later handler succeeded")
+	require.Equal(t, "x2", string(result.Payload))
+	require.Len(t, result.Responses, 2, "handlers after the first success should not be called")
+
+	h.Unregister("two")
+	h.Unregister("three")
+	_, err = h.Dispatch(ctx, "event", []byte("x"), FirstSuccess)
+	require.ErrorIs(t, err, planned, "all handlers failed")
+}
+
+func TestChain(t *testing.T) {
+	ctx := context.Background()
+	h := New()
+	planned := errors.New("planned failure")
+	require.NoError(t, h.Register(ctx, "one", fakePlugin{"event": appendFn("1")}, WithHandles("event"), WithPriority(3)))
+	require.NoError(t, h.Register(ctx, "two", fakePlugin{"event": failFn(planned)}, WithHandles("event"), WithPriority(2)))
+	require.NoError(t, h.Register(ctx, "three", fakePlugin{"event": appendFn("3")}, WithHandles("event"), WithPriority(1)))
+
+	result, err := h.Dispatch(ctx, "event", []byte("x"), Chain)
+	require.ErrorIs(t, err, planned, "failed handlers should be reported")
+	require.Equal(t, "x13", string(result.Payload), "failed handlers should leave the payload unchanged")
+
+	require.NoError(t, h.Register(ctx, "veto", fakePlugin{"event": failFn(&runtime.VetoError{Reason: "not today"})}, WithHandles("event"), WithPriority(2)))
+	result, err = h.Dispatch(ctx, "event", []byte("x"), Chain)
+	require.ErrorIs(t, err, ErrVetoed)
+	require.ErrorIs(t, err, runtime.ErrVetoed, "a chain veto should match the runtime's veto")
+	var vetoErr *VetoError
+	require.ErrorAs(t, err, &vetoErr)
+	require.Equal(t, "veto", vetoErr.Plugin)
+	require.Equal(t, "not today", vetoErr.Reason)
+	require.Equal(t, "x1", string(result.Payload), "handlers after a veto should not be called")
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = h.Dispatch(cancelled, "event", []byte("x"), Chain)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestDispatchRuntime(t *testing.T) {
+	ctx := context.Background()
+	h := New()
+	for _, name := range []string{"first", "second"} {
+		rt, err := runtime.New(ctx, runtime.WithFile(SIMPLE_WASM))
+		require.NoError(t, err, "failed to create module")
+		defer func() {
+			require.NoError(t, rt.Close(ctx), "failed to close module")
+		}()
+		require.NoError(t, h.Register(ctx, name, rt, WithHandles("vowel")))
+	}
+
+	result, err := h.Dispatch(ctx, "vowel", []byte("hookr"), Broadcast)
+	require.NoError(t, err, "failed to dispatch event")
+	require.Len(t, result.Responses, 2)
+	for _, resp := range result.Responses {
+		require.Equal(t, "2", string(resp.Output))
+	}
+}
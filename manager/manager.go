@@ -0,0 +1,541 @@
+// Package manager loads and manages many plugins.
+//
+// Plugins are discovered from a directory, where a file named
+// "greeter@1.2.0.wasm" is version 1.2.0 of the plugin greeter, or read from a
+// JSON or YAML manifest, see Manifest. They are loaded concurrently, sharing
+// the compilation of modules, and looked up by name and version:
+//
+//	m := manager.New(manager.WithRuntimeOptions(runtime.WithHostFns(fns...)))
+//	defer m.Close(ctx)
+//
+//	if err := m.LoadDir(ctx, "./plugins"); err != nil {
+//		log.Printf("some plugins failed to load: %v", err)
+//	}
+//	rt, ok := m.Get("greeter", "") // the latest version
+//
+// A plugin which fails to load does not stop the others from loading, the
+// failures are returned together as a *LoadError.
+package manager
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mopeyjellyfish/hookr/runtime"
+	"github.com/tetratelabs/wazero"
+)
+
+const (
+	// Ext is the extension of the plugin files discovered in a directory.
+	Ext = ".wasm"
+
+	// VersionSep separates the name and the version of a plugin, "greeter@1.2.0".
+	VersionSep = "@"
+)
+
+var (
+	// ErrNotFound is returned when no loaded plugin has the name and version.
+	ErrNotFound = errors.New("plugin not found")
+
+	// ErrClosed is returned when the manager is used after it was closed.
+	ErrClosed = errors.New("manager closed")
+)
+
+// Plugin describes a plugin to load.
+type Plugin struct {
+	Name    string
+	Version string
+	Path    string
+
+	// FileOptions verify the file of the plugin, Reload is given those of the
+	// file replacing it.
+	FileOptions []runtime.FileOption
+
+	// Options are passed to runtime.New after the options of the manager.
+	Options []runtime.Option
+
+	// PoolSize is the number of instances of the plugin the calls of Invoke are
+	// spread over, one when 0. A pool of more than one instance cannot be
+	// created with runtime.WithLinker or runtime.WithRecorder.
+	PoolSize int
+}
+
+// String returns the name and version of the plugin, "greeter@1.2.0".
+func (p Plugin) String() string {
+	if p.Version == "" {
+		return p.Name
+	}
+	return p.Name + VersionSep + p.Version
+}
+
+// PluginError is the error of a single plugin.
+type PluginError struct {
+	Plugin string
+	Path   string
+	Err    error
+}
+
+func (e *PluginError) Error() string {
+	return fmt.Sprintf("plugin %q (%s): %v", e.Plugin, e.Path, e.Err)
+}
+
+func (e *PluginError) Unwrap() error {
+	return e.Err
+}
+
+// LoadError aggregates the errors of the plugins which failed to load.
+type LoadError struct {
+	Errors []*PluginError
+}
+
+func (e *LoadError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d plugin(s) failed to load: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+func (e *LoadError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+type entry struct {
+	plugin Plugin
+	pool   *pool
+}
+
+// Manager owns the runtimes of the loaded plugins.
+// It is safe for concurrent use.
+type Manager struct {
+	cache       wazero.CompilationCache
+	opts        []runtime.Option
+	concurrency int
+
+	mu      sync.RWMutex
+	plugins map[string]map[string]*entry // name to version
+	closed  bool
+}
+
+// Option configures a Manager.
+type Option func(*Manager)
+
+// WithRuntimeOptions sets the options every plugin's runtime is created with.
+func WithRuntimeOptions(opts ...runtime.Option) Option {
+	return func(m *Manager) {
+		m.opts = append(m.opts, opts...)
+	}
+}
+
+// WithConcurrency limits how many plugins are loaded at once, by default all
+// plugins passed to Load are loaded at once.
+func WithConcurrency(n int) Option {
+	return func(m *Manager) {
+		m.concurrency = n
+	}
+}
+
+// New returns a manager without plugins.
+func New(opts ...Option) *Manager {
+	m := &Manager{
+		cache:   wazero.NewCompilationCache(),
+		plugins: make(map[string]map[string]*entry),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Discover returns the plugins in the directory, every file with the extension
+// Ext. The name and version are taken from the file name, "greeter@1.2.0.wasm",
+// a file without a version is an unversioned plugin.
+func Discover(dir string) ([]Plugin, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugin directory: %w", err)
+	}
+	var plugins []Plugin
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != Ext {
+			continue
+		}
+		name, version, _ := strings.Cut(strings.TrimSuffix(file.Name(), Ext), VersionSep)
+		if name == "" {
+			return nil, fmt.Errorf("plugin file %s has no name", file.Name())
+		}
+		plugins = append(plugins, Plugin{Name: name, Version: version, Path: filepath.Join(dir, file.Name())})
+	}
+	return plugins, nil
+}
+
+// LoadDir loads the plugins discovered in the directory, see Discover and Load.
+func (m *Manager) LoadDir(ctx context.Context, dir string) error {
+	plugins, err := Discover(dir)
+	if err != nil {
+		return err
+	}
+	return m.Load(ctx, plugins...)
+}
+
+// LoadManifest loads the plugins listed in the manifest file, see ReadManifest and Load.
+func (m *Manager) LoadManifest(ctx context.Context, path string) error {
+	plugins, err := ReadManifest(path)
+	if err != nil {
+		return err
+	}
+	return m.Load(ctx, plugins...)
+}
+
+// Load loads the plugins concurrently. The plugins which loaded are available
+// even when others failed, the failures are returned as a *LoadError. A plugin
+// cannot be loaded twice with the same name and version.
+func (m *Manager) Load(ctx context.Context, plugins ...Plugin) error {
+	errs := make([]*PluginError, len(plugins))
+	pools := make([]*pool, len(plugins))
+
+	seen := make(map[string]bool, len(plugins))
+	m.mu.RLock()
+	closed := m.closed
+	for i, p := range plugins {
+		switch {
+		case closed:
+			errs[i] = &PluginError{Plugin: p.String(), Path: p.Path, Err: ErrClosed}
+		case p.Name == "":
+			errs[i] = &PluginError{Plugin: p.String(), Path: p.Path, Err: errors.New("name cannot be empty")}
+		case p.Path == "":
+			errs[i] = &PluginError{Plugin: p.String(), Path: p.Path, Err: errors.New("path cannot be empty")}
+		case p.PoolSize < 0:
+			errs[i] = &PluginError{Plugin: p.String(), Path: p.Path, Err: errors.New("pool size cannot be negative")}
+		case seen[p.String()] || m.plugins[p.Name][p.Version] != nil:
+			errs[i] = &PluginError{Plugin: p.String(), Path: p.Path, Err: errors.New("already loaded")}
+		}
+		seen[p.String()] = true
+	}
+	m.mu.RUnlock()
+
+	concurrency := m.concurrency
+	if concurrency <= 0 {
+		concurrency = len(plugins)
+	}
+	sem := make(chan struct{}, max(concurrency, 1))
+	var wg sync.WaitGroup
+	for i, p := range plugins {
+		if errs[i] != nil {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			instances, err := newPool(ctx, max(p.PoolSize, 1), m.runtimeOptions(p))
+			if err != nil {
+				errs[i] = &PluginError{Plugin: p.String(), Path: p.Path, Err: err}
+				return
+			}
+			pools[i] = instances
+		}()
+	}
+	wg.Wait()
+
+	m.mu.Lock()
+	for i, instances := range pools {
+		if instances == nil {
+			continue
+		}
+		p := plugins[i]
+		switch {
+		case m.closed:
+			errs[i] = &PluginError{Plugin: p.String(), Path: p.Path, Err: ErrClosed}
+		case m.plugins[p.Name][p.Version] != nil: // loaded by a concurrent Load
+			errs[i] = &PluginError{Plugin: p.String(), Path: p.Path, Err: errors.New("already loaded")}
+		default:
+			if m.plugins[p.Name] == nil {
+				m.plugins[p.Name] = make(map[string]*entry)
+			}
+			m.plugins[p.Name][p.Version] = &entry{plugin: p, pool: instances}
+			continue
+		}
+		_ = instances.close(ctx)
+	}
+	m.mu.Unlock()
+
+	var loadErr LoadError
+	for _, err := range errs {
+		if err != nil {
+			loadErr.Errors = append(loadErr.Errors, err)
+		}
+	}
+	if len(loadErr.Errors) > 0 {
+		return &loadErr
+	}
+	return nil
+}
+
+// runtimeOptions returns the options the runtime of the plugin is created with.
+func (m *Manager) runtimeOptions(p Plugin) []runtime.Option {
+	opts := make([]runtime.Option, 0, len(m.opts)+len(p.Options)+2)
+	opts = append(opts, runtime.WithFile(p.Path, p.FileOptions...), runtime.WithCompilationCache(m.cache))
+	opts = append(opts, m.opts...)
+	return append(opts, p.Options...)
+}
+
+// Get returns the runtime of the plugin with the name and version, an empty
+// version returns the latest version of the plugin. The runtime of a pooled
+// plugin is its first instance, Invoke spreads calls over all of them.
+func (m *Manager) Get(name, version string) (*runtime.Runtime, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	e := m.lookup(name, version)
+	if e == nil {
+		return nil, false
+	}
+	return e.pool.runtimes[0], true
+}
+
+// Invoke calls the plugin function of the plugin with the name and version,
+// see Get, on an idle instance of the plugin. It waits for an instance to
+// become idle until ctx is done.
+func (m *Manager) Invoke(ctx context.Context, name, version, operation string, payload []byte) ([]byte, error) {
+	m.mu.RLock()
+	e := m.lookup(name, version)
+	m.mu.RUnlock()
+	if e == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, Plugin{Name: name, Version: version})
+	}
+	return e.pool.invoke(ctx, operation, payload)
+}
+
+// InvokeBatch calls the plugin function of the plugin with the name and
+// version, see Get, once for each payload, see runtime.Runtime.InvokeBatch.
+// The payloads of a pooled plugin are split evenly over its instances, which
+// process their part concurrently. The results are in the order of the
+// payloads, items which could not get an instance before ctx was done fail
+// with the context error.
+func (m *Manager) InvokeBatch(ctx context.Context, name, version, operation string, payloads [][]byte) []runtime.Result[[]byte] {
+	m.mu.RLock()
+	e := m.lookup(name, version)
+	m.mu.RUnlock()
+	if e == nil {
+		err := fmt.Errorf("%w: %s", ErrNotFound, Plugin{Name: name, Version: version})
+		results := make([]runtime.Result[[]byte], len(payloads))
+		for i := range results {
+			results[i].Err = err
+		}
+		return results
+	}
+	return e.pool.invokeBatch(ctx, operation, payloads)
+}
+
+// lookup returns the plugin with the name and version, see Get.
+// The caller must hold m.mu.
+func (m *Manager) lookup(name, version string) *entry {
+	versions := m.plugins[name]
+	if version != "" || len(versions) == 0 {
+		return versions[version]
+	}
+	var latest *entry
+	for _, e := range versions {
+		if latest == nil || CompareVersions(e.plugin.Version, latest.plugin.Version) > 0 {
+			latest = e
+		}
+	}
+	return latest
+}
+
+// Versions returns the loaded versions of the plugin, oldest first.
+func (m *Manager) Versions(name string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	versions := make([]string, 0, len(m.plugins[name]))
+	for version := range m.plugins[name] {
+		versions = append(versions, version)
+	}
+	slices.SortFunc(versions, CompareVersions)
+	return versions
+}
+
+// Plugins returns the loaded plugins sorted by name and version.
+func (m *Manager) Plugins() []Plugin {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var plugins []Plugin
+	for _, versions := range m.plugins {
+		for _, e := range versions {
+			plugins = append(plugins, e.plugin)
+		}
+	}
+	slices.SortFunc(plugins, func(a, b Plugin) int {
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		return CompareVersions(a.Version, b.Version)
+	})
+	return plugins
+}
+
+// Unload removes the plugin with the name and version, see Get, and shuts its
+// runtime down once the in-flight calls finished or ctx is done.
+func (m *Manager) Unload(ctx context.Context, name, version string) error {
+	m.mu.Lock()
+	e := m.lookup(name, version)
+	if e == nil {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrNotFound, Plugin{Name: name, Version: version})
+	}
+	delete(m.plugins[name], e.plugin.Version)
+	if len(m.plugins[name]) == 0 {
+		delete(m.plugins, name)
+	}
+	m.mu.Unlock()
+
+	if err := e.pool.shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to unload plugin %q: %w", e.plugin.String(), err)
+	}
+	return nil
+}
+
+// Reload reloads the plugin with the name and version, see Get, from its file.
+// The new file is verified with opts, which a plugin loaded with FileOptions
+// requires as its hash or signature only match the previous file. When the new
+// module fails to load the previous one keeps running, see
+// runtime.Runtime.Reload. The instances of a pooled plugin are reloaded one by
+// one, when one fails those already reloaded are rolled back.
+func (m *Manager) Reload(ctx context.Context, name, version string, smoke *runtime.SmokeTest, opts ...runtime.FileOption) error {
+	m.mu.RLock()
+	e := m.lookup(name, version)
+	var path string
+	var verified bool
+	if e != nil {
+		path, verified = e.plugin.Path, len(e.plugin.FileOptions) > 0
+	}
+	m.mu.RUnlock()
+	if e == nil {
+		return fmt.Errorf("%w: %s", ErrNotFound, Plugin{Name: name, Version: version})
+	}
+	if verified && len(opts) == 0 {
+		return &runtime.ReloadError{Path: path, Err: errors.New("plugin file is verified, the options verifying the new file are required")}
+	}
+
+	file, err := runtime.NewFile(path, opts...)
+	if err != nil {
+		return &runtime.ReloadError{Path: path, Err: err}
+	}
+	if err := e.pool.reload(ctx, file, smoke); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	e.plugin.FileOptions = opts
+	m.mu.Unlock()
+	return nil
+}
+
+// Close shuts down the runtimes of all plugins, waiting for their in-flight
+// calls until ctx is done. The manager cannot load plugins once closed.
+func (m *Manager) Close(ctx context.Context) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	plugins := m.plugins
+	m.plugins = make(map[string]map[string]*entry)
+	m.mu.Unlock()
+
+	var errs []error
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, versions := range plugins {
+		for _, e := range versions {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := e.pool.shutdown(ctx); err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("failed to close plugin %q: %w", e.plugin.String(), err))
+					mu.Unlock()
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	if err := m.cache.Close(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close compilation cache: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// CompareVersions compares two versions, returning -1, 0 or +1. Versions are
+// compared by their dot separated parts, numerically where both parts are
+// numbers, "1.10.0" is newer than "1.9.0". A leading "v" and any build
+// metadata after a "+" are ignored. As in semantic versioning, a pre-release
+// after a "-" is older than its release, "1.0.0-rc1" is older than "1.0.0",
+// and pre-releases of the same version are compared by their parts, a numeric
+// part being older than any other but an empty one.
+func CompareVersions(a, b string) int {
+	a, _, _ = strings.Cut(strings.TrimPrefix(a, "v"), "+")
+	b, _, _ = strings.Cut(strings.TrimPrefix(b, "v"), "+")
+	a, aPre, aIsPre := strings.Cut(a, "-")
+	b, bPre, bIsPre := strings.Cut(b, "-")
+	if c := compareParts(a, b); c != 0 {
+		return c
+	}
+	switch {
+	case aIsPre && bIsPre:
+		return compareParts(aPre, bPre)
+	case aIsPre:
+		return -1
+	case bIsPre:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// compareParts compares the dot separated parts of two versions, see
+// CompareVersions.
+func compareParts(a, b string) int {
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := range min(len(as), len(bs)) {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		var c int
+		switch {
+		case aErr == nil && bErr == nil:
+			c = cmp.Compare(an, bn)
+		case as[i] == "" || bs[i] == "": // an empty part is older than any other
+			c = strings.Compare(as[i], bs[i])
+		case aErr == nil:
+			c = -1
+		case bErr == nil:
+			c = 1
+		default:
+			c = strings.Compare(as[i], bs[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(as), len(bs))
+}
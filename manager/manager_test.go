@@ -0,0 +1,312 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mopeyjellyfish/hookr/runtime"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	ABI_WASM     = "../testdata/abi/bin/abi.wasm"
+	LEGACY_WASM  = "../testdata/abi/bin/legacy.wasm"
+	INVALID_WASM = "../testdata/invalid/invalidformat.wasm"
+)
+
+func reply(_ context.Context, payload []byte) ([]byte, error) {
+	return append([]byte("re: "), payload...), nil
+}
+
+// copyFile copies the file at src to dir under the name.
+func copyFile(t *testing.T, dir, name, src string) string {
+	t.Helper()
+	data, err := os.ReadFile(src)
+	require.NoError(t, err)
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	return path
+}
+
+func newManager(t *testing.T, opts ...Option) *Manager {
+	t.Helper()
+	m := New(append([]Option{WithRuntimeOptions(runtime.WithHostFns(runtime.HostFnByte("hello", reply)))}, opts...)...)
+	t.Cleanup(func() {
+		_ = m.Close(context.Background())
+	})
+	return m
+}
+
+func echo(t *testing.T, m *Manager, name, version string) {
+	t.Helper()
+	rt, ok := m.Get(name, version)
+	require.True(t, ok, "plugin %s@%s is loaded", name, version)
+	out, err := rt.Invoke(context.Background(), "echo", []byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("re: a"), out)
+}
+
+func TestDiscover(t *testing.T) {
+	dir := t.TempDir()
+	copyFile(t, dir, "greeter@1.0.0.wasm", ABI_WASM)
+	copyFile(t, dir, "plain.wasm", ABI_WASM)
+	copyFile(t, dir, "notes.txt", ABI_WASM)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub.wasm"), 0o700))
+
+	plugins, err := Discover(dir)
+	require.NoError(t, err)
+	require.Equal(t, []Plugin{
+		{Name: "greeter", Version: "1.0.0", Path: filepath.Join(dir, "greeter@1.0.0.wasm")},
+		{Name: "plain", Path: filepath.Join(dir, "plain.wasm")},
+	}, plugins)
+
+	copyFile(t, dir, "@1.0.0.wasm", ABI_WASM)
+	_, err = Discover(dir)
+	require.ErrorContains(t, err, "has no name")
+	_, err = Discover(filepath.Join(dir, "missing"))
+	require.Error(t, err, "expected error for a missing directory")
+}
+
+func TestLoadDir(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	copyFile(t, dir, "greeter@1.9.0.wasm", LEGACY_WASM)
+	copyFile(t, dir, "greeter@1.10.0.wasm", ABI_WASM)
+	copyFile(t, dir, "plain.wasm", ABI_WASM)
+	copyFile(t, dir, "broken@1.0.0.wasm", INVALID_WASM)
+
+	m := newManager(t, WithConcurrency(2))
+	err := m.LoadDir(ctx, dir)
+	var loadErr *LoadError
+	require.ErrorAs(t, err, &loadErr)
+	require.Len(t, loadErr.Errors, 1, "only the broken plugin fails")
+	require.Equal(t, "broken@1.0.0", loadErr.Errors[0].Plugin)
+
+	require.Equal(t, []Plugin{
+		{Name: "greeter", Version: "1.9.0", Path: filepath.Join(dir, "greeter@1.9.0.wasm")},
+		{Name: "greeter", Version: "1.10.0", Path: filepath.Join(dir, "greeter@1.10.0.wasm")},
+		{Name: "plain", Path: filepath.Join(dir, "plain.wasm")},
+	}, m.Plugins())
+	require.Equal(t, []string{"1.9.0", "1.10.0"}, m.Versions("greeter"))
+
+	echo(t, m, "greeter", "1.9.0")
+	echo(t, m, "plain", "")
+	latest, ok := m.Get("greeter", "")
+	require.True(t, ok)
+	newest, _ := m.Get("greeter", "1.10.0")
+	require.Same(t, newest, latest, "an empty version is the latest")
+	require.NoError(t, m.Load(ctx, Plugin{Name: "greeter", Version: "1.10.0-rc1", Path: LEGACY_WASM}))
+	latest, _ = m.Get("greeter", "")
+	require.Same(t, newest, latest, "a pre-release is older than the latest release")
+	_, ok = m.Get("broken", "1.0.0")
+	require.False(t, ok)
+
+	err = m.Load(ctx, Plugin{Name: "plain", Path: ABI_WASM}, Plugin{Path: ABI_WASM}, Plugin{Name: "nopath"})
+	require.ErrorAs(t, err, &loadErr)
+	require.Len(t, loadErr.Errors, 3)
+	require.ErrorContains(t, loadErr.Errors[0], "already loaded")
+	require.ErrorContains(t, loadErr.Errors[1], "name cannot be empty")
+	require.ErrorContains(t, loadErr.Errors[2], "path cannot be empty")
+}
+
+func TestUnloadReload(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := copyFile(t, dir, "greeter.wasm", ABI_WASM)
+
+	m := newManager(t)
+	require.NoError(t, m.LoadDir(ctx, dir))
+
+	copyFile(t, dir, "greeter.wasm", LEGACY_WASM)
+	require.NoError(t, m.Reload(ctx, "greeter", "", &runtime.SmokeTest{Operation: "noop"}))
+	echo(t, m, "greeter", "")
+
+	copyFile(t, dir, "greeter.wasm", INVALID_WASM)
+	var reloadErr *runtime.ReloadError
+	require.ErrorAs(t, m.Reload(ctx, "greeter", "", nil), &reloadErr)
+	require.Equal(t, path, reloadErr.Path)
+	echo(t, m, "greeter", "")
+
+	rt, _ := m.Get("greeter", "")
+	require.NoError(t, m.Unload(ctx, "greeter", ""))
+	_, ok := m.Get("greeter", "")
+	require.False(t, ok)
+	_, err := rt.Invoke(ctx, "echo", nil)
+	require.ErrorIs(t, err, runtime.ErrClosed, "the runtime is shut down")
+
+	require.ErrorIs(t, m.Unload(ctx, "greeter", ""), ErrNotFound)
+	require.ErrorIs(t, m.Reload(ctx, "greeter", "", nil), ErrNotFound)
+}
+
+func TestReloadVerified(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := copyFile(t, dir, "greeter.wasm", ABI_WASM)
+	hash := func(path string) runtime.FileOption {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		h, err := runtime.Sha256Hasher{}.Hash(data)
+		require.NoError(t, err)
+		return runtime.WithHash(h)
+	}
+	sha256 := runtime.WithHasher(runtime.Sha256Hasher{})
+
+	m := newManager(t)
+	require.NoError(t, m.Load(ctx, Plugin{Name: "greeter", Path: path, FileOptions: []runtime.FileOption{hash(ABI_WASM), sha256}}))
+
+	copyFile(t, dir, "greeter.wasm", LEGACY_WASM)
+	var reloadErr *runtime.ReloadError
+	require.ErrorAs(t, m.Reload(ctx, "greeter", "", nil), &reloadErr, "a verified plugin needs the options of the new file")
+	require.ErrorContains(t, m.Reload(ctx, "greeter", "", nil, hash(ABI_WASM), sha256), "hash does not match")
+	require.NoError(t, m.Reload(ctx, "greeter", "", nil, hash(LEGACY_WASM), sha256))
+	echo(t, m, "greeter", "")
+	require.Len(t, m.Plugins()[0].FileOptions, 2, "the options of the running file are kept")
+}
+
+func TestPool(t *testing.T) {
+	ctx := context.Background()
+	entered := make(chan struct{}, 2)
+	release := make(chan struct{})
+	m := New(WithRuntimeOptions(runtime.WithHostFns(runtime.HostFnByte("hello", func(ctx context.Context, payload []byte) ([]byte, error) {
+		entered <- struct{}{}
+		<-release
+		return reply(ctx, payload)
+	}))))
+	defer m.Close(ctx)
+	require.NoError(t, m.Load(ctx, Plugin{Name: "greeter", Path: ABI_WASM, PoolSize: 2}))
+
+	results := make(chan error, 2)
+	for range 2 {
+		go func() {
+			_, err := m.Invoke(ctx, "greeter", "", "echo", nil)
+			results <- err
+		}()
+	}
+	<-entered
+	<-entered // both instances run a call at once
+	close(release)
+	require.NoError(t, <-results)
+	require.NoError(t, <-results)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := m.Invoke(cancelled, "greeter", "", "echo", nil)
+	require.Error(t, err, "expected error for a cancelled context")
+	_, err = m.Invoke(ctx, "missing", "", "echo", nil)
+	require.ErrorIs(t, err, ErrNotFound)
+	require.ErrorContains(t, m.Load(ctx, Plugin{Name: "negative", Path: ABI_WASM, PoolSize: -1}), "pool size cannot be negative")
+
+	linker := runtime.NewLinker()
+	err = m.Load(ctx, Plugin{Name: "linked", Path: ABI_WASM, PoolSize: 2, Options: []runtime.Option{runtime.WithLinker(linker, "linked")}})
+	require.ErrorContains(t, err, "cannot be linked")
+	_, ok := linker.Plugin("linked")
+	require.False(t, ok, "the instance of the pool which failed should be unregistered")
+	err = m.Load(ctx, Plugin{Name: "recorded", Path: ABI_WASM, PoolSize: 2, Options: []runtime.Option{runtime.WithRecorder(runtime.NewRecorder(io.Discard))}})
+	require.ErrorContains(t, err, "cannot be recorded")
+	require.NoError(t, m.Load(ctx, Plugin{Name: "linked", Path: ABI_WASM, Options: []runtime.Option{runtime.WithLinker(linker, "linked")}}), "a single instance can be linked")
+}
+
+func TestPoolBatch(t *testing.T) {
+	ctx := context.Background()
+	entered := make(chan struct{}, 4)
+	release := make(chan struct{})
+	m := New(WithRuntimeOptions(runtime.WithHostFns(runtime.HostFnByte("hello", func(ctx context.Context, payload []byte) ([]byte, error) {
+		entered <- struct{}{}
+		<-release
+		return reply(ctx, payload)
+	}))))
+	defer m.Close(ctx)
+	require.NoError(t, m.Load(ctx, Plugin{Name: "greeter", Path: ABI_WASM, PoolSize: 2}))
+
+	done := make(chan []runtime.Result[[]byte])
+	go func() {
+		done <- m.InvokeBatch(ctx, "greeter", "", "echo", [][]byte{[]byte("a"), []byte("b"), []byte("c")})
+	}()
+	<-entered
+	<-entered // both instances run a part of the batch at once
+	close(release)
+	results := <-done
+	require.Len(t, results, 3)
+	for i, expected := range []string{"re: a", "re: b", "re: c"} {
+		require.NoError(t, results[i].Err)
+		require.Equal(t, expected, string(results[i].Value), "results should be in input order")
+	}
+
+	require.Empty(t, m.InvokeBatch(ctx, "greeter", "", "echo", nil))
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	for _, result := range m.InvokeBatch(cancelled, "greeter", "", "echo", [][]byte{nil, nil}) {
+		require.ErrorIs(t, result.Err, context.Canceled)
+	}
+	for _, result := range m.InvokeBatch(ctx, "missing", "", "echo", [][]byte{nil}) {
+		require.ErrorIs(t, result.Err, ErrNotFound)
+	}
+}
+
+func TestReloadRollback(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	copyFile(t, dir, "greeter.wasm", ABI_WASM)
+	m := New(WithRuntimeOptions(runtime.WithHostFns(runtime.HostFnByte("hello", reply))))
+	defer m.Close(ctx)
+	require.NoError(t, m.Load(ctx, Plugin{Name: "greeter", Path: filepath.Join(dir, "greeter.wasm"), PoolSize: 2}))
+	e := m.lookup("greeter", "")
+	running := e.pool.runtimes[0].File().Hash
+
+	copyFile(t, dir, "greeter.wasm", LEGACY_WASM)
+	smokes := 0
+	err := m.Reload(ctx, "greeter", "", &runtime.SmokeTest{Operation: "noop", Check: func([]byte) error {
+		if smokes++; smokes > 1 {
+			return errors.New("second instance fails")
+		}
+		return nil
+	}})
+	require.ErrorContains(t, err, "second instance fails")
+	for _, rt := range e.pool.runtimes {
+		require.Equal(t, running, rt.File().Hash, "every instance runs the previous version")
+	}
+	echo(t, m, "greeter", "")
+}
+
+func TestClose(t *testing.T) {
+	ctx := context.Background()
+	m := newManager(t)
+	require.NoError(t, m.Load(ctx, Plugin{Name: "greeter", Path: ABI_WASM}))
+	rt, _ := m.Get("greeter", "")
+
+	require.NoError(t, m.Close(ctx))
+	require.NoError(t, m.Close(ctx), "closing twice is allowed")
+	require.Empty(t, m.Plugins())
+	_, err := rt.Invoke(ctx, "echo", nil)
+	require.ErrorIs(t, err, runtime.ErrClosed)
+
+	err = m.Load(ctx, Plugin{Name: "greeter", Path: ABI_WASM})
+	require.ErrorIs(t, err, ErrClosed)
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.9.0", "1.10.0", -1},
+		{"v2.0", "1.99", 1},
+		{"1.0", "1.0.1", -1},
+		{"", "0.1", -1},
+		{"1.0.0-beta", "1.0.0-alpha", 1},
+		{"1.0.0-rc1", "1.0.0", -1},
+		{"v1.0.0", "1.0.0-rc1", 1},
+		{"1.0.0-rc.2", "1.0.0-rc.10", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-1", "1.0.0-alpha", -1},
+		{"1.0.0-rc1", "0.9.0", 1},
+		{"1.0.0+build.5", "1.0.0", 0},
+	} {
+		require.Equal(t, tc.want, CompareVersions(tc.a, tc.b), "%s <=> %s", tc.a, tc.b)
+	}
+}
@@ -0,0 +1,287 @@
+package manager
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mopeyjellyfish/hookr/runtime"
+	"gopkg.in/yaml.v3"
+)
+
+// pageSize is the size of a page of plugin memory.
+const pageSize = 64 << 10
+
+// Manifest lists the plugins to load, it is written in JSON or YAML:
+//
+//	plugins:
+//	  - name: greeter
+//	    version: 1.2.0
+//	    path: greeter.wasm
+//	    hash: e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855
+//	    capabilities: [hookr:http]
+//	    memory_limit: 16MiB
+//	    timeout: 2s
+//	    config:
+//	      greeting: hello
+//	    pool_size: 4
+type Manifest struct {
+	Plugins []ManifestPlugin `json:"plugins" yaml:"plugins"`
+}
+
+// ManifestPlugin describes a plugin in a Manifest, only the name and the path
+// are required.
+type ManifestPlugin struct {
+	Name    string `json:"name" yaml:"name"`
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+
+	// Path is the file of the plugin, relative to the manifest.
+	Path string `json:"path" yaml:"path"`
+
+	// Hash is the hex encoded SHA-256 hash the file must match.
+	Hash string `json:"hash,omitempty" yaml:"hash,omitempty"`
+
+	// Signature is the base64 encoded ed25519 signature of the file, verified
+	// with the base64 encoded PublicKey.
+	Signature string `json:"signature,omitempty" yaml:"signature,omitempty"`
+	PublicKey string `json:"public_key,omitempty" yaml:"public_key,omitempty"`
+
+	// Capabilities are the host functions the plugin may call, every host
+	// function when omitted, see runtime.WithAllowedHostFns.
+	Capabilities []string `json:"capabilities,omitempty" yaml:"capabilities,omitempty"`
+
+	// MemoryLimit limits the memory of the plugin, in bytes or with the unit
+	// KiB, MiB or GiB, "16MiB". It is rounded up to whole pages of 64 KiB.
+	MemoryLimit string `json:"memory_limit,omitempty" yaml:"memory_limit,omitempty"`
+
+	// Timeout bounds every call of the plugin, "2s", see runtime.WithCallTimeout.
+	Timeout string `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+
+	// Config is read by the plugin with pdk.Config.
+	Config map[string]string `json:"config,omitempty" yaml:"config,omitempty"`
+
+	// PoolSize is the number of instances of the plugin, see Plugin.PoolSize.
+	PoolSize int `json:"pool_size,omitempty" yaml:"pool_size,omitempty"`
+}
+
+// ManifestError is returned for an invalid manifest. Field is the path of the
+// offending field, "plugins[1].timeout", empty when the manifest could not be
+// parsed.
+type ManifestError struct {
+	File  string
+	Field string
+	Err   error
+}
+
+func (e *ManifestError) Error() string {
+	var b strings.Builder
+	b.WriteString("manifest")
+	if e.File != "" {
+		b.WriteString(" " + e.File)
+	}
+	if e.Field != "" {
+		b.WriteString(": " + e.Field)
+	}
+	b.WriteString(": " + e.Err.Error())
+	return b.String()
+}
+
+func (e *ManifestError) Unwrap() error {
+	return e.Err
+}
+
+// ParseManifest parses and validates a manifest written in JSON or YAML.
+// Unknown fields are rejected.
+func ParseManifest(data []byte) (*Manifest, error) {
+	var manifest Manifest
+	var err error
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&manifest)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err = dec.Decode(&manifest); errors.Is(err, io.EOF) {
+			err = nil // empty, reported by Validate
+		}
+	}
+	if err != nil {
+		return nil, &ManifestError{Err: fmt.Errorf("failed to parse: %w", err)}
+	}
+	if err := manifest.Validate(); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// Validate checks every field of the manifest, the error of the first invalid
+// field is returned as a *ManifestError.
+func (m *Manifest) Validate() error {
+	if len(m.Plugins) == 0 {
+		return &ManifestError{Field: "plugins", Err: errors.New("at least one plugin is required")}
+	}
+	seen := make(map[string]bool, len(m.Plugins))
+	for i, p := range m.Plugins {
+		plugin, err := p.Plugin("")
+		if err != nil {
+			var manifestErr *ManifestError
+			if errors.As(err, &manifestErr) {
+				manifestErr.Field = fmt.Sprintf("plugins[%d].%s", i, manifestErr.Field)
+			}
+			return err
+		}
+		if seen[plugin.String()] {
+			return &ManifestError{
+				Field: fmt.Sprintf("plugins[%d].name", i),
+				Err:   fmt.Errorf("plugin %q is listed twice", plugin.String()),
+			}
+		}
+		seen[plugin.String()] = true
+	}
+	return nil
+}
+
+// Plugin returns the plugin described, with the options of its fields. A
+// relative path is joined to dir. An invalid field is returned as a
+// *ManifestError.
+func (p ManifestPlugin) Plugin(dir string) (Plugin, error) {
+	fieldErr := func(field string, err error) (Plugin, error) {
+		return Plugin{}, &ManifestError{Field: field, Err: err}
+	}
+
+	switch {
+	case p.Name == "":
+		return fieldErr("name", errors.New("is required"))
+	case strings.ContainsAny(p.Name, VersionSep+`/\`):
+		return fieldErr("name", fmt.Errorf("%q cannot contain %q or path separators", p.Name, VersionSep))
+	case p.Path == "":
+		return fieldErr("path", errors.New("is required"))
+	case p.PoolSize < 0:
+		return fieldErr("pool_size", fmt.Errorf("%d cannot be negative", p.PoolSize))
+	}
+
+	path := p.Path
+	if dir != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	plugin := Plugin{Name: p.Name, Version: p.Version, Path: path, PoolSize: p.PoolSize}
+
+	if p.Hash != "" {
+		hash := strings.TrimPrefix(p.Hash, "sha256:")
+		if b, err := hex.DecodeString(hash); err != nil || len(b) != 32 {
+			return fieldErr("hash", errors.New("must be a hex encoded SHA-256 hash"))
+		}
+		plugin.FileOptions = append(plugin.FileOptions, runtime.WithHash(hash), runtime.WithHasher(runtime.Sha256Hasher{}))
+	}
+
+	if p.Signature != "" || p.PublicKey != "" {
+		if p.PublicKey == "" {
+			return fieldErr("public_key", errors.New("is required to verify the signature"))
+		}
+		if p.Signature == "" {
+			return fieldErr("signature", errors.New("is required with a public key"))
+		}
+		publicKey, err := base64.StdEncoding.DecodeString(p.PublicKey)
+		if err != nil || len(publicKey) != ed25519.PublicKeySize {
+			return fieldErr("public_key", fmt.Errorf("must be a base64 encoded ed25519 public key of %d bytes", ed25519.PublicKeySize))
+		}
+		signature, err := base64.StdEncoding.DecodeString(p.Signature)
+		if err != nil || len(signature) != ed25519.SignatureSize {
+			return fieldErr("signature", fmt.Errorf("must be a base64 encoded ed25519 signature of %d bytes", ed25519.SignatureSize))
+		}
+		plugin.FileOptions = append(plugin.FileOptions, runtime.WithSignature(publicKey, signature))
+	}
+
+	if p.Capabilities != nil {
+		for i, capability := range p.Capabilities {
+			if capability == "" {
+				return fieldErr(fmt.Sprintf("capabilities[%d]", i), errors.New("cannot be empty"))
+			}
+		}
+		plugin.Options = append(plugin.Options, runtime.WithAllowedHostFns(p.Capabilities...))
+	}
+
+	if p.MemoryLimit != "" {
+		size, err := parseSize(p.MemoryLimit)
+		if err != nil {
+			return fieldErr("memory_limit", err)
+		}
+		pages := size / pageSize
+		if size%pageSize != 0 {
+			pages++
+		}
+		if pages == 0 || pages > runtime.MaxMemoryPages {
+			return fieldErr("memory_limit", fmt.Errorf("%s must be between 64KiB and 4GiB", p.MemoryLimit))
+		}
+		plugin.Options = append(plugin.Options, runtime.WithMemoryLimitPages(uint32(pages)))
+	}
+
+	if p.Timeout != "" {
+		timeout, err := time.ParseDuration(p.Timeout)
+		if err != nil {
+			return fieldErr("timeout", fmt.Errorf("%q is not a duration, like \"2s\"", p.Timeout))
+		}
+		if timeout <= 0 {
+			return fieldErr("timeout", fmt.Errorf("%s must be positive", p.Timeout))
+		}
+		plugin.Options = append(plugin.Options, runtime.WithCallTimeout(timeout))
+	}
+
+	if p.Config != nil {
+		plugin.Options = append(plugin.Options, runtime.WithConfig(p.Config))
+	}
+	return plugin, nil
+}
+
+// parseSize parses a size in bytes, optionally with the unit KiB, MiB or GiB.
+func parseSize(s string) (uint64, error) {
+	number, unit := s, uint64(1)
+	for suffix, size := range map[string]uint64{"KiB": 1 << 10, "MiB": 1 << 20, "GiB": 1 << 30} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			number, unit = n, size
+			break
+		}
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(number), 10, 64)
+	if err != nil || n > math.MaxUint64/unit {
+		return 0, fmt.Errorf("%q is not a size, like \"16MiB\"", s)
+	}
+	return n * unit, nil
+}
+
+// ReadManifest returns the plugins listed in the manifest file, see
+// ParseManifest. Relative paths are relative to the manifest.
+func ReadManifest(path string) ([]Plugin, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	manifest, err := ParseManifest(data)
+	if err != nil {
+		var manifestErr *ManifestError
+		if errors.As(err, &manifestErr) {
+			manifestErr.File = path
+		}
+		return nil, err
+	}
+
+	plugins := make([]Plugin, len(manifest.Plugins))
+	for i, p := range manifest.Plugins {
+		if plugins[i], err = p.Plugin(filepath.Dir(path)); err != nil {
+			return nil, err // validated already
+		}
+	}
+	return plugins, nil
+}
@@ -0,0 +1,146 @@
+package manager
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mopeyjellyfish/hookr/runtime"
+	"github.com/stretchr/testify/require"
+)
+
+const manifestYAML = `
+plugins:
+  - name: greeter
+    version: 1.2.0
+    path: greeter.wasm
+    hash: %s
+    signature: %s
+    public_key: %s
+    capabilities: [hello]
+    memory_limit: 1MiB
+    timeout: 2s
+    config:
+      greeting: hi
+    pool_size: 2
+  - name: sandboxed
+    path: greeter.wasm
+    capabilities: []
+`
+
+func TestParseManifest(t *testing.T) {
+	yamlManifest, err := ParseManifest([]byte(`
+plugins:
+  - name: greeter
+    path: greeter.wasm
+    timeout: 2s
+    pool_size: 2
+`))
+	require.NoError(t, err)
+	jsonManifest, err := ParseManifest([]byte(`{"plugins": [{"name": "greeter", "path": "greeter.wasm", "timeout": "2s", "pool_size": 2}]}`))
+	require.NoError(t, err)
+	require.Equal(t, yamlManifest, jsonManifest)
+
+	plugin, err := yamlManifest.Plugins[0].Plugin("plugins")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join("plugins", "greeter.wasm"), plugin.Path)
+	require.Equal(t, 2, plugin.PoolSize)
+	require.Len(t, plugin.Options, 1)
+}
+
+func TestManifestErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		manifest string
+		err      string
+	}{
+		"Empty":        {manifest: ``, err: "manifest: plugins: at least one plugin is required"},
+		"YAMLSyntax":   {manifest: "plugins: [", err: "manifest: failed to parse"},
+		"JSONSyntax":   {manifest: `{"plugins": [`, err: "manifest: failed to parse"},
+		"UnknownYAML":  {manifest: "plugins:\n  - name: a\n    path: a.wasm\n    poolsize: 2\n", err: "line 4: field poolsize not found"},
+		"UnknownJSON":  {manifest: `{"plugins": [{"name": "a", "path": "a.wasm", "poolsize": 2}]}`, err: `unknown field "poolsize"`},
+		"Type":         {manifest: `{"plugins": [{"name": "a", "path": "a.wasm", "pool_size": "2"}]}`, err: "plugins.0.pool_size"},
+		"Name":         {manifest: `{"plugins": [{"path": "a.wasm"}]}`, err: "plugins[0].name: is required"},
+		"NameVersion":  {manifest: `{"plugins": [{"name": "a@1", "path": "a.wasm"}]}`, err: "plugins[0].name: \"a@1\" cannot contain"},
+		"Path":         {manifest: `{"plugins": [{"name": "a"}]}`, err: "plugins[0].path: is required"},
+		"Duplicate":    {manifest: `{"plugins": [{"name": "a", "path": "a.wasm"}, {"name": "a", "path": "b.wasm"}]}`, err: `plugins[1].name: plugin "a" is listed twice`},
+		"Hash":         {manifest: `{"plugins": [{"name": "a", "path": "a.wasm", "hash": "abc"}]}`, err: "plugins[0].hash: must be a hex encoded SHA-256 hash"},
+		"PublicKey":    {manifest: `{"plugins": [{"name": "a", "path": "a.wasm", "signature": "c2ln"}]}`, err: "plugins[0].public_key: is required"},
+		"Signature":    {manifest: `{"plugins": [{"name": "a", "path": "a.wasm", "public_key": "a2V5"}]}`, err: "plugins[0].signature: is required"},
+		"KeySize":      {manifest: `{"plugins": [{"name": "a", "path": "a.wasm", "public_key": "a2V5", "signature": "c2ln"}]}`, err: "plugins[0].public_key: must be a base64 encoded ed25519 public key"},
+		"Capability":   {manifest: `{"plugins": [{"name": "a", "path": "a.wasm", "capabilities": ["hello", ""]}]}`, err: "plugins[0].capabilities[1]: cannot be empty"},
+		"MemoryLimit":  {manifest: `{"plugins": [{"name": "a", "path": "a.wasm", "memory_limit": "16MB"}]}`, err: `plugins[0].memory_limit: "16MB" is not a size`},
+		"MemoryTooBig": {manifest: `{"plugins": [{"name": "a", "path": "a.wasm", "memory_limit": "5GiB"}]}`, err: "plugins[0].memory_limit: 5GiB must be between 64KiB and 4GiB"},
+		"Timeout":      {manifest: `{"plugins": [{"name": "a", "path": "a.wasm", "timeout": "2"}]}`, err: `plugins[0].timeout: "2" is not a duration`},
+		"NoTimeout":    {manifest: `{"plugins": [{"name": "a", "path": "a.wasm", "timeout": "0s"}]}`, err: "plugins[0].timeout: 0s must be positive"},
+		"PoolSize":     {manifest: `{"plugins": [{"name": "a", "path": "a.wasm", "pool_size": -1}]}`, err: "plugins[0].pool_size: -1 cannot be negative"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseManifest([]byte(tc.manifest))
+			var manifestErr *ManifestError
+			require.ErrorAs(t, err, &manifestErr)
+			require.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for s, want := range map[string]uint64{"1024": 1024, "64KiB": 64 << 10, "16MiB": 16 << 20, "1GiB": 1 << 30} {
+		size, err := parseSize(s)
+		require.NoError(t, err)
+		require.Equal(t, want, size, s)
+	}
+	_, err := parseSize("99999999999GiB")
+	require.Error(t, err, "expected error for an overflowing size")
+}
+
+func TestLoadManifest(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	copyFile(t, dir, "greeter.wasm", ABI_WASM)
+	data, err := os.ReadFile(ABI_WASM)
+	require.NoError(t, err)
+	hash, err := runtime.Sha256Hasher{}.Hash(data)
+	require.NoError(t, err)
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, data))
+
+	manifest := filepath.Join(dir, "plugins.yaml")
+	writeManifest := func(hash, signature string) {
+		content := []byte(fmt.Sprintf(manifestYAML, hash, signature, base64.StdEncoding.EncodeToString(publicKey)))
+		require.NoError(t, os.WriteFile(manifest, content, 0o600))
+	}
+	writeManifest(hash, signature)
+
+	m := newManager(t)
+	require.NoError(t, m.LoadManifest(ctx, manifest))
+	out, err := m.Invoke(ctx, "greeter", "1.2.0", "echo", []byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("re: a"), out)
+	_, err = m.Invoke(ctx, "sandboxed", "", "echo", []byte("a"))
+	require.ErrorContains(t, err, "host function not granted", "an empty list of capabilities grants nothing")
+
+	for name, tc := range map[string]struct {
+		hash, signature, err string
+	}{
+		"Hash":      {hash: "0000000000000000000000000000000000000000000000000000000000000000", signature: signature, err: "hash does not match"},
+		"Signature": {hash: hash, signature: base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, []byte("other"))), err: "signature does not match"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			writeManifest(tc.hash, tc.signature)
+			err := newManager(t).LoadManifest(ctx, manifest)
+			var loadErr *LoadError
+			require.ErrorAs(t, err, &loadErr)
+			require.ErrorContains(t, loadErr.Errors[0], tc.err)
+		})
+	}
+
+	require.NoError(t, os.WriteFile(manifest, []byte("plugins: []"), 0o600))
+	_, err = ReadManifest(manifest)
+	require.ErrorContains(t, err, "manifest "+manifest+": plugins: at least one plugin is required")
+}
@@ -0,0 +1,159 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/mopeyjellyfish/hookr/runtime"
+)
+
+// pool spreads the calls of a plugin over instances created with the same
+// options, a runtime only runs one call at a time.
+type pool struct {
+	runtimes []*runtime.Runtime
+	idle     chan *runtime.Runtime
+}
+
+// newPool creates size runtimes with the options.
+func newPool(ctx context.Context, size int, opts []runtime.Option) (*pool, error) {
+	p := &pool{idle: make(chan *runtime.Runtime, size)}
+	for range size {
+		rt, err := runtime.New(ctx, opts...)
+		if err == nil && size > 1 {
+			if err = shareable(rt); err != nil {
+				_ = rt.Close(ctx)
+			}
+		}
+		if err != nil {
+			_ = p.close(ctx)
+			return nil, err
+		}
+		p.runtimes = append(p.runtimes, rt)
+		p.idle <- rt
+	}
+	return p, nil
+}
+
+// shareable returns an error when the options of the runtime cannot be shared
+// by the instances of a pool: a name can only be registered once with a
+// runtime.Linker, and the instances would interleave their recordings in a
+// shared runtime.Recorder.
+func shareable(rt *runtime.Runtime) error {
+	if linker, name := rt.Linker(); linker != nil {
+		return fmt.Errorf("a pool of more than one instance cannot be linked, %q can only be registered once with the linker", name)
+	}
+	if rt.Recorder() != nil {
+		return errors.New("a pool of more than one instance cannot be recorded, the instances would share the recorder")
+	}
+	return nil
+}
+
+// acquire takes an idle instance, waiting for one until ctx is done. The
+// instance must be given back with release.
+func (p *pool) acquire(ctx context.Context) (*runtime.Runtime, error) {
+	select {
+	case rt := <-p.idle:
+		return rt, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// release gives an instance taken with acquire back to the pool.
+func (p *pool) release(rt *runtime.Runtime) {
+	p.idle <- rt
+}
+
+// invoke calls the plugin function on an idle instance, waiting for one until
+// ctx is done.
+func (p *pool) invoke(ctx context.Context, operation string, payload []byte) ([]byte, error) {
+	rt, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer p.release(rt)
+	return rt.Invoke(ctx, operation, payload)
+}
+
+// invokeBatch splits the payloads into a part per instance, each part is
+// invoked as a batch on an idle instance concurrently with the others. The
+// results are in the order of the payloads.
+func (p *pool) invokeBatch(ctx context.Context, operation string, payloads [][]byte) []runtime.Result[[]byte] {
+	results := make([]runtime.Result[[]byte], len(payloads))
+	size := (len(payloads) + len(p.runtimes) - 1) / len(p.runtimes)
+	var wg sync.WaitGroup
+	for start := 0; start < len(payloads); start += size {
+		part := results[start:min(start+size, len(payloads))]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rt, err := p.acquire(ctx)
+			if err != nil {
+				for i := range part {
+					part[i].Err = err
+				}
+				return
+			}
+			defer p.release(rt)
+			copy(part, rt.InvokeBatch(ctx, operation, payloads[start:start+len(part)]))
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// reload reloads the instances one by one. When one fails to reload, those
+// already reloaded are rolled back to the file they ran, so every instance
+// keeps running the same version.
+func (p *pool) reload(ctx context.Context, file *runtime.File, smoke *runtime.SmokeTest) error {
+	prev := make([]*runtime.File, 0, len(p.runtimes))
+	for _, rt := range p.runtimes {
+		running := rt.File()
+		if err := rt.Reload(ctx, file, smoke); err != nil {
+			return errors.Join(err, p.rollback(context.WithoutCancel(ctx), prev))
+		}
+		prev = append(prev, running)
+	}
+	return nil
+}
+
+// rollback reloads the first instances with the files they ran before.
+func (p *pool) rollback(ctx context.Context, prev []*runtime.File) error {
+	var errs []error
+	for i, file := range prev {
+		if err := p.runtimes[i].Reload(ctx, file, nil); err != nil {
+			errs = append(errs, fmt.Errorf("failed to roll back instance %d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// shutdown shuts the instances down concurrently, see runtime.Runtime.Shutdown.
+func (p *pool) shutdown(ctx context.Context) error {
+	return p.each(func(rt *runtime.Runtime) error {
+		return rt.Shutdown(ctx)
+	})
+}
+
+// close closes the instances, see runtime.Runtime.Close.
+func (p *pool) close(ctx context.Context) error {
+	return p.each(func(rt *runtime.Runtime) error {
+		return rt.Close(ctx)
+	})
+}
+
+func (p *pool) each(fn func(rt *runtime.Runtime) error) error {
+	errs := make([]error, len(p.runtimes))
+	var wg sync.WaitGroup
+	for i, rt := range p.runtimes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(rt)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
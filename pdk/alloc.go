@@ -0,0 +1,45 @@
+package pdk
+
+// invokeFailed is returned by functions returning a packed pointer and length
+// when the call failed.
+const invokeFailed = ^uint64(0)
+
+var (
+	// pinned keeps the buffers allocated for the host alive until they are
+	// taken by the plugin or freed by the host.
+	pinned = map[uintptr][]byte{}
+
+	// lastResponse keeps the response of the last call alive until the host
+	// has read it.
+	lastResponse []byte
+)
+
+// hookrAlloc allocates a buffer of size bytes the host writes into.
+//
+//go:export hookr_alloc
+func hookrAlloc(size uint32) uintptr {
+	buf := make([]byte, size) // alloc
+	ptr := bytesToPointer(buf)
+	pinned[ptr] = buf
+	return ptr
+}
+
+// hookrFree releases a buffer allocated with hookrAlloc.
+//
+//go:export hookr_free
+func hookrFree(ptr uintptr, _ uint32) {
+	delete(pinned, ptr)
+}
+
+// take returns the buffer allocated with hookrAlloc at ptr, which is then
+// owned by the plugin.
+func take(ptr uintptr, size uint32) []byte {
+	buf := pinned[ptr]
+	delete(pinned, ptr)
+	return buf[:size:size]
+}
+
+// pack packs the pointer and length of b for the host.
+func pack(b []byte) uint64 {
+	return uint64(bytesToPointer(b))<<32 | uint64(len(b))
+}
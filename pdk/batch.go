@@ -0,0 +1,57 @@
+package pdk
+
+import (
+	"github.com/tinylib/msgp/msgp"
+)
+
+// pluginBatch lets the host call a function for many payloads in a single call.
+// The payload is a msgpack array of binary payloads and the response a msgpack
+// array of [error, result] pairs in the same order, the error is nil for the
+// items which succeeded.
+//
+//go:export __plugin_batch
+func pluginBatch(operationSize uint32, payloadSize uint32) bool {
+	operation := make([]byte, operationSize) // alloc
+	payload := make([]byte, payloadSize)     // alloc
+	pluginRequest(bytesToPointer(operation), bytesToPointer(payload))
+
+	f, ok := allFns[string(operation)]
+	if !ok {
+		message := `Could not find function "` + string(operation) + `"`
+		pluginError(stringToPointer(message), uint32(len(message)))
+
+		return false
+	}
+
+	count, payload, err := msgp.ReadArrayHeaderBytes(payload)
+	if err != nil {
+		message := "invalid batch: " + err.Error()
+		pluginError(stringToPointer(message), uint32(len(message)))
+
+		return false
+	}
+
+	response := msgp.AppendArrayHeader(nil, count)
+	for ; count > 0; count-- {
+		var item []byte
+		if item, payload, err = msgp.ReadBytesZC(payload); err != nil {
+			message := "invalid batch: " + err.Error()
+			pluginError(stringToPointer(message), uint32(len(message)))
+
+			return false
+		}
+
+		response = msgp.AppendArrayHeader(response, 2)
+		if out, err := f(item); err != nil {
+			response = msgp.AppendString(response, err.Error())
+			response = msgp.AppendBytes(response, nil)
+		} else {
+			response = msgp.AppendNil(response)
+			response = msgp.AppendBytes(response, out)
+		}
+	}
+
+	pluginResponse(bytesToPointer(response), uint32(len(response)))
+
+	return true
+}
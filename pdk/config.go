@@ -0,0 +1,20 @@
+package pdk
+
+import "errors"
+
+// ConfigFn is the host function which returns the configuration of the plugin.
+const ConfigFn = "hookr_config"
+
+// ErrConfigNotSet is matched by the error of Config for keys without a value.
+var ErrConfigNotSet = errors.New("config not set")
+
+// Config returns the value of the key in the configuration the host loaded the
+// plugin with. The error matches ErrConfigNotSet when the key is not set, any
+// other error is a failure of the host call.
+func Config(key string) (string, error) {
+	value, err := HostCall(ConfigFn, []byte(key))
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
@@ -67,6 +67,29 @@ respectively. The easiest way is to use structs with msgp tags:
 		Message string `msg:"message"`
 	}
 
+# Streaming Functions
+
+Functions registered with FnStream read their input and write their output in
+chunks, which lets a plugin transform payloads larger than its memory:
+
+	pdk.FnStream("upper", func(r io.Reader, w io.Writer) error {
+		buf := make([]byte, 4096)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				if _, err := w.Write(bytes.ToUpper(buf[:n])); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	})
+
 # Calling Host Functions
 
 Plugins can call back into the host using the HostFn function:
@@ -87,6 +110,88 @@ Plugins can call back into the host using the HostFn function:
 		}, nil
 	}
 
+# Hooks
+
+Plugins declare the events they handle with Hook or HookSerial, the host
+dispatches each event to every plugin handling it (see the hooks package):
+
+	pdk.Hook("user.created", func(payload []byte) ([]byte, error) {
+		if isBlocked(payload) {
+			return nil, pdk.Veto("user is blocked")
+		}
+		return payload, nil
+	})
+
+Returning Veto from a handler stops a chained event from reaching later handlers.
+
+# Outbound HTTP
+
+Plugins have no sockets, but can ask the host to perform HTTP requests when the
+host registers the runtime/httphost module:
+
+	resp, err := (&pdk.HTTPRequest{
+		Method: "GET",
+		URL:    "https://api.internal/v1/users/42",
+	}).Send()
+	if err != nil {
+		return nil, err
+	}
+
+The host decides which URLs and methods are allowed and may redact headers.
+
+# Lifecycle
+
+Plugins can flush state when the host closes them and report their health:
+
+	//go:wasmexport hookr_init
+	func Initialize() {
+		pdk.OnShutdown(flush)
+		pdk.OnHealth(func() error {
+			if !connected {
+				return errors.New("not connected")
+			}
+			return nil
+		})
+	}
+
+A plugin which cannot start, for example because configuration is missing,
+reports it with InitError and the host refuses to load it:
+
+	//go:wasmexport hookr_init
+	func Initialize() {
+		if err := loadConfig(); err != nil {
+			pdk.InitError(err)
+			return
+		}
+		pdk.FnSerial("hello", Hello)
+	}
+
+When the host reloads a plugin, the state of the running version can be handed
+to the new one. The version tags the format of the state, an import which
+returns an error rejects it and the host keeps running the previous version:
+
+	pdk.OnExportState("v1", func() ([]byte, error) {
+		return counter.MarshalMsg(nil)
+	})
+	pdk.OnImportState(func(version string, state []byte) error {
+		if version != "v1" {
+			return fmt.Errorf("unsupported state %s", version)
+		}
+		_, err := counter.UnmarshalMsg(state)
+		return err
+	})
+
+# Configuration
+
+Config returns the configuration values the host loaded the plugin with:
+
+	region, err := pdk.Config("region")
+	if errors.Is(err, pdk.ErrConfigNotSet) {
+		region = "eu-west-1"
+	} else if err != nil {
+		pdk.InitError(err)
+	}
+
 # Logging
 
 The PDK provides a logging function that sends messages to the host:
@@ -101,6 +206,13 @@ The PDK provides a logging function that sends messages to the host:
 		}, nil
 	}
 
+# Calling Other Plugins
+
+A plugin can call the functions of other plugins loaded by the same host, when
+the host linked them:
+
+	resp, err := pdk.PluginCall("auth", "verify", token)
+
 # Error Handling
 
 Errors returned from plugin functions are properly propagated to the host:
@@ -115,6 +227,15 @@ Errors returned from plugin functions are properly propagated to the host:
 		}, nil
 	}
 
+Host calls the host refused because the plugin exceeded a rate limit or quota
+fail with a *QuotaError naming the exceeded Limit, the plugin can back off or
+give up:
+
+	resp, err := pdk.HostCall("db.query", query)
+	if errors.Is(err, pdk.ErrQuotaExceeded) {
+		return nil, err
+	}
+
 # Building Plugins
 
 To build a plugin for use with Hookr, you typically use TinyGo:
@@ -122,5 +243,20 @@ To build a plugin for use with Hookr, you typically use TinyGo:
 	tinygo build -o plugin.wasm -scheduler=none --no-debug -target=wasi main.go
 
 This produces a WebAssembly module that can be loaded by a Hookr host application.
+
+# Testing Plugins
+
+Built natively, host calls and logs go to the host set with SetNativeHost. The
+pdktest package provides one, so plugin functions can be tested with go test:
+
+	func TestEcho(t *testing.T) {
+		host := pdktest.New(t)
+		host.Handle("hello", func(payload []byte) ([]byte, error) {
+			return (&HelloResponse{Message: "Hello, Hookr!"}).MarshalMsg(nil)
+		})
+		Initialize()
+
+		out, err := host.Invoke("echo", payload)
+	}
 */
 package pdk
@@ -0,0 +1,63 @@
+package pdk
+
+import (
+	"github.com/tinylib/msgp/msgp"
+)
+
+// HooksFn is the name of the function which returns the hooks a plugin handles as a msgpack array of strings.
+// It is registered automatically once a hook is added with Hook or HookSerial.
+const HooksFn = "hookr_hooks"
+
+// codeVeto is the error code of a VetoError, the host returns it as a runtime.VetoError.
+const codeVeto = 1
+
+// VetoError is the error returned by Veto.
+type VetoError struct {
+	Reason string
+}
+
+func (e *VetoError) Error() string {
+	return "vetoed: " + e.Reason
+}
+
+var allHooks []string
+
+// Hook registers fn as the handler of the named hook. The host dispatches events
+// for the hook to every plugin which handles it.
+// This should be invoked in your initialize func.
+func Hook(name string, fn Function) {
+	FnByte(name, fn)
+	addHook(name)
+}
+
+// HookSerial registers fn as the handler of the named hook, see Hook.
+// This will invoke the Marshal and Unmarshal functions on the input and output types.
+func HookSerial[In PtrUnmarshaler[T], Out Marshaler, T any](name string, fn PluginFunction[In, Out]) {
+	FnSerial(name, fn)
+	addHook(name)
+}
+
+// Veto returns an error which, when returned from a hook handler in a chain,
+// stops the event from reaching any further handlers. The host receives it as
+// a runtime.VetoError with the reason.
+func Veto(reason string) error {
+	return &VetoError{Reason: reason}
+}
+
+func addHook(name string) {
+	for _, hook := range allHooks {
+		if hook == name {
+			return
+		}
+	}
+	allHooks = append(allHooks, name)
+	allFns[HooksFn] = listHooks
+}
+
+func listHooks([]byte) ([]byte, error) {
+	b := msgp.AppendArrayHeader(nil, uint32(len(allHooks)))
+	for _, hook := range allHooks {
+		b = msgp.AppendString(b, hook)
+	}
+	return b, nil
+}
@@ -0,0 +1,55 @@
+//go:build wasip1
+
+package pdk
+
+import (
+	"io"
+	"unsafe"
+)
+
+// hostScratch receives host responses which fit, larger ones are written into
+// a buffer allocated with hookr_alloc.
+var hostScratch [4096]byte
+
+// callHost calls the operation on the host through the hookr host module, the
+// host writes the response within the same call.
+func callHost(operation string, payload []byte) ([]byte, error) {
+	scratch := uintptr(unsafe.Pointer(&hostScratch[0]))
+	result := hostInvoke(
+		stringToPointer(operation), uint32(len(operation)),
+		bytesToPointer(payload), uint32(len(payload)),
+		scratch, uint32(len(hostScratch)),
+	)
+	if result == invokeFailed {
+		return nil, lastHostError(operation)
+	}
+
+	ptr, size := uintptr(result>>32), uint32(result)
+	if ptr == scratch {
+		return append([]byte(nil), hostScratch[:size]...), nil // alloc
+	}
+	return take(ptr, size), nil
+}
+
+// hostLog passes the message to the logger of the host.
+func hostLog(message string) {
+	consoleLog(stringToPointer(message), uint32(len(message)))
+}
+
+// readStream reads the input of the streaming invocation from the host.
+func readStream(p []byte) (int, error) {
+	n := streamRead(bytesToPointer(p), uint32(len(p)))
+	switch {
+	case n < 0:
+		return 0, lastHostError("")
+	case n == 0:
+		return 0, io.EOF
+	}
+	return int(n), nil
+}
+
+// writeStream writes the output of the streaming invocation to the host,
+// returning false when the host no longer accepts it.
+func writeStream(p []byte) bool {
+	return streamWrite(bytesToPointer(p), uint32(len(p)))
+}
@@ -0,0 +1,117 @@
+//go:build !wasip1
+
+package pdk
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// NativeHost stands in for the host when a plugin is built natively instead of
+// for WebAssembly, so its functions can be tested with go test. See the pdktest
+// package for an implementation.
+type NativeHost interface {
+	// HostCall answers a call of a host function made with HostCall.
+	HostCall(operation string, payload []byte) ([]byte, error)
+	// Log receives the messages logged with Log.
+	Log(message string)
+}
+
+// NativeStreamHost is a NativeHost which also serves the input and output of
+// the streaming functions called with InvokeStream.
+type NativeStreamHost interface {
+	NativeHost
+	// StreamRead reads the input of the streaming invocation, io.EOF at its end.
+	StreamRead(p []byte) (int, error)
+	// StreamWrite writes the output of the streaming invocation.
+	StreamWrite(p []byte) (int, error)
+}
+
+var (
+	nativeMu   sync.RWMutex
+	nativeHost NativeHost
+)
+
+// SetNativeHost sets the host answering host calls and receiving logs of a
+// natively built plugin and returns the previous one. Without a host, host
+// calls fail and logs are discarded.
+func SetNativeHost(host NativeHost) NativeHost {
+	nativeMu.Lock()
+	defer nativeMu.Unlock()
+	previous := nativeHost
+	nativeHost = host
+	return previous
+}
+
+// Invoke calls the function registered as operation, as the host would, in a
+// natively built plugin.
+func Invoke(operation string, payload []byte) ([]byte, error) {
+	return callFunction(operation, payload)
+}
+
+// InvokeStream calls the streaming function registered as operation, as the
+// host would, in a natively built plugin. Its input and output are served by
+// the native host, which must implement NativeStreamHost.
+func InvokeStream(operation string) error {
+	f, ok := allStreamFns[operation]
+	if !ok {
+		return errors.New(`Could not find function "` + operation + `"`)
+	}
+	return f(streamReader{}, streamWriter{})
+}
+
+func currentHost() NativeHost {
+	nativeMu.RLock()
+	defer nativeMu.RUnlock()
+	return nativeHost
+}
+
+func callHost(operation string, payload []byte) ([]byte, error) {
+	host := currentHost()
+	if host == nil {
+		return nil, &HostError{message: "no host"}
+	}
+	response, err := host.HostCall(operation, payload)
+	if err != nil {
+		return nil, newHostError(errorCode(err), operation, err.Error())
+	}
+	return response, nil
+}
+
+// errorCode returns the code of an error of the native host, which carries it
+// like the errors of the host module.
+func errorCode(err error) uint32 {
+	var coded interface{ ErrorCode() uint32 }
+	if errors.As(err, &coded) {
+		return coded.ErrorCode()
+	}
+	return 0
+}
+
+func hostLog(message string) {
+	if host := currentHost(); host != nil {
+		host.Log(message)
+	}
+}
+
+func readStream(p []byte) (int, error) {
+	host, ok := currentHost().(NativeStreamHost)
+	if !ok {
+		return 0, &HostError{message: "no stream host"}
+	}
+	n, err := host.StreamRead(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return n, newHostError(errorCode(err), "", err.Error())
+	}
+	return n, err
+}
+
+func writeStream(p []byte) bool {
+	host, ok := currentHost().(NativeStreamHost)
+	if !ok {
+		return false
+	}
+	_, err := host.StreamWrite(p)
+	return err == nil
+}
@@ -0,0 +1,170 @@
+package pdk
+
+import (
+	"github.com/tinylib/msgp/msgp"
+)
+
+// HTTPHostFn is the name of the host function which performs outbound HTTP
+// requests on behalf of a plugin. The host must register an HTTP host module
+// (see the runtime/httphost package) for requests to succeed.
+const HTTPHostFn = "hookr:http"
+
+type (
+	// HTTPRequest is an outbound HTTP request performed by the host on behalf of the plugin.
+	HTTPRequest struct {
+		Method  string
+		URL     string
+		Headers map[string][]string
+		Body    []byte
+	}
+
+	// HTTPResponse is the response to an HTTPRequest as returned by the host.
+	HTTPResponse struct {
+		StatusCode int
+		Headers    map[string][]string
+		Body       []byte
+	}
+)
+
+var httpHost = HostFnSerial[*HTTPRequest, *HTTPResponse](HTTPHostFn)
+
+// Send asks the host to perform the request and returns the response.
+// The host decides which URLs, hosts and methods a plugin may use, an error
+// is returned when the request is not allowed.
+func (r *HTTPRequest) Send() (*HTTPResponse, error) {
+	return httpHost.Call(r)
+}
+
+// MarshalMsg implements msgp.Marshaler
+func (z *HTTPRequest) MarshalMsg(b []byte) (o []byte, err error) {
+	o = msgp.AppendMapHeader(b, 4)
+	o = msgp.AppendString(o, "method")
+	o = msgp.AppendString(o, z.Method)
+	o = msgp.AppendString(o, "url")
+	o = msgp.AppendString(o, z.URL)
+	o = msgp.AppendString(o, "headers")
+	o = appendHeaders(o, z.Headers)
+	o = msgp.AppendString(o, "body")
+	o = msgp.AppendBytes(o, z.Body)
+	return
+}
+
+// UnmarshalMsg implements msgp.Unmarshaler
+func (z *HTTPRequest) UnmarshalMsg(bts []byte) (o []byte, err error) {
+	var field []byte
+	var size uint32
+	size, bts, err = msgp.ReadMapHeaderBytes(bts)
+	if err != nil {
+		return nil, msgp.WrapError(err)
+	}
+	for ; size > 0; size-- {
+		field, bts, err = msgp.ReadMapKeyZC(bts)
+		if err != nil {
+			return nil, msgp.WrapError(err)
+		}
+		switch msgp.UnsafeString(field) {
+		case "method":
+			z.Method, bts, err = msgp.ReadStringBytes(bts)
+		case "url":
+			z.URL, bts, err = msgp.ReadStringBytes(bts)
+		case "headers":
+			z.Headers, bts, err = readHeaders(bts)
+		case "body":
+			z.Body, bts, err = msgp.ReadBytesBytes(bts, z.Body)
+		default:
+			bts, err = msgp.Skip(bts)
+		}
+		if err != nil {
+			return nil, msgp.WrapError(err, string(field))
+		}
+	}
+	return bts, nil
+}
+
+// MarshalMsg implements msgp.Marshaler
+func (z *HTTPResponse) MarshalMsg(b []byte) (o []byte, err error) {
+	o = msgp.AppendMapHeader(b, 3)
+	o = msgp.AppendString(o, "status")
+	o = msgp.AppendInt(o, z.StatusCode)
+	o = msgp.AppendString(o, "headers")
+	o = appendHeaders(o, z.Headers)
+	o = msgp.AppendString(o, "body")
+	o = msgp.AppendBytes(o, z.Body)
+	return
+}
+
+// UnmarshalMsg implements msgp.Unmarshaler
+func (z *HTTPResponse) UnmarshalMsg(bts []byte) (o []byte, err error) {
+	var field []byte
+	var size uint32
+	size, bts, err = msgp.ReadMapHeaderBytes(bts)
+	if err != nil {
+		return nil, msgp.WrapError(err)
+	}
+	for ; size > 0; size-- {
+		field, bts, err = msgp.ReadMapKeyZC(bts)
+		if err != nil {
+			return nil, msgp.WrapError(err)
+		}
+		switch msgp.UnsafeString(field) {
+		case "status":
+			z.StatusCode, bts, err = msgp.ReadIntBytes(bts)
+		case "headers":
+			z.Headers, bts, err = readHeaders(bts)
+		case "body":
+			z.Body, bts, err = msgp.ReadBytesBytes(bts, z.Body)
+		default:
+			bts, err = msgp.Skip(bts)
+		}
+		if err != nil {
+			return nil, msgp.WrapError(err, string(field))
+		}
+	}
+	return bts, nil
+}
+
+func appendHeaders(b []byte, headers map[string][]string) []byte {
+	b = msgp.AppendMapHeader(b, uint32(len(headers))) // #nosec G115 -- header counts are small
+	for name, values := range headers {
+		b = msgp.AppendString(b, name)
+		b = msgp.AppendArrayHeader(b, uint32(len(values))) // #nosec G115 -- header counts are small
+		for _, v := range values {
+			b = msgp.AppendString(b, v)
+		}
+	}
+	return b
+}
+
+// readHeaders reads the headers, the counts are checked against the bytes left
+// before allocating as every name and value takes at least one.
+func readHeaders(bts []byte) (map[string][]string, []byte, error) {
+	size, bts, err := msgp.ReadMapHeaderBytes(bts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if uint64(size) > uint64(len(bts)) {
+		return nil, nil, msgp.ErrShortBytes
+	}
+	headers := make(map[string][]string, size)
+	for ; size > 0; size-- {
+		var name string
+		var count uint32
+		if name, bts, err = msgp.ReadStringBytes(bts); err != nil {
+			return nil, nil, err
+		}
+		if count, bts, err = msgp.ReadArrayHeaderBytes(bts); err != nil {
+			return nil, nil, err
+		}
+		if uint64(count) > uint64(len(bts)) {
+			return nil, nil, msgp.ErrShortBytes
+		}
+		values := make([]string, count)
+		for i := range values {
+			if values[i], bts, err = msgp.ReadStringBytes(bts); err != nil {
+				return nil, nil, err
+			}
+		}
+		headers[name] = values
+	}
+	return headers, bts, nil
+}
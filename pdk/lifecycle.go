@@ -0,0 +1,49 @@
+package pdk
+
+var (
+	shutdownFn func()
+	healthFn   func() error
+)
+
+// OnShutdown registers fn to be called when the host closes the plugin, giving it a chance to flush any state.
+// The host only waits for a limited time before the plugin is closed regardless.
+func OnShutdown(fn func()) {
+	shutdownFn = fn
+}
+
+// OnHealth registers fn to report the health of the plugin to the host.
+// A plugin without a health function is always healthy.
+func OnHealth(fn func() error) {
+	healthFn = fn
+}
+
+// InitError reports that the plugin failed to initialize, the host refuses to load the plugin and returns the error.
+// It should be called from your initialize func, for example when required configuration is missing.
+func InitError(err error) {
+	if err == nil {
+		return
+	}
+	message := err.Error()
+	pluginError(stringToPointer(message), uint32(len(message)))
+}
+
+//go:export hookr_shutdown
+func shutdown() {
+	if shutdownFn != nil {
+		shutdownFn()
+	}
+}
+
+//go:export hookr_health
+func health() bool {
+	if healthFn == nil {
+		return true
+	}
+	if err := healthFn(); err != nil {
+		message := err.Error()
+		pluginError(stringToPointer(message), uint32(len(message)))
+
+		return false
+	}
+	return true
+}
@@ -0,0 +1,17 @@
+package pdk
+
+import "github.com/tinylib/msgp/msgp"
+
+// PluginCallFn is the host function which routes calls to other plugins.
+const PluginCallFn = "hookr_plugin_call"
+
+// PluginCall calls the operation of another plugin loaded by the host. The host
+// only routes the call when this plugin is linked to the other plugin and the
+// link allows the operation.
+func PluginCall(plugin, operation string, payload []byte) ([]byte, error) {
+	b := msgp.AppendArrayHeader(nil, 3)
+	b = msgp.AppendString(b, plugin)
+	b = msgp.AppendString(b, operation)
+	b = msgp.AppendBytes(b, payload)
+	return HostCall(PluginCallFn, b)
+}
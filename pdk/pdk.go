@@ -1,8 +1,9 @@
 package pdk
 
 import (
+	"errors"
 	"fmt"
-	"reflect"
+	"io"
 	"unsafe"
 )
 
@@ -13,6 +14,20 @@ type Unmarshaler interface {
 	UnmarshalMsg([]byte) ([]byte, error)
 }
 
+// PtrUnmarshaler is an Unmarshaler which is a pointer to T, so a value to
+// unmarshal into can be created with new(T) instead of reflection.
+type PtrUnmarshaler[T any] interface {
+	*T
+	Unmarshaler
+}
+
+// PtrMarshaler is a Marshaler which is a pointer to T, so a nil input can be
+// told apart without reflection.
+type PtrMarshaler[T any] interface {
+	*T
+	Marshaler
+}
+
 type (
 	// PluginFunction is a function that takes an input of type In and returns an output of type Out.
 	// These are the concrete functions that are callable by the host.
@@ -28,32 +43,55 @@ type (
 	// The key is the function name and the value is the Function implementation.
 	Functions map[string]Function
 
+	// StreamFunction is a function that reads its input from r and writes its output to w in chunks.
+	// This is the function that is called by the host for streaming invocations.
+	StreamFunction func(r io.Reader, w io.Writer) error
+
 	HostError struct {
 		message string
+		code    uint32
+	}
+
+	// QuotaError is the error of a host call the host refused because the plugin exceeded one of its rate limits
+	// or quotas, it matches ErrQuotaExceeded.
+	QuotaError struct {
+		HostError
+
+		// Limit is the exceeded limit: LimitRate, LimitHostCalls or LimitHostBytes.
+		Limit string
+
+		// Operation is the refused host call.
+		Operation string
 	}
 )
 
-var allFns = Functions{}
+// ErrQuotaExceeded is matched by the QuotaError of host calls refused by a rate limit or quota of the host.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Limits which can refuse host calls, reported by QuotaError.
+const (
+	LimitRate      = "rate"
+	LimitHostCalls = "host calls"
+	LimitHostBytes = "host bytes"
+)
+
+// Codes of the host errors, see the host module.
+const (
+	codeQuotaRate      = 2
+	codeQuotaHostCalls = 3
+	codeQuotaHostBytes = 4
+	codeConfigNotSet   = 6
+)
+
+var (
+	allFns       = Functions{}
+	allStreamFns = map[string]StreamFunction{}
+)
 
-func pluginFunction[In Unmarshaler, Out Marshaler](fn PluginFunction[In, Out]) Function {
+func pluginFunction[In Unmarshaler, Out Marshaler](newIn func() In, fn PluginFunction[In, Out]) Function {
 	return func(input []byte) ([]byte, error) {
-		var zero In
-		t := reflect.TypeOf(zero)
-
-		var pluginInput In
-
-		// If it's a pointer type, create a new instance
-		if t != nil && t.Kind() == reflect.Ptr {
-			// Create a new instance of the element type
-			elemType := t.Elem()
-			newElem := reflect.New(elemType)
-			pluginInput = newElem.Interface().(In)
-		} else {
-			// For non-pointer types, use the zero value
-			pluginInput = zero
-		}
-		_, err := pluginInput.UnmarshalMsg(input) // unmarshal the input
-		if err != nil {
+		pluginInput := newIn()
+		if _, err := pluginInput.UnmarshalMsg(input); err != nil { // unmarshal the input
 			return nil, err
 		}
 		output, err := fn(pluginInput)
@@ -68,8 +106,20 @@ func pluginFunction[In Unmarshaler, Out Marshaler](fn PluginFunction[In, Out]) F
 // FnSerial adds a single function by name to the registry.
 // This will invoke the Marshal and Unmarshal functions on the input and output types.
 // This should be invoked in your initialize func to expose any functions you wish the host to use.
-func FnSerial[In Unmarshaler, Out Marshaler](name string, fn PluginFunction[In, Out]) {
-	allFns[name] = pluginFunction(fn)
+// In must be a pointer, the type it points to is inferred.
+func FnSerial[In PtrUnmarshaler[T], Out Marshaler, T any](name string, fn PluginFunction[In, Out]) {
+	allFns[name] = pluginFunction(newPtr[In], fn)
+}
+
+// FnSerialWith is FnSerial for types it cannot infer, such as the type parameters of a generic caller,
+// the input of each call is created with newIn.
+func FnSerialWith[In Unmarshaler, Out Marshaler](name string, newIn func() In, fn PluginFunction[In, Out]) {
+	allFns[name] = pluginFunction(newIn, fn)
+}
+
+// newPtr returns a new T for PT, a pointer to T.
+func newPtr[PT PtrUnmarshaler[T], T any]() PT {
+	return PT(new(T))
 }
 
 // FnByte adds a single function by name to the registry.
@@ -78,30 +128,80 @@ func FnByte(name string, fn Function) {
 	allFns[name] = fn
 }
 
+// FnStream adds a single streaming function by name to the registry.
+// The function reads the input and writes the output in chunks, so large payloads never have to be held in memory.
+// This should be invoked in your initialize func to expose any functions you wish the host to use.
+func FnStream(name string, fn StreamFunction) {
+	allStreamFns[name] = fn
+}
+
 //go:export __plugin_call
 func pluginCall(operationSize uint32, payloadSize uint32) bool {
 	operation := make([]byte, operationSize) // alloc
 	payload := make([]byte, payloadSize)     // alloc
 	pluginRequest(bytesToPointer(operation), bytesToPointer(payload))
 
-	if f, ok := allFns[string(operation)]; ok {
-		response, err := f(payload)
-		if err != nil {
-			message := err.Error()
-			pluginError(stringToPointer(message), uint32(len(message)))
+	if f, ok := allStreamFns[string(operation)]; ok {
+		if err := f(streamReader{}, streamWriter{}); err != nil {
+			reportError(err)
 
 			return false
 		}
 
-		pluginResponse(bytesToPointer(response), uint32(len(response)))
-
 		return true
 	}
 
-	message := `Could not find function "` + string(operation) + `"`
+	response, err := callFunction(string(operation), payload)
+	if err != nil {
+		reportError(err)
+
+		return false
+	}
+
+	pluginResponse(bytesToPointer(response), uint32(len(response)))
+
+	return true
+}
+
+// pluginInvoke is the callback the host uses when the plugin exports hookr_alloc. The host writes the operation
+// followed by the payload into a buffer it allocated with hookr_alloc and reuses for its next calls, so the payload
+// is copied out of it. The response is returned as a packed pointer and length instead of through __plugin_response.
+//
+//go:export __plugin_invoke
+func pluginInvoke(ptr uintptr, operationSize uint32, payloadSize uint32) uint64 {
+	request := pinned[ptr][:operationSize+payloadSize]
+	operation := string(request[:operationSize])
+	payload := append([]byte(nil), request[operationSize:]...) // alloc
+
+	response, err := callFunction(operation, payload)
+	if err != nil {
+		reportError(err)
+
+		return invokeFailed
+	}
+
+	lastResponse = response
+	return pack(response)
+}
+
+// reportError passes the error of a plugin function to the host, with the error code of a VetoError.
+func reportError(err error) {
+	message := err.Error()
+	var veto *VetoError
+	if errors.As(err, &veto) {
+		pluginErrorCode(codeVeto)
+		message = veto.Reason
+	}
 	pluginError(stringToPointer(message), uint32(len(message)))
+}
 
-	return false
+// callFunction calls the function registered as operation.
+func callFunction(operation string, payload []byte) ([]byte, error) {
+	f, ok := allFns[operation]
+	if !ok {
+		return nil, errors.New(`Could not find function "` + operation + `"`)
+	}
+	return f(payload)
 }
 
 // Log is a convenience function to log messages to the console.
@@ -112,28 +212,46 @@ func Log(message string) {
 	if len(message) == 0 {
 		return
 	}
-	consoleLog(stringToPointer(message), uint32(len(message)))
+	hostLog(message)
 }
 
 type HostFunctionSerial[In Marshaler, Out Unmarshaler] struct {
 	name string
+	call func(operation string, input In) (Out, error)
 }
 
 func (h *HostFunctionSerial[In, Out]) Call(input In) (Out, error) {
-	return Call[In, Out](h.name, input)
+	return h.call(h.name, input)
 }
 
-func HostFnSerial[In Marshaler, Out Unmarshaler](name string) *HostFunctionSerial[In, Out] {
-	return &HostFunctionSerial[In, Out]{name: name}
+// HostFnSerial returns the host function with the name.
+// In and Out must be pointers, the types they point to are inferred:
+//
+//	var Hello = pdk.HostFnSerial[*api.HelloRequest, *api.HelloResponse]("hello")
+func HostFnSerial[In PtrMarshaler[I], Out PtrUnmarshaler[T], I, T any](name string) *HostFunctionSerial[In, Out] {
+	return &HostFunctionSerial[In, Out]{name: name, call: Call[In, Out]}
 }
 
-func Call[In Marshaler, Out Unmarshaler](operation string, input In) (Out, error) {
-	var zero Out
+// HostFnSerialWith is HostFnSerial for types it cannot infer, see CallWith.
+func HostFnSerialWith[In Marshaler, Out Unmarshaler](name string, newOut func() Out) *HostFunctionSerial[In, Out] {
+	return &HostFunctionSerial[In, Out]{name: name, call: func(operation string, input In) (Out, error) {
+		return CallWith(operation, input, newOut)
+	}}
+}
 
-	if reflect.ValueOf(input).Kind() == reflect.Ptr && reflect.ValueOf(input).IsNil() {
-		return zero, fmt.Errorf("input cannot be nil")
+// Call calls the host function of the operation with the input.
+// In and Out must be pointers, the types they point to are inferred.
+func Call[In PtrMarshaler[I], Out PtrUnmarshaler[T], I, T any](operation string, input In) (Out, error) {
+	if input == nil {
+		return nil, fmt.Errorf("input cannot be nil")
 	}
+	return CallWith(operation, input, newPtr[Out])
+}
 
+// CallWith is Call for types it cannot infer, such as the type parameters of a generic caller, the output is
+// created with newOut. The input is not checked for nil.
+func CallWith[In Marshaler, Out Unmarshaler](operation string, input In, newOut func() Out) (Out, error) {
+	var zero Out
 	data, err := input.MarshalMsg(nil)
 	if err != nil {
 		return zero, err
@@ -144,23 +262,8 @@ func Call[In Marshaler, Out Unmarshaler](operation string, input In) (Out, error
 		return zero, err
 	}
 
-	t := reflect.TypeOf(zero)
-
-	var output Out
-
-	// If it's a pointer type, create a new instance
-	if t != nil && t.Kind() == reflect.Ptr {
-		// Create a new instance of the element type
-		elemType := t.Elem()
-		newElem := reflect.New(elemType)
-		output = newElem.Interface().(Out)
-	} else {
-		// For non-pointer types, use the zero value
-		output = zero
-	}
-
-	output.UnmarshalMsg(response)
-	if err != nil {
+	output := newOut()
+	if _, err := output.UnmarshalMsg(response); err != nil {
 		return zero, err
 	}
 	return output, nil
@@ -186,23 +289,32 @@ func HostFnByte(name string) *HostFunctionByte {
 // to route to the `payload` to the appropriate operation.  The host will return
 // a response payload if successful.
 func HostCall(operation string, payload []byte) ([]byte, error) {
-	result := hostCall(
-		stringToPointer(operation), uint32(len(operation)),
-		bytesToPointer(payload), uint32(len(payload)),
-	)
-	if !result {
-		errorLen := hostErrorLen()
-		message := make([]byte, errorLen) // alloc
-		hostError(bytesToPointer(message))
+	return callHost(operation, payload)
+}
 
-		return nil, &HostError{message: string(message)} // alloc
-	}
+// lastHostError returns the error of the last failed host interaction with the operation.
+func lastHostError(operation string) error {
+	errorLen := hostErrorLen()
+	message := make([]byte, errorLen) // alloc
+	hostError(bytesToPointer(message))
 
-	responseLen := hostResponseLen()
-	response := make([]byte, responseLen) // alloc
-	hostResponse(bytesToPointer(response))
+	return newHostError(hostErrorCode(), operation, string(message)) // alloc
+}
 
-	return response, nil
+// newHostError returns the error of a failed host call with its code, a QuotaError when the host refused the call.
+func newHostError(code uint32, operation, message string) error {
+	var limit string
+	switch code {
+	case codeQuotaRate:
+		limit = LimitRate
+	case codeQuotaHostCalls:
+		limit = LimitHostCalls
+	case codeQuotaHostBytes:
+		limit = LimitHostBytes
+	default:
+		return &HostError{message: message, code: code}
+	}
+	return &QuotaError{HostError: HostError{message: message, code: code}, Limit: limit, Operation: operation}
 }
 
 //go:inline
@@ -222,3 +334,15 @@ func stringToPointer(s string) uintptr {
 func (e *HostError) Error() string {
 	return "Host error: " + e.message // alloc
 }
+
+func (e *HostError) Is(target error) bool {
+	return target == ErrConfigNotSet && e.code == codeConfigNotSet
+}
+
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+func (e *QuotaError) Unwrap() error {
+	return &e.HostError
+}
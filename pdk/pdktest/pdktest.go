@@ -0,0 +1,158 @@
+//go:build !wasip1
+
+// Package pdktest runs the functions of a plugin natively, so plugins built
+// with the PDK can be unit tested with go test.
+//
+// A Host answers the plugin's host calls with registered handlers and
+// captures the messages it logs:
+//
+//	func TestEcho(t *testing.T) {
+//		host := pdktest.New(t)
+//		host.Handle("hello", func(payload []byte) ([]byte, error) {
+//			return (&api.HelloResponse{Msg: "Hello hookr"}).MarshalMsg(nil)
+//		})
+//		Initialize() // registers the plugin's functions
+//
+//		out, err := host.Invoke("echo", payload)
+//		require.NoError(t, err)
+//		require.Contains(t, host.Logs(), "received echo")
+//	}
+//
+// Streaming functions are called with InvokeStream, which serves their input
+// from a reader and collects their output in a writer:
+//
+//	var out bytes.Buffer
+//	err := host.InvokeStream("upper", strings.NewReader("hookr"), &out)
+//
+// The functions and the host are shared by the whole test binary, so tests
+// using a Host must not run in parallel.
+package pdktest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/mopeyjellyfish/hookr/pdk"
+)
+
+// Call is a host call made by the plugin.
+type Call struct {
+	Operation string
+	Payload   []byte
+}
+
+// Host is an in-process host for a natively built plugin.
+type Host struct {
+	t        testing.TB
+	mu       sync.Mutex
+	handlers map[string]pdk.Function
+	logs     []string
+	calls    []Call
+
+	in  io.Reader // input of the streaming invocation
+	out io.Writer // output of the streaming invocation
+}
+
+// New returns a Host answering the plugin's host calls until the test
+// finishes, when the previous host is restored.
+func New(t testing.TB) *Host {
+	h := &Host{t: t, handlers: map[string]pdk.Function{}}
+	previous := pdk.SetNativeHost(h)
+	t.Cleanup(func() {
+		pdk.SetNativeHost(previous)
+	})
+	return h
+}
+
+// Handle answers calls of the host function with fn.
+func (h *Host) Handle(operation string, fn pdk.Function) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.handlers[operation] = fn
+}
+
+// Invoke calls the plugin function registered as operation, as the host
+// would. The plugin registers its functions in its initialize function, which
+// the test must call first.
+func (h *Host) Invoke(operation string, payload []byte) ([]byte, error) {
+	return pdk.Invoke(operation, payload)
+}
+
+// InvokeStream calls the streaming plugin function registered as operation,
+// as the host would, with the input read from in and the output written to
+// out.
+func (h *Host) InvokeStream(operation string, in io.Reader, out io.Writer) error {
+	h.mu.Lock()
+	h.in, h.out = in, out
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		h.in, h.out = nil, nil
+		h.mu.Unlock()
+	}()
+	return pdk.InvokeStream(operation)
+}
+
+// StreamRead reads the input of the streaming invocation, it implements
+// pdk.NativeStreamHost.
+func (h *Host) StreamRead(p []byte) (int, error) {
+	h.mu.Lock()
+	in := h.in
+	h.mu.Unlock()
+	if in == nil {
+		return 0, io.EOF
+	}
+	return in.Read(p)
+}
+
+// StreamWrite writes the output of the streaming invocation, it implements
+// pdk.NativeStreamHost.
+func (h *Host) StreamWrite(p []byte) (int, error) {
+	h.mu.Lock()
+	out := h.out
+	h.mu.Unlock()
+	if out == nil {
+		return 0, errors.New("no stream output")
+	}
+	return out.Write(p)
+}
+
+// HostCall answers a host call of the plugin with its handler, it implements
+// pdk.NativeHost.
+func (h *Host) HostCall(operation string, payload []byte) ([]byte, error) {
+	h.mu.Lock()
+	h.calls = append(h.calls, Call{Operation: operation, Payload: bytes.Clone(payload)})
+	fn, ok := h.handlers[operation]
+	h.mu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("no handler for host call %q", operation)
+	}
+	return fn(payload)
+}
+
+// Log captures a message logged by the plugin, it implements pdk.NativeHost.
+func (h *Host) Log(message string) {
+	h.t.Log(message)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.logs = append(h.logs, message)
+}
+
+// Logs returns the messages the plugin logged.
+func (h *Host) Logs() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string{}, h.logs...)
+}
+
+// Calls returns the host calls the plugin made.
+func (h *Host) Calls() []Call {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Call{}, h.calls...)
+}
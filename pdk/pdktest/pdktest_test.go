@@ -0,0 +1,177 @@
+//go:build !wasip1
+
+package pdktest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/mopeyjellyfish/hookr/pdk"
+	"github.com/mopeyjellyfish/hookr/runtime"
+	"github.com/mopeyjellyfish/hookr/testdata/api"
+	"github.com/stretchr/testify/require"
+)
+
+var hello = pdk.HostFnSerial[*api.HelloRequest, *api.HelloResponse]("hello")
+
+func echo(payload *api.EchoRequest) (*api.EchoResponse, error) {
+	pdk.Log("received " + payload.Data)
+	resp, err := hello.Call(&api.HelloRequest{Msg: payload.Data})
+	if err != nil {
+		pdk.Log(err.Error())
+		return nil, err
+	}
+	return &api.EchoResponse{Data: resp.Msg}, nil
+}
+
+func upper(r io.Reader, w io.Writer) error {
+	buf := make([]byte, 2) // small chunks to read and write the stream in several calls
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(bytes.ToUpper(buf[:n])); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func initialize() {
+	pdk.FnSerial("echo", echo)
+	pdk.FnStream("upper", upper)
+}
+
+func TestHost(t *testing.T) {
+	host := New(t)
+	host.Handle("hello", func(payload []byte) ([]byte, error) {
+		req := &api.HelloRequest{}
+		if _, err := req.UnmarshalMsg(payload); err != nil {
+			return nil, err
+		}
+		return (&api.HelloResponse{Msg: "Hello " + req.Msg}).MarshalMsg(nil)
+	})
+	initialize()
+
+	payload, err := (&api.EchoRequest{Data: "hookr"}).MarshalMsg(nil)
+	require.NoError(t, err)
+	out, err := host.Invoke("echo", payload)
+	require.NoError(t, err)
+
+	resp := &api.EchoResponse{}
+	_, err = resp.UnmarshalMsg(out)
+	require.NoError(t, err)
+	require.Equal(t, "Hello hookr", resp.Data)
+	require.Equal(t, []string{"received hookr"}, host.Logs())
+
+	calls := host.Calls()
+	require.Len(t, calls, 1)
+	require.Equal(t, "hello", calls[0].Operation)
+}
+
+func TestHostMissingHandler(t *testing.T) {
+	host := New(t)
+	initialize()
+
+	payload, err := (&api.EchoRequest{Data: "hookr"}).MarshalMsg(nil)
+	require.NoError(t, err)
+	_, err = host.Invoke("echo", payload)
+	var hostErr *pdk.HostError
+	require.ErrorAs(t, err, &hostErr)
+	require.EqualError(t, err, `Host error: no handler for host call "hello"`)
+	require.Equal(t, []string{"received hookr", `Host error: no handler for host call "hello"`}, host.Logs())
+}
+
+func TestHostQuotaExceeded(t *testing.T) {
+	host := New(t)
+	host.Handle("hello", func([]byte) ([]byte, error) {
+		return nil, &runtime.QuotaError{Limit: runtime.LimitRate, Operation: "hello"}
+	})
+
+	_, err := pdk.HostCall("hello", nil)
+	var quotaErr *pdk.QuotaError
+	require.ErrorAs(t, err, &quotaErr, "the host's quota error is typed in the plugin")
+	require.Equal(t, pdk.LimitRate, quotaErr.Limit)
+	require.Equal(t, "hello", quotaErr.Operation)
+	require.ErrorIs(t, err, pdk.ErrQuotaExceeded)
+	var hostErr *pdk.HostError
+	require.ErrorAs(t, err, &hostErr)
+	require.EqualError(t, err, `Host error: quota exceeded: rate limit of host call "hello"`)
+
+	host.Handle("hello", func([]byte) ([]byte, error) {
+		return nil, errors.New("quota exceeded: by the host function")
+	})
+	_, err = pdk.HostCall("hello", nil)
+	require.NotErrorIs(t, err, pdk.ErrQuotaExceeded, "only errors with a quota code are quota errors")
+}
+
+func TestConfig(t *testing.T) {
+	host := New(t)
+	host.Handle(pdk.ConfigFn, func(key []byte) ([]byte, error) {
+		switch string(key) {
+		case "region":
+			return []byte("eu"), nil
+		case "broken":
+			return nil, errors.New("config store unavailable")
+		}
+		return nil, fmt.Errorf("%w: %q", runtime.ErrConfigNotSet, key)
+	})
+
+	region, err := pdk.Config("region")
+	require.NoError(t, err)
+	require.Equal(t, "eu", region)
+	_, err = pdk.Config("missing")
+	require.ErrorIs(t, err, pdk.ErrConfigNotSet, "a missing key is told apart by its code")
+	_, err = pdk.Config("broken")
+	require.Error(t, err)
+	require.NotErrorIs(t, err, pdk.ErrConfigNotSet, "other host errors are not a missing key")
+}
+
+func TestHostStream(t *testing.T) {
+	host := New(t)
+	initialize()
+
+	var out bytes.Buffer
+	require.NoError(t, host.InvokeStream("upper", strings.NewReader("hookr"), &out))
+	require.Equal(t, "HOOKR", out.String())
+
+	err := host.InvokeStream("upper", iotest.ErrReader(errors.New("connection reset")), &out)
+	var hostErr *pdk.HostError
+	require.ErrorAs(t, err, &hostErr, "input errors reach the plugin as host errors")
+	require.EqualError(t, err, "Host error: connection reset")
+
+	err = host.InvokeStream("upper", strings.NewReader("hookr"), failWriter{})
+	require.EqualError(t, err, "stream closed by host")
+	require.EqualError(t, host.InvokeStream("missing", nil, nil), `Could not find function "missing"`)
+}
+
+// failWriter is the output of a stream whose consumer went away.
+type failWriter struct{}
+
+func (failWriter) Write([]byte) (int, error) {
+	return 0, errors.New("consumer gone")
+}
+
+func TestHostUnknownFunction(t *testing.T) {
+	host := New(t)
+	_, err := host.Invoke("missing", nil)
+	require.EqualError(t, err, `Could not find function "missing"`)
+}
+
+func TestHostRestored(t *testing.T) {
+	t.Run("with host", func(t *testing.T) {
+		New(t)
+	})
+	_, err := pdk.HostCall("hello", nil)
+	require.EqualError(t, err, "Host error: no host")
+}
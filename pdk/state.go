@@ -0,0 +1,67 @@
+package pdk
+
+import (
+	"github.com/tinylib/msgp/msgp"
+)
+
+var (
+	stateVersion  string
+	exportStateFn func() ([]byte, error)
+	importStateFn func(version string, state []byte) error
+)
+
+// OnExportState registers fn to export the state of the plugin when the host reloads it, the state is handed to the
+// new version of the plugin, see OnImportState. The version tags the format of the state so a new version of the
+// plugin can tell whether it understands it.
+func OnExportState(version string, fn func() ([]byte, error)) {
+	stateVersion = version
+	exportStateFn = fn
+}
+
+// OnImportState registers fn to import the state exported by the previous version of the plugin when the host
+// reloads it. Returning an error rejects the state, for example when the version is not understood, and the host
+// keeps running the previous version.
+func OnImportState(fn func(version string, state []byte) error) {
+	importStateFn = fn
+}
+
+// exportState responds with a msgpack array of the version tag and the state, or nothing when the plugin has none.
+//
+//go:export hookr_export_state
+func exportState() bool {
+	if exportStateFn == nil {
+		return true
+	}
+	state, err := exportStateFn()
+	if err != nil {
+		message := err.Error()
+		pluginError(stringToPointer(message), uint32(len(message)))
+
+		return false
+	}
+
+	response := msgp.AppendArrayHeader(nil, 2)
+	response = msgp.AppendString(response, stateVersion)
+	response = msgp.AppendBytes(response, state)
+	pluginResponse(bytesToPointer(response), uint32(len(response)))
+
+	return true
+}
+
+//go:export hookr_import_state
+func importState(versionSize uint32, stateSize uint32) bool {
+	if importStateFn == nil {
+		return true
+	}
+	version := make([]byte, versionSize) // alloc
+	state := make([]byte, stateSize)     // alloc
+	pluginRequest(bytesToPointer(version), bytesToPointer(state))
+
+	if err := importStateFn(string(version), state); err != nil {
+		message := err.Error()
+		pluginError(stringToPointer(message), uint32(len(message)))
+
+		return false
+	}
+	return true
+}
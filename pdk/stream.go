@@ -0,0 +1,37 @@
+package pdk
+
+import (
+	"errors"
+	"io"
+)
+
+// errStreamClosed is returned when the host no longer accepts output, usually because the consumer closed the stream.
+var errStreamClosed = errors.New("stream closed by host")
+
+// streamReader reads the input of a streaming invocation from the host.
+type streamReader struct{}
+
+func (streamReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return readStream(p)
+}
+
+// streamWriter writes the output of a streaming invocation to the host.
+type streamWriter struct{}
+
+func (streamWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if !writeStream(p) {
+		return 0, errStreamClosed
+	}
+	return len(p), nil
+}
+
+var (
+	_ io.Reader = streamReader{}
+	_ io.Writer = streamWriter{}
+)
@@ -15,18 +15,15 @@ func pluginResponse(ptr uintptr, len uint32)
 func pluginError(ptr uintptr, len uint32)
 
 //go:wasm-module hookr
-//go:export __host_call
-func hostCall(
-	operationPtr uintptr, operationLen uint32,
-	payloadPtr uintptr, payloadLen uint32) bool
-
-//go:wasm-module hookr
-//go:export __host_response_len
-func hostResponseLen() uint32
+//go:export __plugin_error_code
+func pluginErrorCode(code uint32)
 
 //go:wasm-module hookr
-//go:export __host_response
-func hostResponse(ptr uintptr)
+//go:export __host_invoke
+func hostInvoke(
+	operationPtr uintptr, operationLen uint32,
+	payloadPtr uintptr, payloadLen uint32,
+	bufPtr uintptr, bufLen uint32) uint64
 
 //go:wasm-module hookr
 //go:export __host_error_len
@@ -36,6 +33,18 @@ func hostErrorLen() uint32
 //go:export __host_error
 func hostError(ptr uintptr)
 
+//go:wasm-module hookr
+//go:export __host_error_code
+func hostErrorCode() uint32
+
 //go:wasm-module hookr
 //go:export __log
 func consoleLog(ptr uintptr, len uint32)
+
+//go:wasm-module hookr
+//go:export __stream_read
+func streamRead(ptr uintptr, len uint32) int32
+
+//go:wasm-module hookr
+//go:export __stream_write
+func streamWrite(ptr uintptr, len uint32) bool
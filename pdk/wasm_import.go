@@ -12,21 +12,7 @@ func pluginError(ptr uintptr, len uint32) {
 
 }
 
-func hostCall(
-	operationPtr uintptr, operationLen uint32,
-	payloadPtr uintptr, payloadLen uint32) bool {
-	return false
-}
-
-//go:wasm-module hookr
-//go:export __host_response_len
-func hostResponseLen() uint32 {
-	return 0
-}
-
-//go:wasm-module hookr
-//go:export __host_response
-func hostResponse(ptr uintptr) {}
+func pluginErrorCode(code uint32) {}
 
 //go:wasm-module hookr
 //go:export __host_error_len
@@ -39,5 +25,7 @@ func hostErrorLen() uint32 {
 func hostError(ptr uintptr) {}
 
 //go:wasm-module hookr
-//go:export __log
-func consoleLog(ptr uintptr, len uint32) {}
+//go:export __host_error_code
+func hostErrorCode() uint32 {
+	return 0
+}
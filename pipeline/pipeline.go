@@ -0,0 +1,200 @@
+// Package pipeline chains plugin functions, the output of each stage becomes
+// the input of the next.
+//
+// Each stage calls an operation of a plugin, usually a runtime.Runtime. Codecs
+// convert the data between stages which speak different formats, and a branch
+// can skip ahead depending on the output of a stage:
+//
+//	p, err := pipeline.New(
+//		pipeline.Stage{Plugin: "parser", Invoker: parser, Operation: "parse"},
+//		pipeline.Stage{Plugin: "filter", Invoker: filter, Operation: "filter",
+//			Branch: func(output []byte) string {
+//				if len(output) == 0 {
+//					return pipeline.End
+//				}
+//				return pipeline.Next
+//			}},
+//		pipeline.Stage{Plugin: "render", Invoker: render, Operation: "render", Decode: pipeline.MsgpackToJSON},
+//	)
+//
+//	result, err := p.Run(ctx, input)
+//	var stageErr *pipeline.StageError
+//	if errors.As(err, &stageErr) {
+//		log.Printf("stage %s failed, steps: %v", stageErr.Stage, result.Steps)
+//	}
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+const (
+	// Next continues with the following stage.
+	Next = ""
+
+	// End finishes the pipeline, the output of the stage is its output.
+	End = "\x00end"
+)
+
+// Invoker calls a plugin function, it is implemented by *runtime.Runtime.
+type Invoker interface {
+	Invoke(ctx context.Context, operation string, payload []byte) ([]byte, error)
+}
+
+// Codec converts data between the format of one stage and another.
+type Codec func(data []byte) ([]byte, error)
+
+// Branch picks the stage following a stage from its output: Next, End or the
+// name of a later stage.
+type Branch func(output []byte) string
+
+// Stage calls an operation of a plugin.
+type Stage struct {
+	// Name identifies the stage, "plugin.operation" when empty.
+	Name      string
+	Plugin    string
+	Invoker   Invoker
+	Operation string
+
+	// Encode converts the input before the call, Decode the output after it.
+	Encode Codec
+	Decode Codec
+
+	// Branch picks the following stage, the next one when nil.
+	Branch Branch
+}
+
+// Step is the outcome of running a single stage.
+type Step struct {
+	Stage  string
+	Input  []byte
+	Output []byte
+	Err    error
+}
+
+// Result is the outcome of running a pipeline.
+type Result struct {
+	// Steps of every stage which ran, in order.
+	Steps []Step
+
+	// Output is the output of the last stage.
+	Output []byte
+}
+
+// StageError is the error of the stage which failed.
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("pipeline stage %q: %v", e.Stage, e.Err)
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// Pipeline is a sequence of stages. It is safe for concurrent use.
+type Pipeline struct {
+	stages []Stage
+	index  map[string]int
+}
+
+// New returns a pipeline running the stages in order.
+func New(stages ...Stage) (*Pipeline, error) {
+	if len(stages) == 0 {
+		return nil, errors.New("pipeline needs at least one stage")
+	}
+
+	p := &Pipeline{stages: make([]Stage, len(stages)), index: map[string]int{}}
+	for i, stage := range stages {
+		if stage.Name == "" {
+			stage.Name = stage.Plugin + "." + stage.Operation
+		}
+		if stage.Invoker == nil {
+			return nil, fmt.Errorf("pipeline stage %q has no invoker", stage.Name)
+		}
+		if stage.Name == End {
+			return nil, fmt.Errorf("pipeline stage %d has a reserved name", i)
+		}
+		if _, ok := p.index[stage.Name]; ok {
+			return nil, fmt.Errorf("pipeline stage %q is not unique", stage.Name)
+		}
+		p.index[stage.Name] = i
+		p.stages[i] = stage
+	}
+	return p, nil
+}
+
+// Run runs the stages with the input. The result holds the steps which ran,
+// also when a stage failed, which is returned as a *StageError.
+func (p *Pipeline) Run(ctx context.Context, input []byte) (*Result, error) {
+	result := &Result{}
+	data := input
+	for i := 0; i < len(p.stages); {
+		stage := &p.stages[i]
+		step := Step{Stage: stage.Name, Input: data}
+		step.Output, step.Err = stage.run(ctx, data)
+		result.Steps = append(result.Steps, step)
+		if step.Err != nil {
+			return result, &StageError{Stage: stage.Name, Err: step.Err}
+		}
+		data = step.Output
+
+		next := Next
+		if stage.Branch != nil {
+			next = stage.Branch(data)
+		}
+		switch next {
+		case Next:
+			i++
+		case End:
+			i = len(p.stages)
+		default:
+			j, ok := p.index[next]
+			if !ok || j <= i {
+				return result, &StageError{Stage: stage.Name, Err: fmt.Errorf("branch to unknown or earlier stage %q", next)}
+			}
+			i = j
+		}
+	}
+	result.Output = data
+	return result, nil
+}
+
+func (s *Stage) run(ctx context.Context, input []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if s.Encode != nil {
+		var err error
+		if input, err = s.Encode(input); err != nil {
+			return nil, fmt.Errorf("failed to encode input: %w", err)
+		}
+	}
+	output, err := s.Invoker.Invoke(ctx, s.Operation, input)
+	if err != nil {
+		return nil, err
+	}
+	if s.Decode != nil {
+		if output, err = s.Decode(output); err != nil {
+			return nil, fmt.Errorf("failed to decode output: %w", err)
+		}
+	}
+	return output, nil
+}
+
+// MsgpackToJSON is a Codec converting msgpack to JSON.
+func MsgpackToJSON(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := msgp.UnmarshalAsJSON(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
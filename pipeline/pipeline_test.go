@@ -0,0 +1,180 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mopeyjellyfish/hookr/runtime"
+	"github.com/stretchr/testify/require"
+	"github.com/tinylib/msgp/msgp"
+)
+
+const (
+	ABI_WASM    = "../testdata/abi/bin/abi.wasm"
+	SIMPLE_WASM = "../testdata/simple/bin/simple.wasm"
+)
+
+// fakePlugin handles operations with Go functions.
+type fakePlugin map[string]func(payload []byte) ([]byte, error)
+
+func (f fakePlugin) Invoke(_ context.Context, operation string, payload []byte) ([]byte, error) {
+	fn, ok := f[operation]
+	if !ok {
+		return nil, errors.New("unknown operation " + operation)
+	}
+	return fn(payload)
+}
+
+func appendFn(s string) func([]byte) ([]byte, error) {
+	return func(payload []byte) ([]byte, error) {
+		return append(append([]byte{}, payload...), s...), nil
+	}
+}
+
+func TestNew(t *testing.T) {
+	_, err := New()
+	require.Error(t, err, "expected error without stages")
+	_, err = New(Stage{Plugin: "p", Operation: "a"})
+	require.ErrorContains(t, err, `"p.a" has no invoker`)
+	_, err = New(Stage{Plugin: "p", Invoker: fakePlugin{}, Operation: "a"}, Stage{Plugin: "p", Invoker: fakePlugin{}, Operation: "a"})
+	require.ErrorContains(t, err, `"p.a" is not unique`)
+	_, err = New(Stage{Name: End, Invoker: fakePlugin{}})
+	require.Error(t, err, "expected error for a reserved name")
+}
+
+func TestRun(t *testing.T) {
+	plugin := fakePlugin{"a": appendFn("a"), "b": appendFn("b"), "c": appendFn("c")}
+	p, err := New(
+		Stage{Plugin: "p", Invoker: plugin, Operation: "a"},
+		Stage{Plugin: "p", Invoker: plugin, Operation: "b"},
+		Stage{Name: "last", Invoker: plugin, Operation: "c"},
+	)
+	require.NoError(t, err)
+
+	result, err := p.Run(context.Background(), []byte(">"))
+	require.NoError(t, err)
+	require.Equal(t, ">abc", string(result.Output))
+	require.Len(t, result.Steps, 3)
+	require.Equal(t, "p.b", result.Steps[1].Stage)
+	require.Equal(t, ">a", string(result.Steps[1].Input))
+	require.Equal(t, ">ab", string(result.Steps[1].Output))
+	require.Equal(t, "last", result.Steps[2].Stage)
+}
+
+func TestRunBranch(t *testing.T) {
+	plugin := fakePlugin{"a": appendFn("a"), "b": appendFn("b"), "c": appendFn("c")}
+	branch := func(output []byte) string {
+		switch string(output) {
+		case "skip":
+			return "c"
+		case "end":
+			return End
+		case "back":
+			return "a"
+		}
+		return Next
+	}
+	p, err := New(
+		Stage{Name: "a", Invoker: plugin, Operation: "a"},
+		Stage{Name: "check", Invoker: fakePlugin{"check": func(b []byte) ([]byte, error) { return b[:len(b)-1], nil }}, Operation: "check", Branch: branch},
+		Stage{Name: "b", Invoker: plugin, Operation: "b"},
+		Stage{Name: "c", Invoker: plugin, Operation: "c"},
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	result, err := p.Run(ctx, []byte("x"))
+	require.NoError(t, err)
+	require.Equal(t, "xbc", string(result.Output))
+
+	result, err = p.Run(ctx, []byte("skip"))
+	require.NoError(t, err)
+	require.Equal(t, "skipc", string(result.Output))
+	require.Len(t, result.Steps, 3, "stage b is skipped")
+
+	result, err = p.Run(ctx, []byte("end"))
+	require.NoError(t, err)
+	require.Equal(t, "end", string(result.Output))
+
+	result, err = p.Run(ctx, []byte("back"))
+	var stageErr *StageError
+	require.ErrorAs(t, err, &stageErr, "branching back is refused")
+	require.Equal(t, "check", stageErr.Stage)
+	require.Len(t, result.Steps, 2)
+}
+
+func TestRunError(t *testing.T) {
+	fail := errors.New("boom")
+	p, err := New(
+		Stage{Plugin: "p", Invoker: fakePlugin{"a": appendFn("a")}, Operation: "a"},
+		Stage{Plugin: "p", Invoker: fakePlugin{"b": func([]byte) ([]byte, error) { return nil, fail }}, Operation: "b"},
+		Stage{Plugin: "p", Invoker: fakePlugin{"c": appendFn("c")}, Operation: "c"},
+	)
+	require.NoError(t, err)
+
+	result, err := p.Run(context.Background(), []byte(">"))
+	require.ErrorIs(t, err, fail)
+	var stageErr *StageError
+	require.ErrorAs(t, err, &stageErr)
+	require.Equal(t, "p.b", stageErr.Stage)
+	require.ErrorContains(t, err, `pipeline stage "p.b": boom`)
+	require.Len(t, result.Steps, 2, "the steps up to the failed stage are reported")
+	require.Equal(t, ">a", string(result.Steps[0].Output))
+	require.ErrorIs(t, result.Steps[1].Err, fail)
+	require.Nil(t, result.Output)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = p.Run(ctx, nil)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestRunCodec(t *testing.T) {
+	plugin := fakePlugin{"echo": func(b []byte) ([]byte, error) { return b, nil }}
+	p, err := New(Stage{
+		Plugin:    "p",
+		Invoker:   plugin,
+		Operation: "echo",
+		Encode: func(data []byte) ([]byte, error) {
+			return msgp.AppendMapStrStr(nil, map[string]string{"in": string(data)}), nil
+		},
+		Decode: MsgpackToJSON,
+	})
+	require.NoError(t, err)
+
+	result, err := p.Run(context.Background(), []byte("x"))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"in":"x"}`, string(result.Output))
+
+	_, err = p.Run(context.Background(), nil)
+	require.NoError(t, err)
+
+	p, err = New(Stage{Plugin: "p", Invoker: plugin, Operation: "echo", Decode: MsgpackToJSON})
+	require.NoError(t, err)
+	_, err = p.Run(context.Background(), []byte{0xc1})
+	require.ErrorContains(t, err, "failed to decode output")
+}
+
+func TestRunRuntime(t *testing.T) {
+	ctx := context.Background()
+	echo, err := runtime.New(ctx, runtime.WithFile(ABI_WASM))
+	require.NoError(t, err, "failed to create module")
+	defer func() {
+		require.NoError(t, echo.Close(ctx), "failed to close module")
+	}()
+	simple, err := runtime.New(ctx, runtime.WithFile(SIMPLE_WASM))
+	require.NoError(t, err, "failed to create module")
+	defer func() {
+		require.NoError(t, simple.Close(ctx), "failed to close module")
+	}()
+
+	p, err := New(
+		Stage{Plugin: "abi", Invoker: echo, Operation: "noop"},
+		Stage{Plugin: "simple", Invoker: simple, Operation: "vowel"},
+	)
+	require.NoError(t, err)
+	result, err := p.Run(ctx, []byte("hookr"))
+	require.NoError(t, err)
+	require.Equal(t, "2", string(result.Output))
+}
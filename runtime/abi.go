@@ -0,0 +1,97 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+
+	"github.com/mopeyjellyfish/hookr/runtime/invoke"
+	"github.com/mopeyjellyfish/hookr/runtime/memory"
+	"github.com/mopeyjellyfish/hookr/runtime/module"
+)
+
+// minLease is the smallest request buffer leased from the guest.
+const minLease = 1024
+
+// callInvoke performs the plugin call through fnPluginInvoke. The operation
+// and payload are written into the leased request buffer, so the call crosses
+// into the plugin once, which returns where its response is.
+// The caller must hold e.mu.
+func (e *Runtime) callInvoke(ctx context.Context, ic *invoke.Context, operation string, payload []byte) ([]byte, error) {
+	opLen, err := memory.Uint32FromInt(len(operation))
+	if err != nil {
+		return nil, err
+	}
+	payloadLen, err := memory.Uint32FromInt(len(payload))
+	if err != nil {
+		return nil, err
+	}
+	size, carry := bits.Add32(opLen, payloadLen, 0)
+	if carry != 0 {
+		return nil, fmt.Errorf("request of %q with %d bytes of payload does not fit into memory", operation, payloadLen)
+	}
+	ptr, err := e.request(ctx, size)
+	if err != nil {
+		return nil, e.callError(operation, err)
+	}
+	mem := e.plugin.Memory()
+	if !mem.WriteString(ptr, operation) || !mem.Write(ptr+opLen, payload) {
+		return nil, fmt.Errorf("request buffer at %d is out of memory", ptr)
+	}
+
+	ic.Alloc = e.alloc
+	results, err := e.pluginInvoke.Call(ctx, uint64(ptr), uint64(opLen), uint64(payloadLen))
+	if err != nil {
+		return nil, e.callError(operation, err)
+	}
+	if ic.Err != nil {
+		return nil, ic.Err
+	}
+	if ic.PluginErr != "" {
+		return nil, pluginError(ic)
+	}
+	if results[0] == module.Failed {
+		return nil, fmt.Errorf("call to %q was unsuccessful", operation)
+	}
+
+	respPtr, respLen := module.Unpack(results[0])
+	if err := e.limits.Check(LimitPluginResponse, uint64(respLen)); err != nil {
+		return nil, err
+	}
+	resp, ok := mem.Read(respPtr, respLen)
+	if !ok {
+		return nil, fmt.Errorf("call to %q returned %d bytes out of memory at %d", operation, respLen, respPtr)
+	}
+	return resp, nil
+}
+
+// request returns the leased request buffer, holding at least size bytes. The
+// buffer is allocated with the guest's allocator and kept by the host for the
+// following calls, it is only replaced by a larger one when a request does not
+// fit.
+// The caller must hold e.mu.
+func (e *Runtime) request(ctx context.Context, size uint32) (uint32, error) {
+	if size <= e.leaseCap && e.lease != 0 {
+		return e.lease, nil
+	}
+	if e.lease != 0 && e.free != nil {
+		if _, err := e.free.Call(ctx, uint64(e.lease), uint64(e.leaseCap)); err != nil {
+			return 0, err
+		}
+	}
+	e.lease, e.leaseCap = 0, 0
+
+	capacity := uint32(minLease)
+	if size > capacity {
+		capacity = 1 << bits.Len32(size-1) // the next power of two
+		if capacity == 0 {
+			capacity = size
+		}
+	}
+	ptr, err := module.Alloc(ctx, e.alloc, capacity)
+	if err != nil {
+		return 0, err
+	}
+	e.lease, e.leaseCap = ptr, capacity
+	return ptr, nil
+}
@@ -0,0 +1,110 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	ABI_WASM    = "../testdata/abi/bin/abi.wasm"
+	LEGACY_WASM = "../testdata/abi/bin/legacy.wasm"
+)
+
+func reply(_ context.Context, payload []byte) ([]byte, error) {
+	if string(payload) == "fail" {
+		return nil, errors.New("host failed")
+	}
+	return append([]byte("re: "), payload...), nil
+}
+
+func TestABI(t *testing.T) {
+	for name, file := range map[string]string{"Alloc": ABI_WASM, "Legacy": LEGACY_WASM} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			rt, err := New(ctx, WithFile(file), WithHostFns(HostFnByte("hello", reply)))
+			require.NoError(t, err)
+			defer rt.Close(ctx)
+			require.Equal(t, name == "Alloc", rt.pluginInvoke != nil)
+
+			first, err := rt.Invoke(ctx, "echo", []byte("a"))
+			require.NoError(t, err)
+			second, err := rt.Invoke(ctx, "noop", []byte("b"))
+			require.NoError(t, err)
+			require.Equal(t, []byte("re: a"), first, "responses are copied out of guest memory")
+			require.Equal(t, []byte("b"), second)
+			lease := rt.lease
+
+			_, err = rt.Invoke(ctx, "echo", []byte("fail"))
+			require.EqualError(t, err, "host failed")
+			require.Equal(t, lease, rt.lease, "the request buffer is reused")
+
+			large := bytes.Repeat([]byte("x"), 200_000)
+			out, err := rt.Invoke(ctx, "echo", large)
+			require.NoError(t, err)
+			require.Equal(t, append([]byte("re: "), large...), out)
+		})
+	}
+}
+
+func TestInvokeBorrowed(t *testing.T) {
+	ctx := context.Background()
+	rt, err := New(ctx, WithFile(ABI_WASM), WithHostFns(HostFnByte("hello", reply)))
+	require.NoError(t, err)
+	defer rt.Close(ctx)
+
+	resp, err := rt.InvokeBorrowed(ctx, "echo", []byte("borrowed"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("re: borrowed"), resp.Bytes())
+	resp.Release()
+	resp.Release()
+	require.Nil(t, resp.Bytes())
+
+	out, err := rt.Invoke(ctx, "echo", []byte("again"))
+	require.NoError(t, err, "the runtime is usable once the response is released")
+	require.Equal(t, []byte("re: again"), out)
+
+	_, err = rt.InvokeBorrowed(ctx, "echo", []byte("fail"))
+	require.EqualError(t, err, "host failed")
+	_, err = rt.Invoke(ctx, "noop", nil)
+	require.NoError(t, err, "failed calls release the runtime")
+}
+
+func BenchmarkInvokeABI(b *testing.B) {
+	ctx := context.Background()
+	payload := []byte(
+		"Who controls the past controls the future; who controls the present controls the past.",
+	)
+	for _, abi := range []struct{ name, file string }{{"Legacy", LEGACY_WASM}, {"Alloc", ABI_WASM}} {
+		rt, err := New(ctx, WithFile(abi.file), WithHostFns(HostFnByte("hello", reply)))
+		require.NoError(b, err)
+
+		// Legacy plugin and host calls cross between host and guest 3 times
+		// each, with the allocator once each.
+		b.Run(abi.name+"/Call", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, _ = rt.Invoke(ctx, "noop", payload)
+			}
+		})
+		b.Run(abi.name+"/HostCall", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, _ = rt.Invoke(ctx, "echo", payload)
+			}
+		})
+		b.Run(abi.name+"/Borrowed", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				resp, err := rt.InvokeBorrowed(ctx, "echo", payload)
+				if err == nil {
+					resp.Release()
+				}
+			}
+		})
+		require.NoError(b, rt.Close(ctx))
+	}
+}
@@ -0,0 +1,220 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mopeyjellyfish/hookr/runtime/invoke"
+	"github.com/mopeyjellyfish/hookr/runtime/memory"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// functionPluginBatch is exported by plugins which can process a batch of payloads in a single call. It has the
+// same signature as __plugin_call, the payload is a msgpack array of binary payloads and the response a msgpack
+// array of [error, result] pairs in the same order. The error of an item which succeeded is nil, that of one
+// which failed is its message, which may be empty.
+const fnPluginBatch = "__plugin_batch"
+
+// Result is the outcome of a single item of a batch or asynchronous call.
+type Result[T any] struct {
+	Value T
+	Err   error
+}
+
+// InvokeBatch calls the plugin function once for each payload and returns the
+// results in the same order. When the plugin exports __plugin_batch the whole
+// batch is sent in a single call, otherwise the payloads are invoked one after
+// another. Either way the calls go through the operation's circuit breaker
+// and are recorded like those of Invoke.
+//
+// Items which were not processed because ctx was cancelled fail with the
+// context error. The batch runs on the single plugin instance of the runtime,
+// manager.Manager.InvokeBatch spreads it over the instances of a pool.
+func (e *Runtime) InvokeBatch(ctx context.Context, operation string, payloads [][]byte) []Result[[]byte] {
+	return e.invokeBatchWith(ctx, e.breaker(operation), operation, payloads)
+}
+
+// invokeBatchWith calls the plugin function for each payload guarded by the
+// circuit breaker b, if any.
+func (e *Runtime) invokeBatchWith(ctx context.Context, b *Breaker, operation string, payloads [][]byte) []Result[[]byte] {
+	if len(payloads) == 0 {
+		return []Result[[]byte]{}
+	}
+
+	if e.exports(fnPluginBatch) {
+		var outs []Result[[]byte]
+		err := guard(b, func() error {
+			var err error
+			outs, err = e.invokeBatch(ctx, operation, payloads)
+			return err
+		})
+		if err != nil {
+			return failBatch[[]byte](len(payloads), err)
+		}
+		return outs
+	}
+
+	results := make([]Result[[]byte], len(payloads))
+	for i, payload := range payloads {
+		if err := ctx.Err(); err != nil {
+			results[i].Err = err
+			continue
+		}
+		results[i].Value, results[i].Err = e.invokeWith(ctx, b, operation, payload)
+	}
+	return results
+}
+
+// exports reports whether the plugin instance exports the function.
+func (e *Runtime) exports(name string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.plugin != nil && e.plugin.ExportedFunction(name) != nil
+}
+
+// failBatch returns n results failing with err.
+func failBatch[T any](n int, err error) []Result[T] {
+	results := make([]Result[T], n)
+	for i := range results {
+		results[i].Err = err
+	}
+	return results
+}
+
+// invokeBatch sends all payloads to the plugin through a single __plugin_batch call.
+func (e *Runtime) invokeBatch(ctx context.Context, operation string, payloads [][]byte) ([]Result[[]byte], error) {
+	batch, err := encodeBatch(payloads)
+	if err != nil {
+		return nil, err
+	}
+
+	var outs []Result[[]byte]
+	if err := e.withBatch(ctx, operation, batch, func(resp []byte) error {
+		if outs, err = decodeBatchResults(resp, len(payloads)); err != nil {
+			return fmt.Errorf("batch call to %q: %w", operation, err)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return outs, nil
+}
+
+// withBatch calls __plugin_batch with the encoded batch and passes the encoded
+// results to fn, they are a view into guest memory only valid until fn returns.
+func (e *Runtime) withBatch(ctx context.Context, operation string, batch []byte, fn func(resp []byte) error) error {
+	if err := e.limits.Check(LimitPluginRequest, uint64(len(batch))); err != nil {
+		return err
+	}
+
+	ctx, done, err := e.inflight.enter(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := e.instance(); err != nil {
+		return err
+	}
+
+	resp, err := e.record(ctx, RecordedInvocation{Batch: true, Operation: operation, Payload: batch}, func(ctx context.Context) ([]byte, error) {
+		return e.callBatch(ctx, operation, batch)
+	})
+	if err != nil {
+		return err
+	}
+	return fn(resp)
+}
+
+// callBatch performs the __plugin_batch call, the response is a view into
+// guest memory valid until the plugin is called again.
+// The caller must hold e.mu.
+func (e *Runtime) callBatch(ctx context.Context, operation string, batch []byte) ([]byte, error) {
+	ctx, cancel := e.withCallTimeout(ctx)
+	defer cancel()
+	ic := invoke.Context{Operation: operation, PluginReq: batch}
+	ctx = invoke.New(ctx, &ic)
+
+	fn := e.plugin.ExportedFunction(fnPluginBatch)
+	if fn == nil { // replaced by a version without it since the batch was prepared
+		return nil, fmt.Errorf("module %s didn't export function %s", e.moduleName, fnPluginBatch)
+	}
+	results, err := fn.Call(ctx, uint64(len(operation)), uint64(len(batch)))
+	if err != nil {
+		return nil, e.callError(operation, err)
+	}
+	if ic.Err != nil {
+		return nil, ic.Err
+	}
+	if ic.PluginErr != "" {
+		return nil, pluginError(&ic)
+	}
+	if results[0] != 1 {
+		return nil, fmt.Errorf("batch call to %q was unsuccessful", operation)
+	}
+	return ic.PluginResp, nil
+}
+
+// encodeBatch encodes the payloads as a msgpack array of binary values.
+func encodeBatch(payloads [][]byte) ([]byte, error) {
+	size, err := memory.Uint32FromInt(len(payloads))
+	if err != nil {
+		return nil, err
+	}
+	b := msgp.AppendArrayHeader(nil, size)
+	for _, payload := range payloads {
+		b = msgp.AppendBytes(b, payload)
+	}
+	return b, nil
+}
+
+// decodeBatchResults decodes a msgpack array of [error, result] pairs, the
+// results are copied out of guest memory. An item failed unless its error is
+// nil. The array must hold a result for each of the count payloads, it is
+// checked before allocating as the plugin chose the size.
+func decodeBatchResults(b []byte, count int) ([]Result[[]byte], error) {
+	size, b, err := msgp.ReadArrayHeaderBytes(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode batch results: %w", err)
+	}
+	if uint64(size) != uint64(count) {
+		return nil, fmt.Errorf("returned %d results for %d payloads", size, count)
+	}
+	results := make([]Result[[]byte], size)
+	for i := range results {
+		var pair uint32
+		if pair, b, err = msgp.ReadArrayHeaderBytes(b); err != nil {
+			return nil, fmt.Errorf("failed to decode batch result %d: %w", i, err)
+		}
+		if pair != 2 {
+			return nil, fmt.Errorf("failed to decode batch result %d: expected 2 fields, got %d", i, pair)
+		}
+		failed := !msgp.IsNil(b)
+		var message string
+		if failed {
+			message, b, err = msgp.ReadStringBytes(b)
+		} else {
+			b, err = msgp.ReadNilBytes(b)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode batch result %d: %w", i, err)
+		}
+		if results[i].Value, b, err = msgp.ReadBytesBytes(b, nil); err != nil {
+			return nil, fmt.Errorf("failed to decode batch result %d: %w", i, err)
+		}
+		if failed {
+			if message == "" {
+				message = fmt.Sprintf("batch item %d failed", i)
+			}
+			results[i] = Result[[]byte]{Err: errors.New(message)}
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,214 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mopeyjellyfish/hookr/testdata/api"
+	"github.com/stretchr/testify/require"
+	"github.com/tinylib/msgp/msgp"
+)
+
+func TestBatchEncoding(t *testing.T) {
+	batch, err := encodeBatch([][]byte{[]byte("a"), nil, []byte("c")})
+	require.NoError(t, err, "failed to encode batch")
+
+	size, rest, err := msgp.ReadArrayHeaderBytes(batch)
+	require.NoError(t, err, "failed to decode batch")
+	require.Equal(t, uint32(3), size)
+	item, _, err := msgp.ReadBytesBytes(rest, nil)
+	require.NoError(t, err, "failed to decode batch item")
+	require.Equal(t, "a", string(item))
+
+	resp := msgp.AppendArrayHeader(nil, 3)
+	resp = msgp.AppendArrayHeader(resp, 2)
+	resp = msgp.AppendNil(resp)
+	resp = msgp.AppendBytes(resp, []byte("ok"))
+	resp = msgp.AppendArrayHeader(resp, 2)
+	resp = msgp.AppendString(resp, "planned failure")
+	resp = msgp.AppendBytes(resp, nil)
+	resp = msgp.AppendArrayHeader(resp, 2)
+	resp = msgp.AppendString(resp, "")
+	resp = msgp.AppendBytes(resp, nil)
+
+	results, err := decodeBatchResults(resp, 3)
+	require.NoError(t, err, "failed to decode batch results")
+	require.Len(t, results, 3)
+	require.NoError(t, results[0].Err)
+	require.Equal(t, "ok", string(results[0].Value))
+	require.EqualError(t, results[1].Err, "planned failure")
+	require.EqualError(t, results[2].Err, "batch item 2 failed", "an empty error message is still a failure")
+
+	_, err = decodeBatchResults([]byte{0xc1}, 1)
+	require.Error(t, err, "expected error when decoding an invalid batch")
+	_, err = decodeBatchResults(msgp.AppendArrayHeader(msgp.AppendArrayHeader(nil, 1), 3), 1)
+	require.Error(t, err, "expected error when a result has the wrong number of fields")
+	_, err = decodeBatchResults(resp, 2)
+	require.EqualError(t, err, "returned 3 results for 2 payloads")
+	_, err = decodeBatchResults([]byte{0xdd, 0xff, 0xff, 0xff, 0xff}, 1)
+	require.EqualError(t, err, "returned 4294967295 results for 1 payloads", "a forged size must be rejected before allocating")
+}
+
+func TestInvokeBatch(t *testing.T) {
+	ctx := context.Background()
+	p, err := New(ctx, WithFile(SIMPLE_WASM), WithHostFns(HostFnByte("helloByte", HelloByte)))
+	require.NoError(t, err, "failed to create module")
+	defer func() {
+		err := p.Close(ctx)
+		require.NoError(t, err, "failed to close module")
+	}()
+
+	require.Empty(t, p.InvokeBatch(ctx, "vowel", nil), "empty batch should return no results")
+
+	results := p.InvokeBatch(ctx, "vowel", [][]byte{[]byte("hookr"), []byte("aeiou"), []byte("xyz")})
+	require.Len(t, results, 3)
+	for i, expected := range []string{"2", "5", "0"} {
+		require.NoError(t, results[i].Err)
+		require.Equal(t, expected, string(results[i].Value), "results should be in input order")
+	}
+
+	results = p.InvokeBatch(ctx, "echoByte", [][]byte{[]byte("Steve"), {}})
+	require.NoError(t, results[0].Err)
+	require.Equal(t, "Hello Steve", string(results[0].Value))
+	require.Error(t, results[1].Err, "errors should be reported per item")
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	results = p.InvokeBatch(cancelled, "vowel", [][]byte{[]byte("a"), []byte("b")})
+	for _, result := range results {
+		require.ErrorIs(t, result.Err, context.Canceled)
+	}
+}
+
+func TestPluginFnAsync(t *testing.T) {
+	ctx := context.Background()
+	p, err := New(ctx, WithFile(SIMPLE_WASM), WithHostFns(HostFnSerial("hello", Hello), HostFnByte("helloByte", HelloByte)))
+	require.NoError(t, err, "failed to create module")
+	defer func() {
+		err := p.Close(ctx)
+		require.NoError(t, err, "failed to close module")
+	}()
+
+	fn, err := PluginFnSerial[*api.EchoRequest, *api.EchoResponse](p, "echo")
+	require.NoError(t, err, "failed to create plugin function")
+
+	futures := make([]*Future[*api.EchoResponse], 10)
+	for i := range futures {
+		futures[i] = fn.CallAsync(ctx, &api.EchoRequest{Data: "Steve"})
+	}
+	for _, f := range futures {
+		resp, err := f.Get(ctx)
+		require.NoError(t, err, "failed to call plugin function")
+		require.Equal(t, "Hello Steve", resp.Data)
+	}
+	<-futures[0].Done()
+
+	expired, cancel := context.WithTimeout(ctx, time.Nanosecond)
+	defer cancel()
+	<-expired.Done()
+	_, err = fn.CallAsync(ctx, &api.EchoRequest{Data: "Steve"}).Get(expired)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	byteFn, err := PluginFnByte(p, "echoByte")
+	require.NoError(t, err, "failed to create plugin function")
+	data, err := byteFn.CallAsync(ctx, []byte("Steve")).Get(ctx)
+	require.NoError(t, err, "failed to call plugin function")
+	require.Equal(t, "Hello Steve", string(data))
+}
+
+func TestPluginFnBatch(t *testing.T) {
+	ctx := context.Background()
+	p, err := New(ctx, WithFile(SIMPLE_WASM), WithHostFns(HostFnSerial("hello", Hello), HostFnByte("helloByte", HelloByte)))
+	require.NoError(t, err, "failed to create module")
+	defer func() {
+		err := p.Close(ctx)
+		require.NoError(t, err, "failed to close module")
+	}()
+
+	fn, err := PluginFnSerial[*api.EchoRequest, *api.EchoResponse](p, "echo")
+	require.NoError(t, err, "failed to create plugin function")
+	results := fn.CallBatch(ctx, []*api.EchoRequest{{Data: "Steve"}, nil, {Data: "Jane"}})
+	require.Len(t, results, 3)
+	require.NoError(t, results[0].Err)
+	require.Equal(t, "Hello Steve", results[0].Value.Data)
+	require.Error(t, results[1].Err, "nil input should fail on its own")
+	require.NoError(t, results[2].Err)
+	require.Equal(t, "Hello Jane", results[2].Value.Data)
+
+	byteFn, err := PluginFnByte(p, "vowel")
+	require.NoError(t, err, "failed to create plugin function")
+	byteResults := byteFn.CallBatch(ctx, [][]byte{[]byte("aa"), []byte("b")})
+	require.Equal(t, "2", string(byteResults[0].Value))
+	require.Equal(t, "0", string(byteResults[1].Value))
+
+	byteResults = PluginFuncByte{}.CallBatch(ctx, [][]byte{[]byte("aa")})
+	require.Error(t, byteResults[0].Err, "expected error without a runtime")
+}
+
+// replyBatch answers the batch sent by the legacy plugin, every payload is
+// answered like reply does.
+func replyBatch(ctx context.Context, batch []byte) ([]byte, error) {
+	size, batch, err := msgp.ReadArrayHeaderBytes(batch)
+	if err != nil {
+		return nil, err
+	}
+	resp := msgp.AppendArrayHeader(nil, size)
+	for range size {
+		var payload []byte
+		if payload, batch, err = msgp.ReadBytesZC(batch); err != nil {
+			return nil, err
+		}
+		out, err := reply(ctx, payload)
+		if err != nil {
+			return nil, err
+		}
+		resp = msgp.AppendArrayHeader(resp, 2)
+		resp = msgp.AppendNil(resp)
+		resp = msgp.AppendBytes(resp, out)
+	}
+	return resp, nil
+}
+
+func TestInvokeBatchCall(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	recorder := NewRecorder(&buf)
+	p, err := New(ctx,
+		WithFile(LEGACY_WASM),
+		WithHostFns(HostFnByte("hello", replyBatch)),
+		WithRecorder(recorder),
+		WithCircuitBreaker(BreakerConfig{Failures: 1, Cooldown: time.Hour}),
+	)
+	require.NoError(t, err, "failed to create module")
+	defer func() {
+		require.NoError(t, p.Close(ctx), "failed to close module")
+	}()
+
+	results := p.InvokeBatch(ctx, "echo", [][]byte{[]byte("a"), []byte("b")})
+	require.NoError(t, results[0].Err)
+	require.Equal(t, "re: a", string(results[0].Value))
+	require.Equal(t, "re: b", string(results[1].Value))
+
+	_, invocations, err := ReadRecording(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err, "failed to read recording")
+	require.Len(t, invocations, 1)
+	require.True(t, invocations[0].Batch, "the batch is recorded as a single call")
+	require.Len(t, invocations[0].HostCalls, 1)
+	report, err := Replay(ctx, bytes.NewReader(buf.Bytes()), WithFile(LEGACY_WASM))
+	require.NoError(t, err, "failed to replay")
+	require.Equal(t, 1, report.Invocations)
+	require.False(t, report.Diverged(), "unexpected divergences: %v", report.Divergences)
+
+	// the batch fails as a whole and opens the circuit of the operation.
+	results = p.InvokeBatch(ctx, "echo", [][]byte{[]byte("fail")})
+	require.ErrorContains(t, results[0].Err, "host failed")
+	results = p.InvokeBatch(ctx, "echo", [][]byte{[]byte("a"), []byte("b")})
+	for _, result := range results {
+		require.ErrorIs(t, result.Err, ErrCircuitOpen)
+	}
+
+	var fn PluginFuncSerial[*api.EchoRequest, *api.EchoResponse]
+	require.Error(t, fn.CallBatch(ctx, []*api.EchoRequest{{}})[0].Err, "expected error without a runtime")
+}
@@ -0,0 +1,244 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mopeyjellyfish/hookr/runtime/logger"
+)
+
+// ErrCircuitOpen is returned without calling the plugin while the circuit breaker
+// of the operation is open.
+var ErrCircuitOpen = errors.New("circuit open")
+
+const (
+	// DefaultBreakerFailures is the number of failures which open a circuit when
+	// BreakerConfig.Failures is not set.
+	DefaultBreakerFailures = 5
+
+	// DefaultBreakerWindow is the window failures are counted in when
+	// BreakerConfig.Window is not set.
+	DefaultBreakerWindow = time.Minute
+
+	// DefaultBreakerCooldown is how long a circuit stays open when
+	// BreakerConfig.Cooldown is not set.
+	DefaultBreakerCooldown = 30 * time.Second
+)
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets all calls through while counting failures.
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen fails all calls with ErrCircuitOpen until the cooldown passed.
+	BreakerOpen
+
+	// BreakerHalfOpen lets a single probe call through, its outcome closes or
+	// reopens the circuit.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("breaker(%d)", int(s))
+	}
+}
+
+// BreakerStateChangeFn is called when the circuit of the named operation changes state.
+type BreakerStateChangeFn func(operation string, from, to BreakerState)
+
+// BreakerConfig configures a circuit breaker, zero values use the defaults.
+type BreakerConfig struct {
+	// Failures within Window which open the circuit.
+	Failures int
+
+	// Window in which failures are counted.
+	Window time.Duration
+
+	// Cooldown is how long the circuit stays open before a probe call is let through.
+	Cooldown time.Duration
+
+	// IsFailure decides whether an error counts as a failure. By default every
+	// error counts, including traps and timeouts, except cancellation by the
+	// caller and vetoes of the plugin, see ErrVetoed. Other errors the plugin
+	// reports count unless IsFailure leaves them out.
+	IsFailure func(err error) bool
+
+	// OnStateChange is called when the circuit changes state, when nil the change
+	// is written to the runtime's logger.
+	OnStateChange BreakerStateChangeFn
+}
+
+// Breaker is a circuit breaker guarding calls to a single plugin operation.
+// It is safe for concurrent use.
+type Breaker struct {
+	operation string
+	cfg       BreakerConfig
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures []time.Time
+	openedAt time.Time
+	probing  bool
+}
+
+// NewBreaker returns a closed circuit breaker for the operation.
+func NewBreaker(operation string, cfg BreakerConfig) *Breaker {
+	if cfg.Failures <= 0 {
+		cfg.Failures = DefaultBreakerFailures
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultBreakerWindow
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = DefaultBreakerCooldown
+	}
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = func(err error) bool {
+			return !errors.Is(err, context.Canceled) && !errors.Is(err, ErrVetoed)
+		}
+	}
+	return &Breaker{operation: operation, cfg: cfg}
+}
+
+// State returns the current state of the circuit.
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Do calls fn unless the circuit is open and records its outcome.
+func (b *Breaker) Do(fn func() error) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := fn()
+	b.record(err)
+	return err
+}
+
+// allow returns ErrCircuitOpen when a call must not be made.
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	from := b.state
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cfg.Cooldown {
+			b.mu.Unlock()
+			return fmt.Errorf("%w for %q", ErrCircuitOpen, b.operation)
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+	case BreakerHalfOpen:
+		if b.probing {
+			b.mu.Unlock()
+			return fmt.Errorf("%w for %q", ErrCircuitOpen, b.operation)
+		}
+		b.probing = true
+	}
+	to := b.state
+	b.mu.Unlock()
+
+	b.changed(from, to)
+	return nil
+}
+
+// record counts the outcome of a call.
+func (b *Breaker) record(err error) {
+	failed := err != nil && b.cfg.IsFailure(err)
+
+	b.mu.Lock()
+	from := b.state
+	now := time.Now()
+	switch b.state {
+	case BreakerHalfOpen:
+		b.probing = false
+		switch {
+		case failed:
+			b.state = BreakerOpen
+			b.openedAt = now
+		case err == nil:
+			b.state = BreakerClosed
+			b.failures = nil
+		}
+	case BreakerClosed:
+		if !failed {
+			break
+		}
+		b.failures = append(b.failures, now)
+		for len(b.failures) > 0 && now.Sub(b.failures[0]) > b.cfg.Window {
+			b.failures = b.failures[1:]
+		}
+		if len(b.failures) >= b.cfg.Failures {
+			b.state = BreakerOpen
+			b.openedAt = now
+			b.failures = nil
+		}
+	}
+	to := b.state
+	b.mu.Unlock()
+
+	b.changed(from, to)
+}
+
+func (b *Breaker) changed(from, to BreakerState) {
+	if from != to && b.cfg.OnStateChange != nil {
+		b.cfg.OnStateChange(b.operation, from, to)
+	}
+}
+
+// withLogger returns cfg reporting state changes to the logger unless it has its own callback.
+func (cfg BreakerConfig) withLogger(log logger.Logger) BreakerConfig {
+	if cfg.OnStateChange == nil && log != nil {
+		cfg.OnStateChange = func(operation string, from, to BreakerState) {
+			log(fmt.Sprintf("circuit breaker for %q changed from %s to %s", operation, from, to))
+		}
+	}
+	return cfg
+}
+
+// breaker returns the circuit breaker of the operation, nil when the runtime
+// has no circuit breakers configured.
+func (e *Runtime) breaker(operation string) *Breaker {
+	if e.breakerCfg == nil {
+		return nil
+	}
+
+	e.breakersMu.Lock()
+	defer e.breakersMu.Unlock()
+	b, ok := e.breakers[operation]
+	if !ok {
+		if e.breakers == nil {
+			e.breakers = make(map[string]*Breaker)
+		}
+		b = NewBreaker(operation, e.breakerCfg.withLogger(e.logger))
+		e.breakers[operation] = b
+	}
+	return b
+}
+
+// BreakerState returns the state of the circuit breaker of the operation.
+// Operations without a circuit breaker, including those not called yet, are
+// always closed.
+func (e *Runtime) BreakerState(operation string) BreakerState {
+	e.breakersMu.Lock()
+	b, ok := e.breakers[operation]
+	e.breakersMu.Unlock()
+	if !ok {
+		return BreakerClosed
+	}
+	return b.State()
+}
@@ -0,0 +1,173 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreaker(t *testing.T) {
+	var mu sync.Mutex
+	var transitions []string
+	b := NewBreaker("op", BreakerConfig{
+		Failures: 2,
+		Cooldown: 20 * time.Millisecond,
+		OnStateChange: func(operation string, from, to BreakerState) {
+			mu.Lock()
+			defer mu.Unlock()
+			transitions = append(transitions, operation+":"+from.String()+"->"+to.String())
+		},
+	})
+	planned := errors.New("planned failure")
+	fail := func() error { return planned }
+	succeed := func() error { return nil }
+
+	require.ErrorIs(t, b.Do(fail), planned)
+	require.ErrorIs(t, b.Do(func() error { return context.Canceled }), context.Canceled)
+	require.Equal(t, BreakerClosed, b.State(), "cancellation should not count as a failure")
+	require.ErrorIs(t, b.Do(func() error { return &VetoError{Reason: "no"} }), ErrVetoed)
+	require.Equal(t, BreakerClosed, b.State(), "a veto should not count as a failure")
+	require.ErrorIs(t, b.Do(fail), planned)
+	require.Equal(t, BreakerOpen, b.State())
+
+	called := false
+	err := b.Do(func() error {
+		called = true
+		return nil
+	})
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.False(t, called, "an open circuit should not call the function")
+
+	time.Sleep(30 * time.Millisecond)
+	require.ErrorIs(t, b.Do(fail), planned, "the probe should be let through")
+	require.Equal(t, BreakerOpen, b.State(), "a failed probe should reopen the circuit")
+	require.ErrorIs(t, b.Do(succeed), ErrCircuitOpen)
+
+	time.Sleep(30 * time.Millisecond)
+	require.NoError(t, b.Do(succeed))
+	require.Equal(t, BreakerClosed, b.State(), "a successful probe should close the circuit")
+
+	require.Equal(t, []string{
+		"op:closed->open",
+		"op:open->half-open",
+		"op:half-open->open",
+		"op:open->half-open",
+		"op:half-open->closed",
+	}, transitions)
+}
+
+func TestBreakerHalfOpenSingleProbe(t *testing.T) {
+	b := NewBreaker("op", BreakerConfig{Failures: 1, Cooldown: time.Millisecond})
+	require.Error(t, b.Do(func() error { return errors.New("planned failure") }))
+	time.Sleep(5 * time.Millisecond)
+
+	probing := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- b.Do(func() error {
+			close(probing)
+			<-release
+			return nil
+		})
+	}()
+	<-probing
+	require.Equal(t, BreakerHalfOpen, b.State())
+	require.ErrorIs(t, b.Do(func() error { return nil }), ErrCircuitOpen, "only one probe should be let through")
+	close(release)
+	require.NoError(t, <-done)
+	require.Equal(t, BreakerClosed, b.State())
+}
+
+func TestBreakerWindow(t *testing.T) {
+	b := NewBreaker("op", BreakerConfig{Failures: 2, Window: 10 * time.Millisecond})
+	require.Error(t, b.Do(func() error { return errors.New("planned failure") }))
+	time.Sleep(20 * time.Millisecond)
+	require.Error(t, b.Do(func() error { return errors.New("planned failure") }))
+	require.Equal(t, BreakerClosed, b.State(), "failures outside of the window should not count")
+}
+
+func TestRuntimeCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+
+	var logs []string
+	plugin, err := New(ctx,
+		WithFile(SIMPLE_WASM),
+		WithLogger(func(msg string) { logs = append(logs, msg) }),
+		WithCircuitBreaker(BreakerConfig{Failures: 2, Cooldown: time.Hour}),
+	)
+	require.NoError(t, err, "failed to create module")
+	defer func() {
+		require.NoError(t, plugin.Close(ctx), "failed to close module")
+	}()
+
+	for range 2 {
+		_, err = plugin.Invoke(ctx, "nope", []byte{0x80})
+		require.Error(t, err)
+		require.NotErrorIs(t, err, ErrCircuitOpen)
+	}
+	_, err = plugin.Invoke(ctx, "nope", []byte{0x80})
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.Equal(t, BreakerOpen, plugin.BreakerState("nope"))
+	require.Contains(t, logs, `circuit breaker for "nope" changed from closed to open`)
+
+	require.Equal(t, BreakerClosed, plugin.BreakerState("vowel"), "an operation not called yet is closed")
+	plugin.breakersMu.Lock()
+	require.Len(t, plugin.breakers, 1, "querying the state should not create a breaker")
+	plugin.breakersMu.Unlock()
+
+	out, err := plugin.Invoke(ctx, "vowel", []byte("hookr"))
+	require.NoError(t, err, "circuits should be per operation")
+	require.Equal(t, "2", string(out))
+	require.Equal(t, BreakerClosed, plugin.BreakerState("vowel"))
+
+	fn, err := PluginFnByte(plugin, "vowel", WithPluginFnBreaker(BreakerConfig{Failures: 1, Cooldown: time.Hour}))
+	require.NoError(t, err)
+	require.NotNil(t, fn.Breaker())
+	_, err = fn.Call(ctx, []byte("hookr"))
+	require.NoError(t, err)
+
+	fn, err = PluginFnByte(plugin, "nope", WithPluginFnBreaker(BreakerConfig{Failures: 1, Cooldown: time.Hour}))
+	require.NoError(t, err)
+	_, err = fn.Call(ctx, []byte{0x80})
+	require.Error(t, err, "the plugin function should use its own circuit")
+	require.NotErrorIs(t, err, ErrCircuitOpen)
+	_, err = fn.Call(ctx, []byte{0x80})
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.Equal(t, BreakerOpen, fn.Breaker().State())
+}
+
+func TestRuntimeCircuitBreakerTraps(t *testing.T) {
+	ctx := context.Background()
+	plugin, err := New(ctx,
+		WithFile(TRAP_WASM),
+		WithLogger(func(string) {}),
+		WithCircuitBreaker(BreakerConfig{Failures: 2, Cooldown: time.Hour}),
+		WithInterruptOnContextDone(),
+	)
+	require.NoError(t, err, "failed to create module")
+	defer func() {
+		require.NoError(t, plugin.Close(ctx), "failed to close module")
+	}()
+
+	for range 2 {
+		_, err = plugin.Invoke(ctx, "unreachable", nil)
+		require.ErrorIs(t, err, ErrTrap)
+	}
+	_, err = plugin.Invoke(ctx, "unreachable", nil)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+
+	for range 2 {
+		timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		_, err = plugin.Invoke(timeout, "loop", nil)
+		cancel()
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	}
+	_, err = plugin.Invoke(ctx, "loop", nil)
+	require.ErrorIs(t, err, ErrCircuitOpen, "timeouts should open the circuit")
+	require.Equal(t, uint64(4), plugin.Restarts(), "an open circuit should not call the plugin")
+}
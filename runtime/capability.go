@@ -0,0 +1,60 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mopeyjellyfish/hookr/runtime/module"
+)
+
+// MaxMemoryPages is the largest memory limit, 4 GiB.
+const MaxMemoryPages = 65536
+
+// ConfigFn is the host function plugins call to read their configuration, see
+// pdk.Config. The payload is the key and the response its value.
+const ConfigFn = "hookr_config"
+
+var (
+	// ErrNotGranted is returned for host calls of functions the plugin was not
+	// granted, see WithAllowedHostFns.
+	ErrNotGranted error = &codedError{message: "host function not granted", code: module.CodeNotGranted}
+
+	// ErrConfigNotSet is returned for configuration keys without a value, see WithConfig.
+	// The plugin sees it as pdk.ErrConfigNotSet.
+	ErrConfigNotSet error = &codedError{message: "config not set", code: module.CodeConfigNotSet}
+)
+
+// codedError is an error the plugin tells apart by its code, see module.CodedError.
+type codedError struct {
+	message string
+	code    uint32
+}
+
+func (e *codedError) Error() string {
+	return e.message
+}
+
+func (e *codedError) ErrorCode() uint32 {
+	return e.code
+}
+
+// granted returns an error unless the plugin may call the host function of the
+// operation. The built-in functions are governed by their own options.
+func (e *Runtime) granted(operation string) error {
+	if e.allowedHostFns == nil || operation == ConfigFn || operation == PluginCallFn {
+		return nil
+	}
+	if _, ok := e.allowedHostFns[operation]; !ok {
+		return fmt.Errorf("%w: %q", ErrNotGranted, operation)
+	}
+	return nil
+}
+
+// configValue handles a ConfigFn host call.
+func (e *Runtime) configValue(_ context.Context, key []byte) ([]byte, error) {
+	value, ok := e.pluginConfig[string(key)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrConfigNotSet, key)
+	}
+	return []byte(value), nil
+}
@@ -0,0 +1,64 @@
+package runtime
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllowedHostFns(t *testing.T) {
+	ctx := context.Background()
+	for _, file := range []string{ABI_WASM, LEGACY_WASM} {
+		denied := newReloadable(t, file, WithAllowedHostFns("other"))
+		_, err := denied.Invoke(ctx, "echo", []byte("a"))
+		require.ErrorContains(t, err, `host function not granted: "hello"`)
+
+		allowed := newReloadable(t, file, WithAllowedHostFns("other", "hello"))
+		out, err := allowed.Invoke(ctx, "echo", []byte("a"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("re: a"), out)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	ctx := context.Background()
+	rt := newReloadable(t, ABI_WASM, WithConfig(map[string]string{"greeting": "hi"}), WithAllowedHostFns())
+
+	value, err := rt.handle(ctx, ConfigFn, []byte("greeting"))
+	require.NoError(t, err, "the config is always granted")
+	require.Equal(t, []byte("hi"), value)
+	_, err = rt.handle(ctx, ConfigFn, []byte("missing"))
+	require.ErrorIs(t, err, ErrConfigNotSet)
+}
+
+func TestCallTimeout(t *testing.T) {
+	ctx := context.Background()
+	rt, err := New(ctx, WithFile(TRAP_WASM), WithCallTimeout(50*time.Millisecond))
+	require.NoError(t, err)
+	defer rt.Close(ctx)
+
+	_, err = rt.Invoke(ctx, "loop", nil)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	_, err = rt.Invoke(ctx, "count", nil)
+	require.NoError(t, err, "the plugin is restarted")
+
+	_, err = New(ctx, WithFile(TRAP_WASM), WithCallTimeout(0))
+	require.Error(t, err, "expected error for a timeout of 0")
+}
+
+func TestMemoryLimitPages(t *testing.T) {
+	ctx := context.Background()
+	rt := newReloadable(t, ABI_WASM, WithMemoryLimitPages(2))
+	mem := rt.plugin.Memory()
+	_, ok := mem.Grow(4)
+	require.False(t, ok, "memory cannot grow beyond the limit")
+	_, ok = mem.Grow(2 - mem.Size()/65536)
+	require.True(t, ok)
+
+	for _, pages := range []uint32{0, MaxMemoryPages + 1} {
+		_, err := New(ctx, WithFile(ABI_WASM), WithMemoryLimitPages(pages))
+		require.Error(t, err, "expected error for a limit of %d pages", pages)
+	}
+}
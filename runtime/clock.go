@@ -0,0 +1,70 @@
+package runtime
+
+import (
+	"encoding/binary"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for plugins, it backs the WASI clocks and sleeps.
+type Clock interface {
+	// Now returns the wall clock time.
+	Now() time.Time
+
+	// Nanotime returns the monotonic time in nanoseconds since an arbitrary point.
+	Nanotime() int64
+
+	// Sleep blocks the plugin for the duration.
+	Sleep(d time.Duration)
+}
+
+// VirtualClock is a Clock which only moves when a plugin sleeps or it is
+// advanced, sleeping returns immediately. It is safe for concurrent use.
+type VirtualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	elapsed time.Duration
+}
+
+// NewVirtualClock returns a VirtualClock starting at start.
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
+}
+
+// Now returns the current virtual time.
+func (c *VirtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Nanotime returns the virtual time elapsed since the clock started.
+func (c *VirtualClock) Nanotime() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.elapsed.Nanoseconds()
+}
+
+// Sleep advances the clock by d without blocking.
+func (c *VirtualClock) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+// Advance moves the clock forward by d, negative durations are ignored.
+func (c *VirtualClock) Advance(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.elapsed += d
+}
+
+// seededReader returns a deterministic random source for the seed.
+func seededReader(seed int64) *rand.ChaCha8 {
+	var key [32]byte
+	binary.LittleEndian.PutUint64(key[:], uint64(seed))
+	return rand.NewChaCha8(key)
+}
@@ -0,0 +1,76 @@
+package runtime
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const CLOCK_WASM = "../testdata/clock/bin/clock.wasm"
+
+// observation is what the clock plugin observed of the host.
+type observation struct {
+	walltime int64
+	nanotime int64
+	random   []byte
+}
+
+func observe(t *testing.T, plugin *Runtime, op string) observation {
+	t.Helper()
+	out, err := plugin.Invoke(context.Background(), op, nil)
+	require.NoError(t, err, "failed to invoke plugin")
+	require.Len(t, out, 24)
+	return observation{
+		walltime: int64(binary.LittleEndian.Uint64(out[0:8])),
+		nanotime: int64(binary.LittleEndian.Uint64(out[8:16])),
+		random:   append([]byte{}, out[16:24]...),
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	run := func(seed int64) []observation {
+		plugin, err := New(ctx, WithFile(CLOCK_WASM), WithDeterministic(seed, start))
+		require.NoError(t, err, "failed to create module")
+		defer func() {
+			require.NoError(t, plugin.Close(ctx), "failed to close module")
+		}()
+		return []observation{observe(t, plugin, "now"), observe(t, plugin, "sleep"), observe(t, plugin, "now")}
+	}
+
+	began := time.Now()
+	first := run(42)
+	require.Less(t, time.Since(began), time.Second, "sleeps should be virtual")
+	require.Equal(t, first, run(42), "runs with the same seed should be identical")
+
+	require.Equal(t, start.UnixNano(), first[0].walltime, "the clock should start at the start time")
+	require.Equal(t, int64(0), first[0].nanotime)
+	require.Equal(t, start.Add(time.Second).UnixNano(), first[1].walltime, "sleeping should advance the clock")
+	require.Equal(t, time.Second.Nanoseconds(), first[1].nanotime)
+	require.Equal(t, first[1].walltime, first[2].walltime, "the clock should only move when sleeping")
+	require.NotEqual(t, first[0].random, first[2].random, "random bytes should not repeat")
+
+	other := run(7)
+	require.NotEqual(t, first[0].random, other[0].random, "different seeds should produce different randomness")
+}
+
+func TestWithClock(t *testing.T) {
+	ctx := context.Background()
+	clock := NewVirtualClock(time.Unix(100, 0))
+	plugin, err := New(ctx, WithFile(CLOCK_WASM), WithClock(clock))
+	require.NoError(t, err, "failed to create module")
+	defer func() {
+		require.NoError(t, plugin.Close(ctx), "failed to close module")
+	}()
+
+	require.Equal(t, int64(100e9), observe(t, plugin, "now").walltime)
+	clock.Advance(time.Minute)
+	clock.Advance(-time.Hour)
+	require.Equal(t, int64(160e9), observe(t, plugin, "now").walltime)
+	require.Equal(t, time.Minute.Nanoseconds(), clock.Nanotime())
+}
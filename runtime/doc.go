@@ -40,6 +40,43 @@ The Runtime can be configured with various options:
 		runtime.WithRandSource(myRandSource),
 	)
 
+# Deterministic Execution
+
+WithDeterministic seeds the plugin's random source and replaces its clocks with
+a virtual clock starting at the given time. The clock only moves when the
+plugin sleeps, which returns immediately, so identical inputs always produce
+identical outputs:
+
+	rt, err := runtime.New(ctx,
+		runtime.WithFile("./plugin.wasm"),
+		runtime.WithDeterministic(42, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+	)
+
+WithClock sets any other Clock, a VirtualClock can also be advanced by the host.
+
+# Record and Replay
+
+A Recorder writes every invocation of a plugin, with the host calls it made,
+to a portable recording of one JSON object per line:
+
+	f, err := os.Create("plugin.recording")
+	recorder := runtime.NewRecorder(f)
+	rt, err := runtime.New(ctx, runtime.WithFile("./plugin.wasm"), runtime.WithRecorder(recorder))
+
+Replay re-runs the recording against a plugin, answering its host calls from the
+recording, and reports every divergence from the recorded behaviour:
+
+	report, err := runtime.Replay(ctx, f, runtime.WithFile("./plugin.wasm"))
+	for _, d := range report.Divergences {
+		log.Println(d)
+	}
+
+Calls made with Invoke and InvokeBatch are recorded, streams are not. The header
+of the recording holds the configuration set with WithConfig. The plugin's reads
+of it are replayed from the recording like its other host calls, so Replay needs
+no WithConfig. Host errors are replayed with the code the plugin saw, such as
+that of a QuotaError.
+
 # Invoking Plugin Functions
 
 Plugin functions can be invoked directly with byte slices:
@@ -50,6 +87,49 @@ Plugin functions can be invoked directly with byte slices:
 	}
 	fmt.Printf("Result: %s\n", result)
 
+The result is copied out of the plugin's memory. InvokeBorrowed returns a view
+into the plugin's memory instead, the plugin cannot be called until it is
+released:
+
+	resp, err := rt.InvokeBorrowed(ctx, "render", payload)
+	if err != nil {
+		log.Fatalf("Function call failed: %v", err)
+	}
+	_, err = w.Write(resp.Bytes())
+	resp.Release()
+
+# Memory ABI
+
+Plugins built with the PDK export an allocator, hookr_alloc and hookr_free. The
+host writes the operation and payload into a request buffer it allocated once
+and reuses, and the plugin returns where its response is, so a plugin call
+crosses into the plugin once. Host responses are written into a buffer the
+plugin passes along with the host call, or allocated in the plugin when they do
+not fit, so a host call crosses into the host once. The host frees only the
+request buffer, with hookr_free when it is replaced by a larger one; the
+response stays owned by the plugin, which keeps it alive until its next call,
+so a Response from InvokeBorrowed is valid until it is released. Plugins
+without an allocator use the request and response functions of the host
+module instead.
+
+# Streaming
+
+Large payloads can be streamed through a plugin function registered with pdk.FnStream,
+the plugin reads and writes chunks so neither side holds the whole payload in memory:
+
+	out, err := rt.InvokeStream(ctx, "transform", inputFile)
+	if err != nil {
+		log.Fatalf("Stream failed: %v", err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(outputFile, out); err != nil {
+		log.Fatalf("Stream failed: %v", err)
+	}
+
+The plugin runs while the output is read, so the output must be drained or closed
+before the Runtime can be used again.
+
 # Type-Safe Function Calls
 
 For type safety, you can create strongly-typed function wrappers:
@@ -76,6 +156,30 @@ For type safety, you can create strongly-typed function wrappers:
 	}
 	fmt.Printf("Output: %s\n", resp.Output)
 
+The request and response types must be pointers. The types they point to are
+inferred, so each call creates its values with new instead of reflection.
+Generic code which cannot name them uses PluginFnSerialWith, FnWith or
+HostFnSerialWith, which take a function creating the values instead.
+
+# Asynchronous and Batched Calls
+
+Calls can run in the background, or be made for many inputs at once:
+
+	future := fn.CallAsync(ctx, &Request{Input: "test data"})
+	resp, err := future.Get(ctx)
+
+	results := fn.CallBatch(ctx, []*Request{{Input: "a"}, {Input: "b"}})
+	for _, result := range results {
+		if result.Err != nil {
+			log.Printf("item failed: %v", result.Err)
+		}
+	}
+
+Plugins built with the PDK process a batch in a single call, otherwise the items
+are invoked one after another. Either way a batch runs on the runtime's single
+plugin instance, manager.Manager.InvokeBatch spreads one over the instances of
+a pooled plugin. The results are always in the order of the inputs.
+
 # Registering Host Functions
 
 Host functions allow the plugin to call back into the host application:
@@ -95,6 +199,21 @@ Host functions allow the plugin to call back into the host application:
 		runtime.WithHostFns(hostFn),
 	)
 
+# Linking Plugins
+
+A Linker lets plugins call each other with pdk.PluginCall. Every plugin is
+registered under a name, calls are only routed along links, which form a
+dependency graph without cycles, and may be limited to some operations:
+
+	linker := runtime.NewLinker(runtime.WithHopTimeout(time.Second))
+	auth, err := runtime.New(ctx, runtime.WithFile("./auth.wasm"), runtime.WithLinker(linker, "auth"))
+	api, err := runtime.New(ctx, runtime.WithFile("./api.wasm"), runtime.WithLinker(linker, "api"))
+
+	err = linker.Link(runtime.Link{Caller: "api", Callee: "auth", Operations: []string{"verify"}})
+
+Each call to another plugin is bounded by the link's timeout, or the hop
+timeout of the Linker.
+
 # File Integrity
 
 To ensure the integrity of WASM files, you can use hashing:
@@ -106,6 +225,209 @@ To ensure the integrity of WASM files, you can use hashing:
 		),
 	)
 
+WithSignature verifies an ed25519 signature of the file with a public key instead.
+
+# Initialization
+
+New calls the plugin's start functions (_start, _initialize and hookr_init).
+A trap, a WASI exit or an error reported with pdk.InitError fails New with an
+*InitError naming the stage which failed. WithInitTimeout bounds how long
+initialization may take:
+
+	rt, err := runtime.New(ctx, runtime.WithFile("./plugin.wasm"), runtime.WithInitTimeout(time.Second))
+	var initErr *runtime.InitError
+	if errors.As(err, &initErr) {
+		log.Printf("plugin failed in %s: %v", initErr.Stage, initErr.Err)
+	}
+
+# Lifecycle
+
+A Runtime moves through the loading, ready, degraded and closed states, changes
+can be observed with a callback:
+
+	rt, err := runtime.New(ctx,
+		runtime.WithFile("./plugin.wasm"),
+		runtime.WithStateChange(func(from, to runtime.State) {
+			log.Printf("plugin %s -> %s", from, to)
+		}),
+		runtime.WithShutdownTimeout(2*time.Second),
+	)
+
+Health calls the plugin's health function (see pdk.OnHealth), an unhealthy plugin
+degrades the runtime until it reports healthy again:
+
+	if err := rt.Health(ctx); err != nil {
+		log.Printf("plugin unhealthy: %v", err)
+	}
+
+Close calls the plugin's shutdown function (see pdk.OnShutdown) before the plugin
+is closed, an interruptible plugin is interrupted once the shutdown timeout
+passes.
+
+Shutdown closes the runtime gracefully: new calls fail with ErrClosed while the
+in-flight calls may finish until the context is done, the remaining calls are
+then cancelled. Close cancels the in-flight calls right away, closing more than
+once is allowed. Borrowed responses and unread streams are released and fail
+with ErrClosed, calls which do not return once cancelled are abandoned and the
+runtime is closed anyway with an error:
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := rt.Shutdown(ctx); err != nil {
+		log.Printf("plugin shutdown: %v", err)
+	}
+
+# Hot Reload
+
+Watch reloads the plugin when its file is replaced, noticing changes through
+inotify on Linux and by polling the file elsewhere. The new module is compiled
+and verified with the options of WithReloadFileOptions, which a file created
+with a hash or a signature requires, then swapped in once the in-flight
+calls finished. When it fails to load, or the smoke test call fails, the
+previous version keeps running:
+
+	w, err := rt.Watch(
+		runtime.WithSmokeTest(runtime.SmokeTest{Operation: "ping"}),
+		runtime.WithReloadFn(func(path string, err error) {
+			log.Printf("reloaded %s: %v", path, err)
+		}),
+	)
+	defer w.Close()
+
+Reload swaps in a module explicitly.
+
+A plugin exporting its state with pdk.OnExportState hands it to the new version
+when that imports it with pdk.OnImportState. The state is tagged with a version,
+a new version rejecting it fails the reload with a *StateError and the previous
+version keeps running.
+
+# Trap Recovery
+
+A plugin which traps, for example by executing unreachable or accessing memory
+out of bounds, is left in an undefined state. The Runtime discards the instance
+and starts a fresh one from the compiled module, running its initialization
+again, before the next call. The trap is returned as a *TrapError holding the
+guest stack:
+
+	_, err := rt.Invoke(ctx, "parse", payload)
+	var trapErr *runtime.TrapError
+	if errors.As(err, &trapErr) {
+		log.Printf("plugin trapped: %v\n%s", err, strings.Join(trapErr.Stack, "\n"))
+	}
+	log.Printf("plugin restarted %d times", rt.Restarts())
+
+WithInterruptOnContextDone interrupts plugin code still running once the
+context of its call is done, so cancellation and deadlines also stop a plugin
+stuck in a loop. It is enabled by the options bounding plugin code with a
+timeout, such as WithCallTimeout. Interrupted plugins are restarted as well.
+
+# Isolation
+
+By default calls share a single plugin instance, so a plugin can keep state
+between calls. WithIsolation runs every call in a fresh instance instead, so
+untrusted plugins cannot observe the memory of earlier calls, at the cost of
+instantiating and initializing the plugin for every call:
+
+	rt, err := runtime.New(ctx, runtime.WithFile("./tenant.wasm"), runtime.WithIsolation())
+
+# Snapshots
+
+WithSnapshot captures the plugin's memory and globals once its initialization
+finished. Instances created later, for isolated calls or after a trap, are
+restored from the snapshot instead of running an expensive initialization
+again:
+
+	rt, err := runtime.New(ctx, runtime.WithFile("./plugin.wasm"), runtime.WithSnapshot(), runtime.WithIsolation())
+
+The snapshot can also be written out as a pre-initialized module with the
+hookr command:
+
+	hookr snapshot -o plugin.init.wasm plugin.wasm
+
+# Circuit Breakers
+
+A circuit breaker stops calling a misbehaving operation. After the configured
+number of failures, traps or timeouts within the window the circuit opens and
+calls fail fast with ErrCircuitOpen. Once the cooldown passed a single probe
+call is let through, its outcome closes or reopens the circuit:
+
+	rt, err := runtime.New(ctx,
+		runtime.WithFile("./plugin.wasm"),
+		runtime.WithCircuitBreaker(runtime.BreakerConfig{
+			Failures: 5,
+			Window:   time.Minute,
+			Cooldown: 30 * time.Second,
+			OnStateChange: func(operation string, from, to runtime.BreakerState) {
+				circuitState.WithLabelValues(operation).Set(float64(to))
+			},
+		}),
+	)
+
+Every operation has its own circuit. A plugin function can use its own
+configuration instead:
+
+	fn, err := runtime.PluginFnByte(rt, "resize", runtime.WithPluginFnBreaker(runtime.BreakerConfig{Failures: 1}))
+
+# Rate Limits and Quotas
+
+Host calls can be limited so a runaway plugin cannot hammer expensive host
+functions. Rate limits are token buckets shared by all invocations of the
+plugin, quotas limit each invocation:
+
+	rt, err := runtime.New(ctx,
+		runtime.WithFile("./plugin.wasm"),
+		runtime.WithRateLimit(runtime.RateLimit{Rate: 100, Burst: 20}),
+		runtime.WithHostFnRateLimit("db.query", runtime.RateLimit{Rate: 10, Burst: 5}),
+		runtime.WithQuota(runtime.Quota{MaxHostCalls: 50, MaxHostBytes: 1 << 20}),
+	)
+
+Refused host calls are not passed to the host function, the plugin receives a
+*QuotaError naming the exceeded limit, which it sees as a pdk.QuotaError.
+
+# Size Limits
+
+WithLimits bounds the data passed between the host and the plugin, each size is
+checked before the data is copied:
+
+	rt, err := runtime.New(ctx,
+		runtime.WithFile("./plugin.wasm"),
+		runtime.WithLimits(runtime.Limits{
+			PluginRequest:  1 << 20,
+			PluginResponse: 4 << 20,
+			HostPayload:    64 << 10,
+			HostResponse:   1 << 20,
+		}),
+	)
+
+	_, err = rt.Invoke(ctx, "render", payload)
+	var sizeErr *runtime.SizeError
+	if errors.As(err, &sizeErr) {
+		log.Printf("%s exceeds %d bytes", sizeErr.Limit, sizeErr.Max)
+	}
+
+Host calls exceeding a limit fail in the plugin, which receives the error. The
+error messages of the plugin count as responses. Streams are not limited, their
+chunks are read and written in place.
+
+# Capabilities and Resources
+
+WithAllowedHostFns grants a plugin a subset of the registered host functions,
+calls of the others fail with ErrNotGranted. WithMemoryLimitPages caps the
+plugin's memory, WithCallTimeout interrupts calls which run too long and
+WithConfig sets the values the plugin reads with pdk.Config:
+
+	rt, err := runtime.New(ctx,
+		runtime.WithFile("./plugin.wasm"),
+		runtime.WithHostFns(httpFn, dbFn),
+		runtime.WithAllowedHostFns(pdk.HTTPHostFn),
+		runtime.WithMemoryLimitPages(256), // 16 MiB
+		runtime.WithCallTimeout(2*time.Second),
+		runtime.WithConfig(map[string]string{"region": "eu-west-1"}),
+	)
+
+WithCompilationCache shares compiled modules between runtimes loading the same
+plugin, see the manager package for loading many plugins at once.
+
 # Memory Management
 
 You can query memory usage of the WASM module:
@@ -1,6 +1,7 @@
 package runtime
 
 import (
+	"crypto/ed25519"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
@@ -103,6 +104,9 @@ type File struct {
 	Name   string
 	hasher Hasher
 	data   []byte
+
+	publicKey ed25519.PublicKey
+	signature []byte
 }
 
 // GetData returns the WasmData for WasmData if the data has already been loaded into memory somewhere else
@@ -133,6 +137,9 @@ func (f *File) Verify() (*File, error) {
 	if f.Path == "" {
 		return nil, errors.New("path is required")
 	}
+	if f.publicKey != nil && len(f.publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key must be %d bytes", ed25519.PublicKeySize)
+	}
 	data, err := f.load()
 	if err != nil {
 		return nil, err
@@ -141,6 +148,9 @@ func (f *File) Verify() (*File, error) {
 	if !f.hasher.IsValid(f.Hash, data) { // optionally check the hash
 		return nil, fmt.Errorf("hash does not match for %s", f.Path)
 	}
+	if f.publicKey != nil && !ed25519.Verify(f.publicKey, data, f.signature) { // optionally check the signature
+		return nil, fmt.Errorf("signature does not match for %s", f.Path)
+	}
 
 	f.data = data
 	return f, nil
@@ -162,6 +172,15 @@ func WithHasher(hasher Hasher) FileOption {
 	}
 }
 
+// WithSignature sets the ed25519 signature of the File's data and the public key
+// verifying it.
+func WithSignature(publicKey ed25519.PublicKey, signature []byte) FileOption {
+	return func(f *File) {
+		f.publicKey = publicKey
+		f.signature = signature
+	}
+}
+
 // NewFile creates a new File instance and verifies it.
 // If the file or name is invalid, an error is returned.
 // Otherwise the *File is returned.
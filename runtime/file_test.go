@@ -1,6 +1,9 @@
 package runtime
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -185,3 +188,19 @@ func TestHasher_Interface(t *testing.T) {
 	var _ Hasher = Sha256Hasher{}
 	var _ Hasher = DefaultHasher{}
 }
+
+func TestWithSignature(t *testing.T) {
+	data, err := os.ReadFile(SIMPLE_WASM)
+	require.NoError(t, err)
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signature := ed25519.Sign(privateKey, data)
+
+	_, err = NewFile(SIMPLE_WASM, WithSignature(publicKey, signature))
+	require.NoError(t, err)
+
+	_, err = NewFile(SIMPLE_WASM, WithSignature(publicKey, ed25519.Sign(privateKey, []byte("other"))))
+	require.ErrorContains(t, err, "signature does not match")
+	_, err = NewFile(SIMPLE_WASM, WithSignature(publicKey[:8], signature))
+	require.ErrorContains(t, err, "public key must be 32 bytes")
+}
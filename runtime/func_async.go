@@ -0,0 +1,40 @@
+package runtime
+
+import (
+	"context"
+)
+
+// Future is the pending result of an asynchronous plugin call.
+type Future[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+}
+
+// newFuture runs fn in the background and returns a Future for its result.
+func newFuture[T any](fn func() (T, error)) *Future[T] {
+	f := &Future[T]{done: make(chan struct{})}
+	go func() {
+		defer close(f.done)
+		f.value, f.err = fn()
+	}()
+	return f
+}
+
+// Done returns a channel which is closed once the result is available.
+func (f *Future[T]) Done() <-chan struct{} {
+	return f.done
+}
+
+// Get waits for the result of the call. If ctx is done first its error is
+// returned, the call itself keeps running until the context it was started
+// with is cancelled.
+func (f *Future[T]) Get(ctx context.Context) (T, error) {
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
@@ -7,8 +7,9 @@ import (
 
 // PluginFuncByte is a function that takes a byte slice and returns a byte slice
 type PluginFuncByte struct {
-	Name string
-	rt   *Runtime
+	Name    string
+	rt      *Runtime
+	breaker *Breaker
 }
 
 // Call takes an input of type In and returns an output of type Out
@@ -17,18 +18,40 @@ func (p PluginFuncByte) Call(ctx context.Context, input []byte) ([]byte, error)
 	if p.rt == nil {
 		return nil, errors.New("engine cannot be nil")
 	}
-	out, err := p.rt.Invoke(ctx, p.Name, input)
+	out, err := invokePluginFn(ctx, p.rt, p.breaker, p.Name, input)
 	if err != nil || out == nil {
 		return nil, err
 	}
 	return out, nil
 }
 
+// Breaker returns the plugin function's own circuit breaker, nil when it has none.
+func (p PluginFuncByte) Breaker() *Breaker {
+	return p.breaker
+}
+
+// CallAsync calls the plugin function in the background and returns a Future for the result.
+func (p PluginFuncByte) CallAsync(ctx context.Context, input []byte) *Future[[]byte] {
+	return newFuture(func() ([]byte, error) {
+		return p.Call(ctx, input)
+	})
+}
+
+// CallBatch calls the plugin function for each input, see Runtime.InvokeBatch.
+// The results are in the same order as the inputs and each carries its own error.
+func (p PluginFuncByte) CallBatch(ctx context.Context, inputs [][]byte) []Result[[]byte] {
+	if p.rt == nil {
+		return failBatch[[]byte](len(inputs), errors.New("engine cannot be nil"))
+	}
+	return invokePluginFnBatch(ctx, p.rt, p.breaker, p.Name, inputs)
+}
+
 // PluginFnByte creates a new PluginFunc with the given name and engine.
 // This will always be a byte slice in and out.
 func PluginFnByte(
 	rt *Runtime,
 	name string,
+	opts ...PluginFnOption,
 ) (*PluginFuncByte, error) {
 	if rt == nil {
 		return nil, errors.New("engine cannot be nil")
@@ -36,7 +59,7 @@ func PluginFnByte(
 	if name == "" {
 		return nil, errors.New("name cannot be empty")
 	}
-	pFn := &PluginFuncByte{Name: name, rt: rt}
+	pFn := &PluginFuncByte{Name: name, rt: rt, breaker: pluginFnBreaker(rt, name, opts)}
 	return pFn, nil
 }
 
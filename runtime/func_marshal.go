@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"reflect"
 
 	"github.com/mopeyjellyfish/hookr/testdata/api"
 )
@@ -16,26 +15,40 @@ type Unmarshaler interface {
 	UnmarshalMsg([]byte) ([]byte, error)
 }
 
+// PtrUnmarshaler is an Unmarshaler which is a pointer to T, so a value to
+// unmarshal into can be created with new(T) instead of reflection.
+type PtrUnmarshaler[T any] interface {
+	*T
+	Unmarshaler
+}
+
+// PtrMarshaler is a Marshaler which is a pointer to T, so a nil input can be
+// told apart without reflection.
+type PtrMarshaler[T any] interface {
+	*T
+	Marshaler
+}
+
 type PluginFuncSerial[In Marshaler, Out Unmarshaler] struct {
-	Name string
-	rt   *Runtime
+	Name    string
+	rt      *Runtime
+	breaker *Breaker
+	newOut  func() Out
+	isNil   func(In) bool
 }
 
 func (p *PluginFuncSerial[In, Out]) Call(ctx context.Context, input In) (Out, error) {
-	var dataInput []byte
 	var zero Out
-	var err error
-
-	if reflect.ValueOf(input).Kind() == reflect.Ptr && reflect.ValueOf(input).IsNil() {
-		return zero, errors.New("input cannot be nil")
+	if p.rt == nil {
+		return zero, errors.New("engine cannot be nil")
 	}
 
-	dataInput, err = input.MarshalMsg(nil)
+	dataInput, err := p.marshal(input)
 	if err != nil {
-		return zero, fmt.Errorf("failed to marshal input: %w", err)
+		return zero, err
 	}
 
-	d, err := p.rt.Invoke(ctx, p.Name, dataInput)
+	d, err := invokePluginFn(ctx, p.rt, p.breaker, p.Name, dataInput)
 	if err != nil {
 		return zero, err
 	}
@@ -43,33 +56,100 @@ func (p *PluginFuncSerial[In, Out]) Call(ctx context.Context, input In) (Out, er
 		return zero, nil
 	}
 
-	t := reflect.TypeOf(zero)
+	return p.unmarshal(d)
+}
+
+// Breaker returns the plugin function's own circuit breaker, nil when it has none.
+func (p *PluginFuncSerial[In, Out]) Breaker() *Breaker {
+	return p.breaker
+}
+
+// CallAsync calls the plugin function in the background and returns a Future for the result.
+func (p *PluginFuncSerial[In, Out]) CallAsync(ctx context.Context, input In) *Future[Out] {
+	return newFuture(func() (Out, error) {
+		return p.Call(ctx, input)
+	})
+}
+
+// CallBatch calls the plugin function for each input, see Runtime.InvokeBatch.
+// The results are in the same order as the inputs and each carries its own error.
+func (p *PluginFuncSerial[In, Out]) CallBatch(ctx context.Context, inputs []In) []Result[Out] {
+	if p.rt == nil {
+		return failBatch[Out](len(inputs), errors.New("engine cannot be nil"))
+	}
+	results := make([]Result[Out], len(inputs))
+	payloads := make([][]byte, 0, len(inputs))
+	indexes := make([]int, 0, len(inputs))
+	for i, input := range inputs {
+		payload, err := p.marshal(input)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		payloads = append(payloads, payload)
+		indexes = append(indexes, i)
+	}
 
-	var output Out
+	for j, out := range invokePluginFnBatch(ctx, p.rt, p.breaker, p.Name, payloads) {
+		i := indexes[j]
+		if out.Err != nil {
+			results[i].Err = out.Err
+			continue
+		}
+		if out.Value != nil {
+			results[i].Value, results[i].Err = p.unmarshal(out.Value)
+		}
+	}
+	return results
+}
 
-	// If it's a pointer type, create a new instance
-	if t != nil && t.Kind() == reflect.Ptr {
-		// Create a new instance of the element type
-		elemType := t.Elem()
-		newElem := reflect.New(elemType)
-		output = newElem.Interface().(Out)
-	} else {
-		// For non-pointer types, use the zero value
-		output = zero
+func (p *PluginFuncSerial[In, Out]) marshal(input In) ([]byte, error) {
+	if p.isNil != nil && p.isNil(input) {
+		return nil, errors.New("input cannot be nil")
 	}
 
-	_, err = output.UnmarshalMsg(d) // unmarshal the output
+	dataInput, err := input.MarshalMsg(nil)
 	if err != nil {
+		return nil, fmt.Errorf("failed to marshal input: %w", err)
+	}
+	return dataInput, nil
+}
+
+func (p *PluginFuncSerial[In, Out]) unmarshal(d []byte) (Out, error) {
+	output := p.newOut()
+	if _, err := output.UnmarshalMsg(d); err != nil { // unmarshal the output
+		var zero Out
 		return zero, fmt.Errorf("failed to unmarshal output: %w", err)
 	}
 	return output, nil
 }
 
 // Will create a new PluginFunc with the given name and engine.
-// This is used to register the function with the host
-func PluginFnSerial[In Marshaler, Out Unmarshaler](
+// This is used to register the function with the host.
+// In and Out must be pointers, the types they point to are inferred:
+//
+//	fn, err := runtime.PluginFnSerial[*api.EchoRequest, *api.EchoResponse](rt, "echo")
+func PluginFnSerial[In PtrMarshaler[I], Out PtrUnmarshaler[T], I, T any](
 	rt *Runtime,
 	name string,
+	opts ...PluginFnOption,
+) (*PluginFuncSerial[In, Out], error) {
+	pFn, err := PluginFnSerialWith[In](rt, name, newPtr[Out], opts...)
+	if err != nil {
+		return nil, err
+	}
+	pFn.isNil = isNil[In]
+	return pFn, nil
+}
+
+// PluginFnSerialWith is PluginFnSerial for types it cannot infer, such as the
+// type parameters of a generic caller, the output of each call is created with
+// newOut. The input is not checked for nil.
+func PluginFnSerialWith[In Marshaler, Out Unmarshaler](
+	rt *Runtime,
+	name string,
+	newOut func() Out,
+	opts ...PluginFnOption,
 ) (*PluginFuncSerial[In, Out], error) {
 	if rt == nil {
 		return nil, errors.New("engine cannot be nil")
@@ -77,10 +157,23 @@ func PluginFnSerial[In Marshaler, Out Unmarshaler](
 	if name == "" {
 		return nil, errors.New("name cannot be empty")
 	}
-	pFn := &PluginFuncSerial[In, Out]{Name: name, rt: rt}
+	if newOut == nil {
+		return nil, errors.New("newOut cannot be nil")
+	}
+	pFn := &PluginFuncSerial[In, Out]{Name: name, rt: rt, breaker: pluginFnBreaker(rt, name, opts), newOut: newOut}
 	return pFn, nil
 }
 
+// newPtr returns a new T for PT, a pointer to T.
+func newPtr[PT PtrUnmarshaler[T], T any]() PT {
+	return PT(new(T))
+}
+
+// isNil reports whether p is a nil pointer.
+func isNil[PT PtrMarshaler[T], T any](p PT) bool {
+	return p == nil
+}
+
 // CallFnT is a generic function that accepts and returns specific types
 // It handles marshaling/unmarshaling automatically
 type CallFnT[In Unmarshaler, Out Marshaler] func(ctx context.Context, input In) (Out, error)
@@ -88,27 +181,17 @@ type CallFnT[In Unmarshaler, Out Marshaler] func(ctx context.Context, input In)
 // Fn converts a strongly-typed GoFn to a byte-based CallFn allowing WASM plugins to call it.
 // This allows for defining a strongly typed function, which can be called from WASM
 // that will use a byte slice for input and output for communication.
-func Fn[In Unmarshaler, Out Marshaler](fn CallFnT[In, Out]) CallFn {
-	return func(ctx context.Context, payload []byte) ([]byte, error) {
-		// Unmarshal the input from bytes
-		var input In
-
-		var zero In
-		t := reflect.TypeOf(zero)
-
-		// If it's a pointer type, create a new instance
-		if t != nil && t.Kind() == reflect.Ptr {
-			// Create a new instance of the element type
-			elemType := t.Elem()
-			newElem := reflect.New(elemType)
-			input = newElem.Interface().(In)
-		} else {
-			// For non-pointer types, use the zero value
-			input = zero
-		}
+// In must be a pointer, the type it points to is inferred.
+func Fn[In PtrUnmarshaler[T], Out Marshaler, T any](fn CallFnT[In, Out]) CallFn {
+	return FnWith(newPtr[In], fn)
+}
 
-		_, err := input.UnmarshalMsg(payload) // unmarshal the input
-		if err != nil {
+// FnWith is Fn for types it cannot infer, such as the type parameters of a
+// generic caller, the input of each call is created with newIn.
+func FnWith[In Unmarshaler, Out Marshaler](newIn func() In, fn CallFnT[In, Out]) CallFn {
+	return func(ctx context.Context, payload []byte) ([]byte, error) {
+		input := newIn()
+		if _, err := input.UnmarshalMsg(payload); err != nil { // unmarshal the input
 			return nil, err
 		}
 
@@ -126,18 +209,27 @@ func Fn[In Unmarshaler, Out Marshaler](fn CallFnT[In, Out]) CallFn {
 // This is used to register the function with the host
 type HostFunction[In Unmarshaler, Out Marshaler] struct {
 	name string
-	fn   CallFnT[In, Out]
+	fn   CallFn
 }
 
 func (f *HostFunction[In, Out]) Fn() (name string, fn CallFn) {
-	return f.name, Fn(f.fn)
+	return f.name, f.fn
+}
+
+func HostFnSerial[In PtrUnmarshaler[T], Out Marshaler, T any](
+	name string,
+	fn CallFnT[In, Out],
+) *HostFunction[In, Out] {
+	return &HostFunction[In, Out]{name: name, fn: Fn(fn)}
 }
 
-func HostFnSerial[In Unmarshaler, Out Marshaler](
+// HostFnSerialWith is HostFnSerial for types it cannot infer, see FnWith.
+func HostFnSerialWith[In Unmarshaler, Out Marshaler](
 	name string,
+	newIn func() In,
 	fn CallFnT[In, Out],
 ) *HostFunction[In, Out] {
-	return &HostFunction[In, Out]{name: name, fn: fn}
+	return &HostFunction[In, Out]{name: name, fn: FnWith(newIn, fn)}
 }
 
 var _ HostFunc = &HostFunction[*api.EchoRequest, api.EchoResponse]{} // Compile time check to ensure HostFunction implements HostFunc
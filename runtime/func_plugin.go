@@ -9,3 +9,47 @@ type PluginFunc[In, Out any] interface {
 	// It returns an error if the call fails
 	Call(ctx context.Context, input In) (Out, error)
 }
+
+// PluginFnOption configures a plugin function created with PluginFnByte or PluginFnSerial.
+type PluginFnOption func(*pluginFnOptions)
+
+type pluginFnOptions struct {
+	breaker *BreakerConfig
+}
+
+// WithPluginFnBreaker guards the plugin function with its own circuit breaker,
+// replacing the one configured for the runtime with WithCircuitBreaker.
+func WithPluginFnBreaker(cfg BreakerConfig) PluginFnOption {
+	return func(o *pluginFnOptions) {
+		o.breaker = &cfg
+	}
+}
+
+// pluginFnBreaker returns the circuit breaker configured with the options, if any.
+func pluginFnBreaker(rt *Runtime, name string, opts []PluginFnOption) *Breaker {
+	o := pluginFnOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.breaker == nil {
+		return nil
+	}
+	return NewBreaker(name, o.breaker.withLogger(rt.logger))
+}
+
+// invokePluginFn calls the plugin function guarded by its own circuit breaker, or the runtime's when it has none.
+func invokePluginFn(ctx context.Context, rt *Runtime, b *Breaker, name string, payload []byte) ([]byte, error) {
+	if b == nil {
+		return rt.Invoke(ctx, name, payload)
+	}
+	return rt.invokeWith(ctx, b, name, payload)
+}
+
+// invokePluginFnBatch calls the plugin function for each payload guarded by its own circuit breaker, or the runtime's
+// when it has none.
+func invokePluginFnBatch(ctx context.Context, rt *Runtime, b *Breaker, name string, payloads [][]byte) []Result[[]byte] {
+	if b == nil {
+		return rt.InvokeBatch(ctx, name, payloads)
+	}
+	return rt.invokeBatchWith(ctx, b, name, payloads)
+}
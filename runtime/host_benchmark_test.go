@@ -2,6 +2,7 @@ package runtime
 
 import (
 	"context"
+	"reflect"
 	"testing"
 
 	"github.com/mopeyjellyfish/hookr/testdata/api"
@@ -58,12 +59,97 @@ func BenchmarkInvokeMsgP(b *testing.B) {
 	require.NotNil(b, d, "plugin function should return a value")
 	b.ResetTimer() // Reset timer to exclude setup time
 	b.Run("Echo", func(b *testing.B) {
+		b.ReportAllocs()
 		for i := 0; i < b.N; i++ {
 			_, _ = fn.Call(context.Background(), payload)
 		}
 	})
 }
 
+// BenchmarkPluginFnSerial compares plugin calls creating their output and
+// checking their input for nil with generics against doing it with reflection.
+func BenchmarkPluginFnSerial(b *testing.B) {
+	ctx := context.Background()
+	p, err := New(ctx, WithFile(SIMPLE_WASM), WithHostFns(HostFnSerial("hello", Hello)))
+	require.NoError(b, err, "failed to create module")
+	defer func() {
+		require.NoError(b, p.Close(ctx), "failed to close module")
+	}()
+
+	payload := &api.EchoRequest{
+		Data: "Who controls the past controls the future; who controls the present controls the past.",
+	}
+	generic, err := PluginFnSerial[*api.EchoRequest, *api.EchoResponse](p, "echo")
+	require.NoError(b, err, "failed to create plugin function")
+	reflected, err := PluginFnSerialWith[*api.EchoRequest](p, "echo", newReflect[*api.EchoResponse])
+	require.NoError(b, err, "failed to create plugin function")
+	reflected.isNil = isNilReflect[*api.EchoRequest]
+
+	fns := []struct {
+		name string
+		fn   *PluginFuncSerial[*api.EchoRequest, *api.EchoResponse]
+	}{
+		{"Generic", generic},
+		{"Reflect", reflected},
+	}
+	for _, f := range fns {
+		fn := f.fn
+		_, err := fn.Call(ctx, payload) // confirm the call works
+		require.NoError(b, err, "failed to call plugin function")
+		b.Run(f.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, _ = fn.Call(ctx, payload)
+			}
+		})
+	}
+}
+
+// BenchmarkHostFnSerial compares host functions creating their input with
+// generics against creating it with reflection.
+func BenchmarkHostFnSerial(b *testing.B) {
+	ctx := context.Background()
+	payload, err := (&api.HelloRequest{Msg: "Steve"}).MarshalMsg(nil)
+	require.NoError(b, err, "failed to marshal input")
+
+	_, generic := HostFnSerial("hello", Hello).Fn()
+	_, reflected := HostFnSerialWith("hello", newReflect[*api.HelloRequest], Hello).Fn()
+	fns := []struct {
+		name string
+		fn   CallFn
+	}{
+		{"Generic", generic},
+		{"Reflect", reflected},
+	}
+	for _, f := range fns {
+		fn := f.fn
+		_, err := fn(ctx, payload) // confirm the call works
+		require.NoError(b, err, "failed to call host function")
+		b.Run(f.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, _ = fn(ctx, payload)
+			}
+		})
+	}
+}
+
+// newReflect creates a T with reflection, as serial functions did before they
+// used generics.
+func newReflect[T any]() T {
+	var zero T
+	if t := reflect.TypeOf(zero); t != nil && t.Kind() == reflect.Ptr {
+		return reflect.New(t.Elem()).Interface().(T)
+	}
+	return zero
+}
+
+// isNilReflect reports whether v is a nil pointer with reflection.
+func isNilReflect[T any](v T) bool {
+	rv := reflect.ValueOf(v)
+	return !rv.IsValid() || (rv.Kind() == reflect.Ptr && rv.IsNil())
+}
+
 func BenchmarkInvokeBytes(b *testing.B) {
 	ctx := context.Background()
 	hostFn := HostFnByte("helloByte", HelloByte)
@@ -91,3 +177,33 @@ func BenchmarkInvokeBytes(b *testing.B) {
 		}
 	})
 }
+
+func BenchmarkInvokeIsolation(b *testing.B) {
+	ctx := context.Background()
+	payload := []byte(
+		"Who controls the past controls the future; who controls the present controls the past.",
+	)
+	modes := []struct {
+		name string
+		opts []Option
+	}{
+		{"Shared", nil},
+		{"PerCall", []Option{WithIsolation()}},
+		{"PerCallSnapshot", []Option{WithIsolation(), WithSnapshot()}},
+	}
+	for _, mode := range modes {
+		p, err := New(ctx, append([]Option{WithFile(SIMPLE_WASM)}, mode.opts...)...)
+		require.NoError(b, err, "failed to create module")
+		fn, err := PluginFnByte(p, "vowel")
+		require.NoError(b, err, "failed to create plugin function")
+		_, err = fn.Call(ctx, payload) // confirm the call works
+		require.NoError(b, err, "failed to call plugin function")
+
+		b.Run(mode.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, _ = fn.Call(ctx, payload)
+			}
+		})
+		require.NoError(b, p.Close(ctx), "failed to close module")
+	}
+}
@@ -0,0 +1,278 @@
+// Package httphost provides an optional host module which performs outbound
+// HTTP requests on behalf of plugins.
+//
+// Plugins never get raw sockets, instead they send a pdk.HTTPRequest to the
+// host which validates it against the configured allowlists and executes it
+// using an *http.Client owned by the host:
+//
+//	httpFn := httphost.New(
+//		httphost.WithAllowedURLs("https://api.internal/v1/"),
+//		httphost.WithAllowedMethods(http.MethodGet),
+//		httphost.WithTimeout(2*time.Second),
+//	)
+//
+//	rt, err := runtime.New(ctx,
+//		runtime.WithFile("./plugin.wasm"),
+//		runtime.WithHostFns(httpFn),
+//	)
+//
+// Nothing is reachable unless it is allowed by WithAllowedURLs or WithAllowedHosts.
+package httphost
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mopeyjellyfish/hookr/pdk"
+	"github.com/mopeyjellyfish/hookr/runtime"
+)
+
+const (
+	// DefaultTimeout is the timeout applied to a request when none is configured.
+	DefaultTimeout = 30 * time.Second
+
+	// DefaultMaxResponseSize is the largest response body returned to a plugin when none is configured.
+	DefaultMaxResponseSize = 1 << 20
+)
+
+var (
+	// ErrNotAllowed is returned when a request is rejected by the allowlists.
+	ErrNotAllowed = errors.New("request not allowed")
+
+	// ErrResponseTooLarge is returned when the response body exceeds the configured limit.
+	ErrResponseTooLarge = errors.New("response too large")
+)
+
+// defaultRedactedHeaders are never passed between a plugin and the upstream server.
+var defaultRedactedHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization", "Set-Cookie"}
+
+// Module executes HTTP requests for plugins. It implements runtime.HostFunc so
+// it can be registered with runtime.WithHostFns. Register a separate Module per
+// plugin to give each plugin its own allowlists and timeout.
+type Module struct {
+	client          *http.Client
+	urls            []*url.URL
+	hosts           map[string]struct{}
+	methods         map[string]struct{}
+	redacted        map[string]struct{}
+	maxResponseSize int64
+	timeout         time.Duration
+}
+
+type Option func(*Module)
+
+// WithClient sets the client used to perform requests. The client is copied so
+// redirects can be checked against the allowlists. A nil client uses
+// http.DefaultClient.
+func WithClient(client *http.Client) Option {
+	return func(m *Module) {
+		if client == nil {
+			client = http.DefaultClient
+		}
+		m.client = client
+	}
+}
+
+// WithAllowedURLs allows requests to URLs which share the scheme and host of one
+// of the given URLs and whose path starts with its path.
+// Invalid URLs are ignored.
+func WithAllowedURLs(urls ...string) Option {
+	return func(m *Module) {
+		for _, raw := range urls {
+			u, err := url.Parse(raw)
+			if err != nil || u.Host == "" {
+				continue
+			}
+			m.urls = append(m.urls, u)
+		}
+	}
+}
+
+// WithAllowedHosts allows requests to any path on the given hosts. A host
+// includes its port when it is not the default for the scheme, e.g. "localhost:8080".
+func WithAllowedHosts(hosts ...string) Option {
+	return func(m *Module) {
+		for _, host := range hosts {
+			m.hosts[strings.ToLower(host)] = struct{}{}
+		}
+	}
+}
+
+// WithAllowedMethods restricts the HTTP methods a plugin may use.
+// All methods are allowed when no methods are configured.
+func WithAllowedMethods(methods ...string) Option {
+	return func(m *Module) {
+		for _, method := range methods {
+			m.methods[strings.ToUpper(method)] = struct{}{}
+		}
+	}
+}
+
+// WithRedactedHeaders adds headers which are removed from requests made by the
+// plugin and from responses before they are returned to the plugin.
+// Authorization, Cookie, Proxy-Authorization and Set-Cookie are always redacted.
+func WithRedactedHeaders(headers ...string) Option {
+	return func(m *Module) {
+		for _, h := range headers {
+			m.redacted[http.CanonicalHeaderKey(h)] = struct{}{}
+		}
+	}
+}
+
+// WithMaxResponseSize sets the largest response body in bytes returned to the plugin.
+func WithMaxResponseSize(size int64) Option {
+	return func(m *Module) {
+		m.maxResponseSize = size
+	}
+}
+
+// WithTimeout sets the timeout for a single request, including reading the response body.
+func WithTimeout(timeout time.Duration) Option {
+	return func(m *Module) {
+		m.timeout = timeout
+	}
+}
+
+// New creates a new HTTP host module.
+func New(opts ...Option) *Module {
+	m := &Module{
+		client:          http.DefaultClient,
+		hosts:           make(map[string]struct{}),
+		methods:         make(map[string]struct{}),
+		redacted:        make(map[string]struct{}),
+		maxResponseSize: DefaultMaxResponseSize,
+		timeout:         DefaultTimeout,
+	}
+	WithRedactedHeaders(defaultRedactedHeaders...)(m)
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	// Copy the client so redirects cannot escape the allowlists.
+	client := *m.client
+	checkRedirect := client.CheckRedirect
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if err := m.allowed(req.Method, req.URL); err != nil {
+			return err
+		}
+		if checkRedirect != nil {
+			return checkRedirect(req, via)
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+	m.client = &client
+	return m
+}
+
+// Fn returns the name and function to be called.
+// This is used to register the module with the host.
+func (m *Module) Fn() (name string, fn runtime.CallFn) {
+	return runtime.HostFnSerial(pdk.HTTPHostFn, m.Do).Fn()
+}
+
+// Do validates and executes the request, returning the response to hand to the plugin.
+func (m *Module) Do(ctx context.Context, req *pdk.HTTPRequest) (*pdk.HTTPResponse, error) {
+	method := strings.ToUpper(req.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url %q: %w", req.URL, err)
+	}
+	if err := m.allowed(method, u); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(req.Body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for name, values := range req.Headers {
+		if m.isRedacted(name) {
+			continue
+		}
+		for _, v := range values {
+			httpReq.Header.Add(name, v)
+		}
+	}
+
+	resp, err := m.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("request to %s failed: %w", u.Redacted(), err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, m.maxResponseSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response from %s: %w", u.Redacted(), err)
+	}
+	if int64(len(body)) > m.maxResponseSize {
+		return nil, fmt.Errorf("%w: limit is %d bytes", ErrResponseTooLarge, m.maxResponseSize)
+	}
+
+	headers := make(map[string][]string, len(resp.Header))
+	for name, values := range resp.Header {
+		if !m.isRedacted(name) {
+			headers[name] = values
+		}
+	}
+	return &pdk.HTTPResponse{StatusCode: resp.StatusCode, Headers: headers, Body: body}, nil
+}
+
+// allowed checks the method and URL against the configured allowlists.
+func (m *Module) allowed(method string, u *url.URL) error {
+	if len(m.methods) > 0 {
+		if _, ok := m.methods[method]; !ok {
+			return fmt.Errorf("%w: method %s", ErrNotAllowed, method)
+		}
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme %q", ErrNotAllowed, u.Scheme)
+	}
+	if segments := "/" + u.Path + "/"; strings.Contains(segments, "/../") || strings.Contains(segments, "/./") {
+		return fmt.Errorf("%w: relative path %q", ErrNotAllowed, u.Path)
+	}
+	host := strings.ToLower(u.Host)
+	if _, ok := m.hosts[host]; ok {
+		return nil
+	}
+	for _, allowed := range m.urls {
+		if allowed.Scheme == u.Scheme && strings.EqualFold(allowed.Host, host) && hasPathPrefix(u.Path, allowed.Path) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrNotAllowed, u.Redacted())
+}
+
+func (m *Module) isRedacted(header string) bool {
+	_, ok := m.redacted[http.CanonicalHeaderKey(header)]
+	return ok
+}
+
+// hasPathPrefix reports whether path is prefix or is below it, so "/v1" does
+// not allow "/v10".
+func hasPathPrefix(path, prefix string) bool {
+	if prefix == "" || prefix == "/" || path == prefix {
+		return true
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return strings.HasPrefix(path, prefix)
+}
+
+var _ runtime.HostFunc = &Module{} // ensure Module implements the HostFunc interface
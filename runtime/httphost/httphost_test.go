@@ -0,0 +1,144 @@
+package httphost
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mopeyjellyfish/hookr/pdk"
+	"github.com/stretchr/testify/require"
+	"github.com/tinylib/msgp/msgp"
+)
+
+func newServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/echo", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Header().Set("X-Echo-Auth", r.Header.Get("Authorization"))
+		w.Header().Set("X-Echo-Custom", r.Header.Get("X-Custom"))
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(r.Method + " echo"))
+	})
+	mux.HandleFunc("/v1/large", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strings.Repeat("a", 2048)))
+	})
+	mux.HandleFunc("/v1/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	})
+	mux.HandleFunc("/v1/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/admin", http.StatusFound)
+	})
+	mux.HandleFunc("/admin", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("admin"))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestModuleDo(t *testing.T) {
+	srv := newServer(t)
+	m := New(WithAllowedURLs(srv.URL+"/v1"), WithRedactedHeaders("X-Custom"))
+
+	resp, err := m.Do(context.Background(), &pdk.HTTPRequest{
+		Method: http.MethodPost,
+		URL:    srv.URL + "/v1/echo",
+		Headers: map[string][]string{
+			"Authorization": {"Bearer plugin"},
+			"X-Custom":      {"value"},
+		},
+	})
+	require.NoError(t, err, "request should be allowed")
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, "POST echo", string(resp.Body))
+	require.NotContains(t, resp.Headers, "Set-Cookie", "response headers should be redacted")
+	require.Equal(t, []string{""}, resp.Headers["X-Echo-Auth"], "request headers should be redacted")
+	require.Equal(t, []string{""}, resp.Headers["X-Echo-Custom"], "custom headers should be redacted")
+}
+
+func TestModuleNilClient(t *testing.T) {
+	srv := newServer(t)
+	m := New(WithClient(nil), WithAllowedURLs(srv.URL+"/v1"))
+
+	resp, err := m.Do(context.Background(), &pdk.HTTPRequest{Method: http.MethodGet, URL: srv.URL + "/v1/echo"})
+	require.NoError(t, err, "a nil client should fall back to the default client")
+	require.Equal(t, "GET echo", string(resp.Body))
+}
+
+func TestModuleNotAllowed(t *testing.T) {
+	srv := newServer(t)
+	tests := []struct {
+		name   string
+		opts   []Option
+		method string
+		url    string
+	}{
+		{"no allowlist", nil, http.MethodGet, srv.URL + "/v1/echo"},
+		{"outside prefix", []Option{WithAllowedURLs(srv.URL + "/v1")}, http.MethodGet, srv.URL + "/admin"},
+		{"prefix boundary", []Option{WithAllowedURLs(srv.URL + "/v1")}, http.MethodGet, srv.URL + "/v10"},
+		{"path traversal", []Option{WithAllowedURLs(srv.URL + "/v1")}, http.MethodGet, srv.URL + "/v1/../admin"},
+		{"other host", []Option{WithAllowedHosts("example.com")}, http.MethodGet, srv.URL + "/v1/echo"},
+		{"scheme", []Option{WithAllowedHosts("example.com")}, http.MethodGet, "file://example.com/etc/passwd"},
+		{"method", []Option{WithAllowedURLs(srv.URL), WithAllowedMethods(http.MethodGet)}, http.MethodDelete, srv.URL + "/v1/echo"},
+		{"redirect", []Option{WithAllowedURLs(srv.URL + "/v1")}, http.MethodGet, srv.URL + "/v1/redirect"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := New(test.opts...)
+			resp, err := m.Do(context.Background(), &pdk.HTTPRequest{Method: test.method, URL: test.url})
+			require.ErrorIs(t, err, ErrNotAllowed)
+			require.Nil(t, resp, "response should be nil when not allowed")
+		})
+	}
+}
+
+func TestModuleLimits(t *testing.T) {
+	srv := newServer(t)
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	m := New(WithAllowedHosts(host), WithMaxResponseSize(1024))
+	_, err := m.Do(context.Background(), &pdk.HTTPRequest{URL: srv.URL + "/v1/large"})
+	require.ErrorIs(t, err, ErrResponseTooLarge)
+
+	m = New(WithAllowedHosts(host), WithTimeout(10*time.Millisecond))
+	_, err = m.Do(context.Background(), &pdk.HTTPRequest{URL: srv.URL + "/v1/slow"})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestModuleFn(t *testing.T) {
+	srv := newServer(t)
+	name, fn := New(WithAllowedURLs(srv.URL + "/v1/")).Fn()
+	require.Equal(t, pdk.HTTPHostFn, name)
+
+	payload, err := (&pdk.HTTPRequest{Method: http.MethodGet, URL: srv.URL + "/v1/echo"}).MarshalMsg(nil)
+	require.NoError(t, err, "failed to marshal request")
+	data, err := fn(context.Background(), payload)
+	require.NoError(t, err, "failed to call host function")
+
+	resp := &pdk.HTTPResponse{}
+	_, err = resp.UnmarshalMsg(data)
+	require.NoError(t, err, "failed to unmarshal response")
+	require.Equal(t, "GET echo", string(resp.Body))
+}
+
+func TestModuleFnForgedHeaders(t *testing.T) {
+	_, fn := New().Fn()
+	for name, headers := range map[string][]byte{
+		"map":   {0xdf, 0xff, 0xff, 0xff, 0xff},
+		"array": msgp.AppendArrayHeader(msgp.AppendString(msgp.AppendMapHeader(nil, 1), "X-Custom"), 0xffffffff),
+	} {
+		t.Run(name, func(t *testing.T) {
+			payload := msgp.AppendString(msgp.AppendMapHeader(nil, 2), "url")
+			payload = msgp.AppendString(payload, "https://example.com")
+			payload = append(msgp.AppendString(payload, "headers"), headers...)
+			_, err := fn(context.Background(), payload)
+			require.ErrorIs(t, err, msgp.ErrShortBytes, "a forged header count must be rejected before allocating")
+		})
+	}
+}
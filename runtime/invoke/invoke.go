@@ -1,6 +1,11 @@
 package invoke
 
-import "context"
+import (
+	"context"
+	"io"
+
+	"github.com/tetratelabs/wazero/api"
+)
 
 type Context struct {
 	Operation string
@@ -9,8 +14,29 @@ type Context struct {
 	PluginResp []byte
 	PluginErr  string
 
+	// PluginErrCode is the error code of PluginErr, such as module.CodeVeto.
+	PluginErrCode uint32
+
 	HostResp []byte
 	HostErr  error
+
+	// StreamIn and StreamOut are set for streaming invocations, the plugin reads
+	// its input from StreamIn and writes its output to StreamOut in chunks.
+	StreamIn  io.Reader
+	StreamOut io.Writer
+
+	// Alloc is the allocator exported by the guest, if any, used to write host
+	// responses into guest memory.
+	Alloc api.Function
+
+	// HostCalls and HostBytes count the host calls of the invocation and the
+	// bytes of their payloads, for enforcing quotas.
+	HostCalls int
+	HostBytes uint64
+
+	// Err fails the invocation with an error of the host, such as a plugin
+	// response exceeding its size limit.
+	Err error
 }
 type invokeContextKey struct{}
 
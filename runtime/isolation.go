@@ -0,0 +1,41 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errNotInitialized is returned by calls of a runtime without a plugin instance.
+var errNotInitialized = errors.New("plugin not initialized")
+
+// instance prepares the plugin instance for a call. The plugin is restarted
+// when a previous restart failed and, when calls are isolated, an instance
+// used by an earlier call is replaced by a fresh one.
+// The caller must hold e.mu.
+func (e *Runtime) instance() error {
+	switch {
+	case e.plugin == nil:
+		return errNotInitialized
+	case e.plugin.IsClosed():
+		if err := e.restart(); err != nil {
+			return err
+		}
+	case e.isolated && e.used:
+		if err := e.reinstantiate(); err != nil {
+			return fmt.Errorf("failed to create plugin instance: %w", err)
+		}
+	}
+	e.used = true
+	return nil
+}
+
+// reinstantiate closes the current plugin instance and instantiates a fresh
+// one from the compiled module.
+// The caller must hold e.mu.
+func (e *Runtime) reinstantiate() error {
+	if !e.plugin.IsClosed() {
+		_ = e.plugin.Close(e.ctx)
+	}
+	e.used = false
+	return e.Instantiate()
+}
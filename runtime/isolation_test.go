@@ -0,0 +1,52 @@
+package runtime
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsolation(t *testing.T) {
+	ctx := context.Background()
+
+	inits := 0
+	plugin, err := New(ctx,
+		WithFile(TRAP_WASM),
+		WithIsolation(),
+		WithLogger(func(string) { inits++ }),
+	)
+	require.NoError(t, err, "failed to create module")
+	defer func() {
+		require.NoError(t, plugin.Close(ctx), "failed to close module")
+	}()
+
+	for range 3 {
+		out, err := plugin.Invoke(ctx, "count", nil)
+		require.NoError(t, err, "failed to invoke plugin")
+		require.Equal(t, []byte{1}, out, "every call should start from a fresh instance")
+	}
+	require.Equal(t, 3, inits, "every instance should be initialized")
+
+	_, err = plugin.Invoke(ctx, "unreachable", nil)
+	require.ErrorIs(t, err, ErrTrap)
+	out, err := plugin.Invoke(ctx, "count", nil)
+	require.NoError(t, err, "failed to invoke plugin")
+	require.Equal(t, []byte{1}, out)
+	require.Equal(t, uint64(1), plugin.Restarts(), "only traps should count as restarts")
+}
+
+func TestSharedInstance(t *testing.T) {
+	ctx := context.Background()
+	plugin, err := New(ctx, WithFile(TRAP_WASM), WithLogger(func(string) {}))
+	require.NoError(t, err, "failed to create module")
+	defer func() {
+		require.NoError(t, plugin.Close(ctx), "failed to close module")
+	}()
+
+	for i := range 3 {
+		out, err := plugin.Invoke(ctx, "count", nil)
+		require.NoError(t, err, "failed to invoke plugin")
+		require.Equal(t, []byte{byte(i + 1)}, out, "calls should share the instance")
+	}
+}
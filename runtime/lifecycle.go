@@ -0,0 +1,169 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mopeyjellyfish/hookr/runtime/invoke"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// functionShutdown is the nullary function called when the runtime is closed, letting the plugin flush any state.
+const fnHookrShutdown = "hookr_shutdown"
+
+// functionHealth reports the health of the plugin. Below is its signature in WebAssembly 1.0 (MVP) Text Format:
+//
+//	(func $hookr_health (result (;healthy;) i32))
+//
+// An unhealthy plugin may describe the problem by calling "__plugin_error".
+const fnHookrHealth = "hookr_health"
+
+// DefaultShutdownTimeout is how long the plugin's shutdown function may run when
+// no timeout is configured with WithShutdownTimeout. A shutdown function which
+// never returns to the host is only interrupted with WithInterruptOnContextDone.
+const DefaultShutdownTimeout = 5 * time.Second
+
+// State is the lifecycle state of a Runtime.
+type State int
+
+const (
+	// StateLoading is the state while the plugin is compiled and initialized.
+	StateLoading State = iota
+
+	// StateReady is the state once the plugin is initialized and healthy.
+	StateReady
+
+	// StateDegraded is the state after the plugin reported itself unhealthy.
+	StateDegraded
+
+	// StateClosed is the state after the runtime was closed.
+	StateClosed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateLoading:
+		return "loading"
+	case StateReady:
+		return "ready"
+	case StateDegraded:
+		return "degraded"
+	case StateClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("state(%d)", int(s))
+	}
+}
+
+// StateChangeFn is called when the lifecycle state of a runtime changes.
+type StateChangeFn func(from, to State)
+
+// State returns the current lifecycle state of the runtime.
+func (e *Runtime) State() State {
+	e.stateMu.Lock()
+	defer e.stateMu.Unlock()
+	return e.state
+}
+
+// setState moves the runtime to the given state, notifying the state change
+// callback when the state changed. A closed runtime stays closed.
+func (e *Runtime) setState(to State) {
+	e.stateMu.Lock()
+	from := e.state
+	if from == to || from == StateClosed {
+		e.stateMu.Unlock()
+		return
+	}
+	e.state = to
+	e.stateMu.Unlock()
+
+	if e.onStateChange != nil {
+		e.onStateChange(from, to)
+	}
+}
+
+// Health calls the plugin's health function and returns its error when the plugin
+// is unhealthy. Plugins which do not export a health function are healthy while
+// they are initialized. An unhealthy plugin moves the runtime to StateDegraded,
+// a healthy one back to StateReady.
+func (e *Runtime) Health(ctx context.Context) error {
+	ctx, done, err := e.inflight.enter(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	err = e.health(ctx)
+	if errors.Is(err, errNotInitialized) {
+		return err
+	}
+	if err != nil {
+		e.setState(StateDegraded)
+	} else {
+		e.setState(StateReady)
+	}
+	return err
+}
+
+// health calls the health function of the plugin instance, holding e.mu so
+// the instance is not replaced by a restart or reload during the call.
+func (e *Runtime) health(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.plugin == nil {
+		return errNotInitialized
+	}
+	fn := e.plugin.ExportedFunction(fnHookrHealth)
+	if fn == nil {
+		return nil
+	}
+
+	ic := invoke.Context{Operation: fnHookrHealth}
+	results, err := fn.Call(invoke.New(ctx, &ic))
+	if err != nil {
+		return fmt.Errorf("error calling %s: %w", fnHookrHealth, err)
+	}
+	if results[0] == 1 {
+		return nil
+	}
+	if ic.Err != nil {
+		return fmt.Errorf("plugin unhealthy: %w", ic.Err)
+	}
+	if ic.PluginErr != "" {
+		return fmt.Errorf("plugin unhealthy: %s", ic.PluginErr)
+	}
+	return errors.New("plugin unhealthy")
+}
+
+// shutdown calls the plugin's shutdown function, it is given until the
+// shutdown timeout or the deadline of ctx, whichever is first.
+func (e *Runtime) shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.shutdownPlugin(ctx, e.plugin)
+}
+
+// shutdownPlugin calls the shutdown function of the plugin instance, see
+// shutdown.
+// The caller must hold e.mu.
+func (e *Runtime) shutdownPlugin(ctx context.Context, plugin api.Module) error {
+	if plugin == nil || plugin.IsClosed() { // not initialized, or interrupted by an aborted call
+		return nil
+	}
+	fn := plugin.ExportedFunction(fnHookrShutdown)
+	if fn == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.shutdownTimeout)
+	defer cancel()
+
+	ic := invoke.Context{Operation: fnHookrShutdown}
+	if _, err := fn.Call(invoke.New(ctx, &ic)); err != nil {
+		return fmt.Errorf("error calling %s: %w", fnHookrShutdown, err)
+	}
+	return nil
+}
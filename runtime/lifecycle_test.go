@@ -0,0 +1,118 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	LIFECYCLE_WASM = "../testdata/lifecycle/bin/lifecycle.wasm"
+	INITFAIL_WASM  = "../testdata/initfail/bin/initfail.wasm"
+)
+
+func TestLifecycle(t *testing.T) {
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var transitions []string
+	var logs []string
+	plugin, err := New(ctx,
+		WithFile(LIFECYCLE_WASM),
+		WithLogger(func(msg string) { logs = append(logs, msg) }),
+		WithStateChange(func(from, to State) {
+			mu.Lock()
+			defer mu.Unlock()
+			transitions = append(transitions, from.String()+"->"+to.String())
+		}),
+	)
+	require.NoError(t, err, "failed to create module")
+	require.Equal(t, StateReady, plugin.State())
+	require.NoError(t, plugin.Health(ctx), "plugin should be healthy")
+
+	_, err = plugin.Invoke(ctx, "unhealthy", nil)
+	require.NoError(t, err, "failed to invoke plugin")
+	err = plugin.Health(ctx)
+	require.ErrorContains(t, err, "database unavailable")
+	require.Equal(t, StateDegraded, plugin.State())
+
+	_, err = plugin.Invoke(ctx, "healthy", nil)
+	require.NoError(t, err, "failed to invoke plugin")
+	require.NoError(t, plugin.Health(ctx), "plugin should have recovered")
+	require.Equal(t, StateReady, plugin.State())
+
+	require.NoError(t, plugin.Close(ctx), "failed to close module")
+	require.Equal(t, StateClosed, plugin.State())
+	require.Equal(t, []string{"shutdown"}, logs, "shutdown should be called on close")
+	require.Error(t, plugin.Health(ctx), "expected error when checking health of a closed plugin")
+
+	require.Equal(t, []string{
+		"loading->ready",
+		"ready->degraded",
+		"degraded->ready",
+		"ready->closed",
+	}, transitions)
+}
+
+func TestLifecycleShutdownTimeout(t *testing.T) {
+	ctx := context.Background()
+	plugin, err := New(ctx, WithFile(LIFECYCLE_WASM), WithShutdownTimeout(50*time.Millisecond))
+	require.NoError(t, err, "failed to create module")
+
+	_, err = plugin.Invoke(ctx, "block", nil)
+	require.NoError(t, err, "failed to invoke plugin")
+
+	start := time.Now()
+	err = plugin.Close(ctx)
+	require.Error(t, err, "expected error when shutdown exceeds its deadline")
+	require.Less(t, time.Since(start), 5*time.Second, "shutdown should be interrupted")
+	require.Equal(t, StateClosed, plugin.State())
+}
+
+func TestLifecycleWithoutExports(t *testing.T) {
+	ctx := context.Background()
+	plugin, err := New(ctx, WithFile(SIMPLE_WASM))
+	require.NoError(t, err, "failed to create module")
+	require.NoError(t, plugin.Health(ctx), "plugins without a health function are healthy")
+	require.NoError(t, plugin.Close(ctx), "failed to close module")
+
+	require.Error(t, (&Runtime{}).Health(ctx), "expected error for an uninitialized plugin")
+	require.Equal(t, "state(42)", State(42).String())
+}
+
+func TestInitFailure(t *testing.T) {
+	ctx := context.Background()
+
+	// the first random byte decides how the plugin initializes.
+	_, err := New(ctx, WithFile(INITFAIL_WASM), WithRandSource(bytes.NewReader([]byte{0})))
+	var initErr *InitError
+	require.ErrorAs(t, err, &initErr, "a trap during init should fail loading")
+	require.Equal(t, fnHookrInit, initErr.Stage)
+
+	_, err = New(ctx, WithFile(INITFAIL_WASM), WithRandSource(bytes.NewReader([]byte{1})))
+	require.ErrorAs(t, err, &initErr, "an init error reported by the plugin should fail loading")
+	require.Equal(t, fnHookrInit, initErr.Stage)
+	require.EqualError(t, initErr.Err, "config missing")
+
+	plugin, err := New(ctx, WithFile(INITFAIL_WASM), WithRandSource(bytes.NewReader([]byte{2})))
+	require.NoError(t, err, "failed to create module")
+	require.NoError(t, plugin.Close(ctx), "failed to close module")
+}
+
+func TestInitTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := New(ctx, WithFile(SIMPLE_WASM), WithInitTimeout(time.Nanosecond))
+	var initErr *InitError
+	require.ErrorAs(t, err, &initErr, "expected init to time out")
+	require.Equal(t, fnInitialize, initErr.Stage)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	plugin, err := New(ctx, WithFile(SIMPLE_WASM), WithInitTimeout(time.Minute))
+	require.NoError(t, err, "failed to create module")
+	require.NoError(t, plugin.Close(ctx), "failed to close module")
+}
@@ -0,0 +1,20 @@
+package runtime
+
+import "github.com/mopeyjellyfish/hookr/runtime/module"
+
+// Limits are the maximum sizes in bytes of the data passed between the host and the plugin, see WithLimits.
+type Limits = module.Limits
+
+// SizeError is the error of data exceeding one of the Limits, it names the exceeded limit.
+type SizeError = module.SizeError
+
+// ErrTooLarge is matched by every SizeError.
+var ErrTooLarge = module.ErrTooLarge
+
+// Names of the Limits, reported by SizeError.
+const (
+	LimitPluginRequest  = module.LimitPluginRequest
+	LimitPluginResponse = module.LimitPluginResponse
+	LimitHostPayload    = module.LimitHostPayload
+	LimitHostResponse   = module.LimitHostResponse
+)
@@ -0,0 +1,52 @@
+package runtime
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimits(t *testing.T) {
+	for name, file := range map[string]string{"Alloc": ABI_WASM, "Legacy": LEGACY_WASM} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			rt, err := New(ctx, WithFile(file),
+				WithHostFns(HostFnByte("hello", reply)),
+				WithLimits(Limits{PluginRequest: 8, PluginResponse: 6, HostPayload: 4, HostResponse: 7}),
+			)
+			require.NoError(t, err)
+			defer rt.Close(ctx)
+
+			_, err = rt.Invoke(ctx, "noop", []byte("123456789"))
+			var sizeErr *SizeError
+			require.ErrorAs(t, err, &sizeErr)
+			require.Equal(t, LimitPluginRequest, sizeErr.Limit)
+			require.EqualError(t, err, "plugin request of 9 bytes exceeds the limit of 8 bytes")
+			require.ErrorIs(t, err, ErrTooLarge)
+
+			_, err = rt.Invoke(ctx, "noop", []byte("1234567"))
+			require.ErrorAs(t, err, &sizeErr)
+			require.Equal(t, LimitPluginResponse, sizeErr.Limit)
+
+			_, err = rt.Invoke(ctx, "echo", []byte("12345"))
+			require.EqualError(t, err, "plugin response of 52 bytes exceeds the limit of 6 bytes", "the plugin's error message is limited")
+
+			out, err := rt.Invoke(ctx, "echo", []byte("12"))
+			require.NoError(t, err, "data within the limits passes")
+			require.Equal(t, []byte("re: 12"), out)
+
+			rt, err = New(ctx, WithFile(file),
+				WithHostFns(HostFnByte("hello", reply)),
+				WithLimits(Limits{HostPayload: 4, HostResponse: 7}),
+			)
+			require.NoError(t, err)
+			defer rt.Close(ctx)
+
+			_, err = rt.Invoke(ctx, "echo", []byte("12345"))
+			require.EqualError(t, err, "host payload of 5 bytes exceeds the limit of 4 bytes", "the plugin receives the error")
+			_, err = rt.Invoke(ctx, "echo", []byte("1234"))
+			require.EqualError(t, err, "host response of 8 bytes exceeds the limit of 7 bytes")
+		})
+	}
+}
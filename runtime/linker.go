@@ -0,0 +1,234 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// PluginCallFn is the host function plugins call to call another plugin, see
+// pdk.PluginCall. The payload is a msgpack array of the plugin's name, the
+// operation and the payload of the call.
+const PluginCallFn = "hookr_plugin_call"
+
+// DefaultHopTimeout is how long a call from one plugin to another may take
+// when neither the link nor the Linker configure a timeout.
+const DefaultHopTimeout = 10 * time.Second
+
+var (
+	// ErrUnknownPlugin is returned for calls to plugins not registered with the Linker.
+	ErrUnknownPlugin = errors.New("unknown plugin")
+
+	// ErrNotLinked is returned for calls between plugins without a link.
+	ErrNotLinked = errors.New("plugins not linked")
+
+	// ErrOperationDenied is returned for calls of operations a link does not allow.
+	ErrOperationDenied = errors.New("operation not allowed")
+
+	// ErrLinkCycle is returned for links which would make plugins depend on
+	// themselves, and for calls re-entering a plugin already in the call chain.
+	ErrLinkCycle = errors.New("plugin dependency cycle")
+)
+
+// Link allows the Caller plugin to call the Callee plugin.
+type Link struct {
+	Caller string
+	Callee string
+	// Operations the caller may call, any operation when empty.
+	Operations []string
+	// Timeout bounds each call over the link, the Linker's hop timeout is used when 0.
+	Timeout time.Duration
+}
+
+// Linker routes calls from one plugin to another, see WithLinker. Plugins may
+// only call the plugins they are linked to, the links form a dependency graph
+// which must not contain cycles. It is safe for concurrent use.
+type Linker struct {
+	mu         sync.RWMutex
+	plugins    map[string]*Runtime
+	links      map[string]map[string]Link // caller -> callee -> link
+	hopTimeout time.Duration
+}
+
+// LinkerOption configures a Linker.
+type LinkerOption func(*Linker)
+
+// WithHopTimeout sets how long each call from one plugin to another may take
+// when its link sets no timeout, DefaultHopTimeout by default.
+func WithHopTimeout(timeout time.Duration) LinkerOption {
+	return func(l *Linker) {
+		l.hopTimeout = timeout
+	}
+}
+
+// NewLinker returns a Linker without plugins.
+func NewLinker(opts ...LinkerOption) *Linker {
+	l := &Linker{
+		plugins:    map[string]*Runtime{},
+		links:      map[string]map[string]Link{},
+		hopTimeout: DefaultHopTimeout,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Link adds the link, replacing an existing link between the same plugins.
+// ErrLinkCycle is returned when the callee already depends on the caller.
+func (l *Linker) Link(link Link) error {
+	if link.Caller == "" || link.Callee == "" {
+		return errors.New("link needs a caller and a callee")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if path := l.path(link.Callee, link.Caller); path != nil {
+		return fmt.Errorf("%w: %s -> %s", ErrLinkCycle, link.Caller, strings.Join(path, " -> "))
+	}
+	if l.links[link.Caller] == nil {
+		l.links[link.Caller] = map[string]Link{}
+	}
+	l.links[link.Caller][link.Callee] = link
+	return nil
+}
+
+// Unlink removes the link between the plugins.
+func (l *Linker) Unlink(caller, callee string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.links[caller], callee)
+}
+
+// Dependencies returns the plugins the plugin is linked to, sorted by name.
+func (l *Linker) Dependencies(name string) []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	deps := make([]string, 0, len(l.links[name]))
+	for callee := range l.links[name] {
+		deps = append(deps, callee)
+	}
+	sort.Strings(deps)
+	return deps
+}
+
+// Plugin returns the plugin registered with the name.
+func (l *Linker) Plugin(name string) (*Runtime, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	rt, ok := l.plugins[name]
+	return rt, ok
+}
+
+// path returns the plugins from one plugin to another following the links,
+// nil when there is none. The caller must hold l.mu.
+func (l *Linker) path(from, to string) []string {
+	if from == to {
+		return []string{from}
+	}
+	for callee := range l.links[from] {
+		if rest := l.path(callee, to); rest != nil {
+			return append([]string{from}, rest...)
+		}
+	}
+	return nil
+}
+
+// Linker returns the Linker the plugin is registered with and the name it is
+// registered under, see WithLinker. The Linker is nil when the plugin is not
+// linked.
+func (e *Runtime) Linker() (*Linker, string) {
+	return e.linker, e.linkName
+}
+
+func (l *Linker) register(name string, rt *Runtime) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.plugins[name]; ok {
+		return fmt.Errorf("plugin %q is already registered", name)
+	}
+	l.plugins[name] = rt
+	return nil
+}
+
+func (l *Linker) unregister(name string, rt *Runtime) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.plugins[name] == rt {
+		delete(l.plugins, name)
+	}
+}
+
+type callChainKey struct{}
+
+// Call calls the operation of the callee on behalf of the caller, checking
+// the link between them. The call is bounded by the link's timeout.
+func (l *Linker) Call(ctx context.Context, caller, callee, operation string, payload []byte) ([]byte, error) {
+	chain, _ := ctx.Value(callChainKey{}).([]string)
+	if len(chain) == 0 || chain[len(chain)-1] != caller {
+		chain = append(slices.Clip(chain), caller)
+	}
+	if slices.Contains(chain, callee) {
+		return nil, fmt.Errorf("%w: %s -> %s", ErrLinkCycle, strings.Join(chain, " -> "), callee)
+	}
+
+	l.mu.RLock()
+	link, linked := l.links[caller][callee]
+	rt, registered := l.plugins[callee]
+	timeout := l.hopTimeout
+	l.mu.RUnlock()
+
+	switch {
+	case !registered:
+		return nil, fmt.Errorf("plugin %q cannot call %q: %w", caller, callee, ErrUnknownPlugin)
+	case !linked:
+		return nil, fmt.Errorf("plugin %q cannot call %q: %w", caller, callee, ErrNotLinked)
+	case len(link.Operations) > 0 && !slices.Contains(link.Operations, operation):
+		return nil, fmt.Errorf("plugin %q cannot call %q of %q: %w", caller, operation, callee, ErrOperationDenied)
+	}
+	if link.Timeout > 0 {
+		timeout = link.Timeout
+	}
+
+	ctx = context.WithValue(ctx, callChainKey{}, append(slices.Clip(chain), callee))
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return rt.Invoke(ctx, operation, payload)
+}
+
+// route handles a PluginCallFn host call of the caller.
+func (l *Linker) route(ctx context.Context, caller string, payload []byte) ([]byte, error) {
+	callee, operation, payload, err := decodePluginCall(payload)
+	if err != nil {
+		return nil, err
+	}
+	return l.Call(ctx, caller, callee, operation, payload)
+}
+
+// decodePluginCall decodes the payload of a PluginCallFn host call.
+func decodePluginCall(b []byte) (plugin, operation string, payload []byte, err error) {
+	size, b, err := msgp.ReadArrayHeaderBytes(b)
+	if err == nil && size != 3 {
+		err = fmt.Errorf("expected 3 elements, got %d", size)
+	}
+	if err == nil {
+		plugin, b, err = msgp.ReadStringBytes(b)
+	}
+	if err == nil {
+		operation, b, err = msgp.ReadStringBytes(b)
+	}
+	if err == nil {
+		payload, _, err = msgp.ReadBytesBytes(b, nil)
+	}
+	if err != nil {
+		return "", "", nil, fmt.Errorf("invalid plugin call: %w", err)
+	}
+	return plugin, operation, payload, nil
+}
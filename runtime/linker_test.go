@@ -0,0 +1,114 @@
+package runtime
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tinylib/msgp/msgp"
+)
+
+const LINK_WASM = "../testdata/link/bin/link.wasm"
+
+// route encodes a call of the link plugin to another plugin.
+func route(plugin, operation string, payload []byte) []byte {
+	b := msgp.AppendArrayHeader(nil, 3)
+	b = msgp.AppendString(b, plugin)
+	b = msgp.AppendString(b, operation)
+	return msgp.AppendBytes(b, payload)
+}
+
+func newLinked(t *testing.T, l *Linker, name, file string) *Runtime {
+	t.Helper()
+	rt, err := New(context.Background(), WithFile(file), WithLinker(l, name))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = rt.Close(context.Background())
+	})
+	return rt
+}
+
+func TestLinker(t *testing.T) {
+	ctx := context.Background()
+	l := NewLinker()
+	a := newLinked(t, l, "a", LINK_WASM)
+	newLinked(t, l, "b", LINK_WASM)
+	newLinked(t, l, "c", LINK_WASM)
+	require.NoError(t, l.Link(Link{Caller: "a", Callee: "b"}))
+	require.NoError(t, l.Link(Link{Caller: "b", Callee: "c", Operations: []string{"noop"}}))
+	require.Equal(t, []string{"b"}, l.Dependencies("a"))
+
+	out, err := a.Invoke(ctx, "call", route("b", "call", route("c", "noop", []byte("x"))))
+	require.NoError(t, err)
+	require.Equal(t, []byte("x"), out)
+
+	_, err = a.Invoke(ctx, "call", route("c", "noop", nil))
+	require.ErrorContains(t, err, ErrNotLinked.Error())
+	_, err = a.Invoke(ctx, "call", route("b", "call", route("c", "call", nil)))
+	require.ErrorContains(t, err, ErrOperationDenied.Error())
+	_, err = a.Invoke(ctx, "call", route("missing", "noop", nil))
+	require.ErrorContains(t, err, ErrUnknownPlugin.Error())
+	_, err = a.Invoke(ctx, "call", []byte("not msgpack"))
+	require.ErrorContains(t, err, "invalid plugin call")
+
+	_, err = l.Call(ctx, "a", "c", "noop", nil)
+	require.ErrorIs(t, err, ErrNotLinked)
+	_, err = l.Call(ctx, "b", "c", "call", nil)
+	require.ErrorIs(t, err, ErrOperationDenied)
+}
+
+func TestLinkerCycle(t *testing.T) {
+	l := NewLinker()
+	require.NoError(t, l.Link(Link{Caller: "a", Callee: "b"}))
+	require.NoError(t, l.Link(Link{Caller: "b", Callee: "c"}))
+
+	err := l.Link(Link{Caller: "c", Callee: "a"})
+	require.ErrorIs(t, err, ErrLinkCycle)
+	require.ErrorContains(t, err, "c -> a -> b -> c")
+	require.ErrorIs(t, l.Link(Link{Caller: "a", Callee: "a"}), ErrLinkCycle)
+
+	l.Unlink("b", "c")
+	require.NoError(t, l.Link(Link{Caller: "c", Callee: "a"}))
+
+	// calls re-entering a plugin of the call chain are refused
+	newLinked(t, l, "a", LINK_WASM)
+	ctx := context.WithValue(context.Background(), callChainKey{}, []string{"a", "b"})
+	_, err = l.Call(ctx, "b", "a", "noop", nil)
+	require.ErrorIs(t, err, ErrLinkCycle)
+}
+
+func TestLinkerHopTimeout(t *testing.T) {
+	l := NewLinker(WithHopTimeout(time.Minute))
+	a := newLinked(t, l, "a", LINK_WASM)
+	newLinked(t, l, "slow", TRAP_WASM)
+	require.NoError(t, l.Link(Link{Caller: "a", Callee: "slow", Timeout: 50 * time.Millisecond}))
+
+	start := time.Now()
+	_, err := a.Invoke(context.Background(), "call", route("slow", "loop", nil))
+	require.ErrorContains(t, err, "deadline exceeded")
+	require.Less(t, time.Since(start), 10*time.Second)
+
+	out, err := a.Invoke(context.Background(), "noop", []byte("ok"))
+	require.NoError(t, err, "the caller keeps working")
+	require.Equal(t, []byte("ok"), out)
+}
+
+func TestLinkerRegistration(t *testing.T) {
+	ctx := context.Background()
+	l := NewLinker()
+	a, err := New(ctx, WithFile(LINK_WASM), WithLinker(l, "a"))
+	require.NoError(t, err)
+
+	_, err = New(ctx, WithFile(LINK_WASM), WithLinker(l, "a"))
+	require.ErrorContains(t, err, `plugin "a" is already registered`)
+	_, err = New(ctx, WithFile(LINK_WASM), WithLinker(l, ""))
+	require.Error(t, err)
+
+	rt, ok := l.Plugin("a")
+	require.True(t, ok)
+	require.Same(t, a, rt)
+	require.NoError(t, a.Close(ctx))
+	_, ok = l.Plugin("a")
+	require.False(t, ok)
+}
@@ -2,6 +2,10 @@ package module
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 
 	"github.com/mopeyjellyfish/hookr/runtime/invoke"
 	"github.com/mopeyjellyfish/hookr/runtime/logger"
@@ -10,11 +14,167 @@ import (
 	"github.com/tetratelabs/wazero/api"
 )
 
-const i32 = api.ValueTypeI32
+const (
+	i32 = api.ValueTypeI32
+	i64 = api.ValueTypeI64
+)
+
+const (
+	// FnAlloc is the function a guest exports to allocate a buffer the host writes into:
+	//
+	//	(func $hookr_alloc (param $size i32) (result (;ptr;) i32))
+	FnAlloc = "hookr_alloc"
+
+	// FnFree is the function a guest exports to release a buffer allocated with FnAlloc:
+	//
+	//	(func $hookr_free (param $ptr i32) (param $size i32))
+	FnFree = "hookr_free"
+
+	// Failed is returned by functions returning a packed pointer and length when
+	// the call failed, it cannot be a valid pointer and length.
+	Failed = ^uint64(0)
+)
+
+// Error codes qualify the errors passed between the host and the guest, so their messages never have to be parsed.
+// A guest reports the code of its error with "__plugin_error_code" before calling "__plugin_error", and reads the code
+// of a host error with "__host_error_code".
+const (
+	// CodeNone is the code of errors without a more specific one.
+	CodeNone uint32 = iota
+
+	// CodeVeto is the code of a plugin vetoing its invocation, the error message is the reason.
+	CodeVeto
+
+	// CodeQuotaRate is the code of a host call refused by a rate limit.
+	CodeQuotaRate
+
+	// CodeQuotaHostCalls is the code of a host call refused because the invocation made too many host calls.
+	CodeQuotaHostCalls
+
+	// CodeQuotaHostBytes is the code of a host call refused because the payloads of the invocation's host calls
+	// were too large.
+	CodeQuotaHostBytes
+
+	// CodeNotGranted is the code of a host call of a function the plugin was not granted.
+	CodeNotGranted
+
+	// CodeConfigNotSet is the code of a configuration key without a value.
+	CodeConfigNotSet
+)
+
+// CodedError is an error of the host carrying its code to the guest.
+type CodedError interface {
+	error
+	ErrorCode() uint32
+}
+
+// ErrorCode returns the code of err, CodeNone when it does not wrap a CodedError.
+func ErrorCode(err error) uint32 {
+	var coded CodedError
+	if errors.As(err, &coded) {
+		return coded.ErrorCode()
+	}
+	return CodeNone
+}
+
+// Pack packs a pointer and a length into a single value, the pointer in the high 32 bits.
+func Pack(ptr, size uint32) uint64 {
+	return uint64(ptr)<<32 | uint64(size)
+}
+
+// Unpack unpacks a pointer and a length packed with Pack.
+func Unpack(v uint64) (ptr, size uint32) {
+	return api.DecodeU32(v >> 32), api.DecodeU32(v)
+}
 
 // CallHandler is a function to invoke to handle when a guest is performing a host call.
 type CallHandler func(ctx context.Context, operation string, payload []byte) ([]byte, error)
 
+// Names of the Limits, reported by SizeError.
+const (
+	LimitPluginRequest  = "plugin request"
+	LimitPluginResponse = "plugin response"
+	LimitHostPayload    = "host payload"
+	LimitHostResponse   = "host response"
+)
+
+// ErrTooLarge is matched by the SizeError of data exceeding one of the Limits.
+var ErrTooLarge = errors.New("too large")
+
+// Limits are the maximum sizes in bytes of the data passed between the host and the guest, zero values are
+// unlimited. Sizes are checked before the data is copied. The chunks of streams are not limited, they are read and
+// written in place and never held by the host.
+type Limits struct {
+	// PluginRequest limits the payloads of plugin calls.
+	PluginRequest uint32
+
+	// PluginResponse limits the responses and error messages of plugin calls.
+	PluginResponse uint32
+
+	// HostPayload limits the payloads of host calls.
+	HostPayload uint32
+
+	// HostResponse limits the responses of host calls.
+	HostResponse uint32
+}
+
+// Check returns a *SizeError when size exceeds the named limit.
+func (l Limits) Check(limit string, size uint64) error {
+	var maxSize uint32
+	switch limit {
+	case LimitPluginRequest:
+		maxSize = l.PluginRequest
+	case LimitPluginResponse:
+		maxSize = l.PluginResponse
+	case LimitHostPayload:
+		maxSize = l.HostPayload
+	case LimitHostResponse:
+		maxSize = l.HostResponse
+	}
+	if maxSize > 0 && size > uint64(maxSize) {
+		return &SizeError{Limit: limit, Size: size, Max: maxSize}
+	}
+	return nil
+}
+
+// SizeError is the error of data exceeding one of the Limits.
+type SizeError struct {
+	// Limit is the exceeded limit, such as LimitPluginResponse.
+	Limit string
+	Size  uint64
+	Max   uint32
+}
+
+func (e *SizeError) Error() string {
+	return fmt.Sprintf("%s of %d bytes exceeds the limit of %d bytes", e.Limit, e.Size, e.Max)
+}
+
+func (e *SizeError) Is(target error) bool {
+	return target == ErrTooLarge
+}
+
+// Limiter is called before each host call with its operation and the length of its payload, the call is refused
+// with the returned error, which is passed to the guest.
+type Limiter func(ctx context.Context, operation string, payloadLen uint32) error
+
+// Option configures the hookr host module.
+type Option func(*hookrModule)
+
+// WithLimiter refuses the host calls the limiter returns an error for.
+func WithLimiter(limiter Limiter) Option {
+	return func(w *hookrModule) {
+		w.limiter = limiter
+	}
+}
+
+// WithLimits limits the sizes of the plugin responses, host call payloads and host responses passed through the
+// module.
+func WithLimits(limits Limits) Option {
+	return func(w *hookrModule) {
+		w.limits = limits
+	}
+}
+
 // hookrModule implements all required hookr host function exports.
 type hookrModule struct {
 	// callHandler implements hostCall, which returns false (0) when nil.
@@ -22,25 +182,40 @@ type hookrModule struct {
 
 	// logger is used to implement consoleLog.
 	logger logger.Logger
+
+	// limiter, when set, is checked by hostCall and hostInvoke before reading the payload.
+	limiter Limiter
+
+	// limits are checked before data is copied in or out of the guest.
+	limits Limits
 }
 
 // instantiateHookrHost instantiates a hookrModule and returns it and its corresponding module, or an error.
 //   - r: used to instantiate the hookr host module
 //   - callHandler: used to implement hostCall
 //   - logger: used to implement consoleLog
+//   - opts: configure the module, such as its Limiter
 func instantiateHookrModule(
 	ctx context.Context,
 	r wazero.Runtime,
 	callHandler CallHandler,
 	logger logger.Logger,
+	opts ...Option,
 ) (api.Module, error) {
 	h := &hookrModule{callHandler: callHandler, logger: logger}
+	for _, opt := range opts {
+		opt(h)
+	}
 	return r.NewHostModuleBuilder("hookr").
 		NewFunctionBuilder().
 		WithGoModuleFunction(api.GoModuleFunc(h.hostCall), []api.ValueType{i32, i32, i32, i32}, []api.ValueType{i32}).
 		WithParameterNames("cmd_ptr", "cmd_len", "payload_ptr", "payload_len").
 		Export("__host_call").
 		NewFunctionBuilder().
+		WithGoModuleFunction(api.GoModuleFunc(h.hostInvoke), []api.ValueType{i32, i32, i32, i32, i32, i32}, []api.ValueType{i64}).
+		WithParameterNames("cmd_ptr", "cmd_len", "payload_ptr", "payload_len", "buf_ptr", "buf_len").
+		Export("__host_invoke").
+		NewFunctionBuilder().
 		WithGoModuleFunction(api.GoModuleFunc(h.log), []api.ValueType{i32, i32}, []api.ValueType{}).
 		WithParameterNames("ptr", "len").
 		Export("__log").
@@ -64,12 +239,27 @@ func instantiateHookrModule(
 		WithParameterNames("ptr", "len").
 		Export("__plugin_error").
 		NewFunctionBuilder().
+		WithGoFunction(api.GoFunc(h.pluginErrorCode), []api.ValueType{i32}, []api.ValueType{}).
+		WithParameterNames("code").
+		Export("__plugin_error_code").
+		NewFunctionBuilder().
 		WithGoModuleFunction(api.GoModuleFunc(h.hostError), []api.ValueType{i32}, []api.ValueType{}).
 		WithParameterNames("ptr").
 		Export("__host_error").
 		NewFunctionBuilder().
 		WithGoFunction(api.GoFunc(h.hostErrorLen), []api.ValueType{}, []api.ValueType{i32}).
 		Export("__host_error_len").
+		NewFunctionBuilder().
+		WithGoFunction(api.GoFunc(h.hostErrorCode), []api.ValueType{}, []api.ValueType{i32}).
+		Export("__host_error_code").
+		NewFunctionBuilder().
+		WithGoModuleFunction(api.GoModuleFunc(h.streamRead), []api.ValueType{i32, i32}, []api.ValueType{i32}).
+		WithParameterNames("ptr", "len").
+		Export("__stream_read").
+		NewFunctionBuilder().
+		WithGoModuleFunction(api.GoModuleFunc(h.streamWrite), []api.ValueType{i32, i32}, []api.ValueType{i32}).
+		WithParameterNames("ptr", "len").
+		Export("__stream_write").
 		Instantiate(ctx)
 }
 
@@ -88,15 +278,112 @@ func (w *hookrModule) hostCall(ctx context.Context, m api.Module, stack []uint64
 
 	mem := m.Memory()
 	operation := memory.ReadString(mem, "operation", cmdPtr, cmdLen)
+	if !w.allow(ctx, ic, operation, payloadLen) {
+		stack[0] = 0 // false: refused by the limiter
+		return
+	}
 	payload := memory.Read(mem, "payload", payloadPtr, payloadLen)
 
-	if ic.HostResp, ic.HostErr = w.callHandler(ctx, operation, payload); ic.HostErr != nil {
+	if !w.handle(ctx, ic, operation, payload) {
 		stack[0] = 0 // false: error (assumed to be logged already?)
 	} else {
 		stack[0] = 1 // true
 	}
 }
 
+// hostInvoke is the WebAssembly function export "__host_invoke", which calls the callHandler like "__host_call" and
+// writes the response within the same call: into the guest's buffer at the given offset (buf_ptr) and length
+// (buf_len) when it fits, otherwise into a buffer allocated with the guest's FnAlloc, which the guest owns. It returns
+// the response's pointer and length packed with Pack, or Failed, in which case the error is available through
+// "__host_error".
+func (w *hookrModule) hostInvoke(ctx context.Context, m api.Module, stack []uint64) {
+	cmdPtr := api.DecodeU32(stack[0])
+	cmdLen := api.DecodeU32(stack[1])
+	payloadPtr := api.DecodeU32(stack[2])
+	payloadLen := api.DecodeU32(stack[3])
+	bufPtr := api.DecodeU32(stack[4])
+	bufLen := api.DecodeU32(stack[5])
+	ic := invoke.From(ctx)
+	if ic == nil || w.callHandler == nil {
+		stack[0] = Failed // neither an invocation context, nor a callHandler
+		return
+	}
+
+	mem := m.Memory()
+	operation := memory.ReadString(mem, "operation", cmdPtr, cmdLen)
+	if !w.allow(ctx, ic, operation, payloadLen) {
+		stack[0] = Failed
+		return
+	}
+	payload := memory.Read(mem, "payload", payloadPtr, payloadLen)
+
+	if !w.handle(ctx, ic, operation, payload) {
+		stack[0] = Failed
+		return
+	}
+	respLen, err := memory.Uint32FromInt(len(ic.HostResp))
+	if err != nil {
+		panic(err)
+	}
+	if respLen <= bufLen {
+		memory.Write(mem, "hostResp", bufPtr, ic.HostResp)
+		stack[0] = Pack(bufPtr, respLen)
+		return
+	}
+
+	alloc := ic.Alloc
+	if alloc == nil {
+		alloc = m.ExportedFunction(FnAlloc)
+	}
+	ptr, err := Alloc(ctx, alloc, respLen)
+	if err != nil {
+		panic(fmt.Errorf("failed to allocate host response: %w", err))
+	}
+	memory.Write(mem, "hostResp", ptr, ic.HostResp)
+	stack[0] = Pack(ptr, respLen)
+}
+
+// allow checks the host call's payload size and the limiter, a refused call's error is set as the host error.
+func (w *hookrModule) allow(ctx context.Context, ic *invoke.Context, operation string, payloadLen uint32) bool {
+	err := w.limits.Check(LimitHostPayload, uint64(payloadLen))
+	if err == nil && w.limiter != nil {
+		err = w.limiter(ctx, operation, payloadLen)
+	}
+	if err != nil {
+		ic.HostResp, ic.HostErr = nil, err
+		return false
+	}
+	return true
+}
+
+// handle calls the callHandler, setting its response or error, which is also set when the response exceeds its limit.
+func (w *hookrModule) handle(ctx context.Context, ic *invoke.Context, operation string, payload []byte) bool {
+	if ic.HostResp, ic.HostErr = w.callHandler(ctx, operation, payload); ic.HostErr != nil {
+		return false
+	}
+	if err := w.limits.Check(LimitHostResponse, uint64(len(ic.HostResp))); err != nil {
+		ic.HostResp, ic.HostErr = nil, err
+		return false
+	}
+	return true
+}
+
+// Alloc allocates size bytes with alloc, the guest's exported FnAlloc.
+func Alloc(ctx context.Context, alloc api.Function, size uint32) (uint32, error) {
+	if alloc == nil {
+		return 0, fmt.Errorf("module didn't export function %s", FnAlloc)
+	}
+	results, err := alloc.Call(ctx, uint64(size))
+	if err != nil {
+		return 0, err
+	}
+	ptr := api.DecodeU32(results[0])
+	if ptr == 0 {
+		return 0, fmt.Errorf("%s failed to allocate %d bytes", FnAlloc, size)
+	}
+	return ptr, nil
+}
+
 // consoleLog is the WebAssembly function export "__console_log", which logs the message stored by the guest at the
 // given offset (ptr) and length (len) in linear memory (wasm.Memory).
 func (w *hookrModule) log(_ context.Context, m api.Module, params []uint64) {
@@ -165,6 +452,8 @@ func (w *hookrModule) pluginResponse(ctx context.Context, m api.Module, params [
 
 	if ic := invoke.From(ctx); ic == nil {
 		return // no invoke context
+	} else if err := w.limits.Check(LimitPluginResponse, uint64(dataLen)); err != nil {
+		ic.Err = err
 	} else {
 		ic.PluginResp = memory.Read(m.Memory(), "guestResp", ptr, dataLen)
 	}
@@ -178,11 +467,21 @@ func (w *hookrModule) pluginError(ctx context.Context, m api.Module, params []ui
 
 	if ic := invoke.From(ctx); ic == nil {
 		return // no invoke context
+	} else if err := w.limits.Check(LimitPluginResponse, uint64(errLen)); err != nil {
+		ic.Err = err
 	} else {
 		ic.PluginErr = memory.ReadString(m.Memory(), "guestErr", ptr, errLen)
 	}
 }
 
+// pluginErrorCode is the WebAssembly function export "__plugin_error_code", which sets invokeContext.pluginErrCode,
+// the error code of the error the guest reports with "__plugin_error".
+func (w *hookrModule) pluginErrorCode(ctx context.Context, params []uint64) {
+	if ic := invoke.From(ctx); ic != nil {
+		ic.PluginErrCode = api.DecodeU32(params[0])
+	}
+}
+
 // hostError is the WebAssembly function export "__host_error", which writes the invokeContext.hostErr to the given
 // offset (ptr) in linear memory (wasm.Memory).
 func (w *hookrModule) hostError(ctx context.Context, m api.Module, params []uint64) {
@@ -211,11 +510,74 @@ func (w *hookrModule) hostErrorLen(ctx context.Context, results []uint64) {
 	}
 }
 
+// hostErrorCode is the WebAssembly function export "__host_error_code", which returns the code of the current host
+// error from invokeContext.hostErr, see ErrorCode.
+func (w *hookrModule) hostErrorCode(ctx context.Context, results []uint64) {
+	results[0] = uint64(CodeNone)
+	if ic := invoke.From(ctx); ic != nil && ic.HostErr != nil {
+		results[0] = uint64(ErrorCode(ic.HostErr))
+	}
+}
+
+// streamRead is the WebAssembly function export "__stream_read", which reads up to len bytes of the
+// invokeContext.streamIn into linear memory (wasm.Memory) at the given offset (ptr). It returns the number of bytes
+// read, 0 at the end of the stream or -1 on error, in which case the error is available through "__host_error".
+// The result is a signed i32, so a single read returns at most math.MaxInt32 bytes.
+func (w *hookrModule) streamRead(ctx context.Context, m api.Module, stack []uint64) {
+	ptr := api.DecodeU32(stack[0])
+	bufLen := min(api.DecodeU32(stack[1]), math.MaxInt32) // larger counts would read as an error
+
+	ic := invoke.From(ctx)
+	if ic == nil || ic.StreamIn == nil || bufLen == 0 {
+		stack[0] = 0 // no stream to read from
+		return
+	}
+
+	// Read directly into linear memory, so the chunk is never copied on the host.
+	buf := memory.Read(m.Memory(), "stream", ptr, bufLen)
+	n, err := io.ReadAtLeast(ic.StreamIn, buf, 1)
+	switch {
+	case err == io.EOF:
+		stack[0] = 0
+	case err != nil && n == 0:
+		ic.HostErr = err
+		stack[0] = api.EncodeI32(-1)
+	default:
+		readLen, err := memory.Uint32FromInt(n)
+		if err != nil {
+			panic(err)
+		}
+		stack[0] = uint64(readLen)
+	}
+}
+
+// streamWrite is the WebAssembly function export "__stream_write", which writes the chunk stored by the guest at
+// the given offset (ptr) and length (len) in linear memory (wasm.Memory) to invokeContext.streamOut. It returns true
+// (1) when the chunk was written.
+func (w *hookrModule) streamWrite(ctx context.Context, m api.Module, stack []uint64) {
+	ptr := api.DecodeU32(stack[0])
+	dataLen := api.DecodeU32(stack[1])
+
+	ic := invoke.From(ctx)
+	if ic == nil || ic.StreamOut == nil {
+		stack[0] = 0 // false: no stream to write to
+		return
+	}
+
+	chunk := memory.Read(m.Memory(), "stream", ptr, dataLen)
+	if _, ic.HostErr = ic.StreamOut.Write(chunk); ic.HostErr != nil {
+		stack[0] = 0 // false: the consumer has gone away
+	} else {
+		stack[0] = 1 // true
+	}
+}
+
 func New(
 	ctx context.Context,
 	rt wazero.Runtime,
 	callHandler CallHandler,
 	logger logger.Logger,
+	opts ...Option,
 ) (api.Module, error) {
-	return instantiateHookrModule(ctx, rt, callHandler, logger)
+	return instantiateHookrModule(ctx, rt, callHandler, logger, opts...)
 }
@@ -2,7 +2,11 @@ package module
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"testing"
+
+	"github.com/mopeyjellyfish/hookr/runtime/invoke"
 )
 
 func TestHookrModuleErrors(t *testing.T) {
@@ -20,4 +24,64 @@ func TestHookrModuleErrors(t *testing.T) {
 	m.pluginRequest(context.Background(), nil, results)
 	m.pluginResponse(context.Background(), nil, results)
 	m.pluginError(context.Background(), nil, results)
+	m.streamRead(context.Background(), nil, results)
+	m.streamWrite(context.Background(), nil, results)
+
+	invokeResults := make([]uint64, 6)
+	m.hostInvoke(context.Background(), nil, invokeResults)
+	if invokeResults[0] != Failed {
+		t.Errorf("hostInvoke without an invocation context returned %d, want Failed", invokeResults[0])
+	}
+}
+
+type codedError struct{}
+
+func (codedError) Error() string     { return "coded" }
+func (codedError) ErrorCode() uint32 { return CodeQuotaRate }
+
+func TestHostErrorCode(t *testing.T) {
+	m := hookrModule{}
+	results := make([]uint64, 1)
+	ic := &invoke.Context{HostErr: fmt.Errorf("wrapped: %w", codedError{})}
+	m.hostErrorCode(invoke.New(context.Background(), ic), results)
+	if results[0] != uint64(CodeQuotaRate) {
+		t.Errorf("hostErrorCode = %d, want CodeQuotaRate", results[0])
+	}
+
+	ic.HostErr = errors.New("plain")
+	m.hostErrorCode(invoke.New(context.Background(), ic), results)
+	if results[0] != uint64(CodeNone) {
+		t.Errorf("hostErrorCode of an error without a code = %d, want CodeNone", results[0])
+	}
+}
+
+func TestPack(t *testing.T) {
+	ptr, size := Unpack(Pack(0xdeadbeef, 42))
+	if ptr != 0xdeadbeef || size != 42 {
+		t.Errorf("Unpack(Pack(0xdeadbeef, 42)) = %#x, %d", ptr, size)
+	}
+	if _, err := Alloc(context.Background(), nil, 8); err == nil {
+		t.Error("Alloc without an allocator succeeded")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	limits := Limits{PluginRequest: 1, PluginResponse: 2, HostPayload: 3, HostResponse: 4}
+	for limit, size := range map[string]uint64{
+		LimitPluginRequest:  1,
+		LimitPluginResponse: 2,
+		LimitHostPayload:    3,
+		LimitHostResponse:   4,
+	} {
+		if err := limits.Check(limit, size); err != nil {
+			t.Errorf("Check(%q, %d) = %v, want nil", limit, size, err)
+		}
+		err := limits.Check(limit, size+1)
+		if sizeErr, ok := err.(*SizeError); !ok || sizeErr.Limit != limit || !errors.Is(err, ErrTooLarge) {
+			t.Errorf("Check(%q, %d) = %v, want a SizeError", limit, size+1, err)
+		}
+	}
+	if err := (Limits{}).Check(LimitHostResponse, 1<<40); err != nil {
+		t.Errorf("zero limits are unlimited, got %v", err)
+	}
 }
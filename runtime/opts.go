@@ -1,10 +1,14 @@
 package runtime
 
 import (
+	"errors"
+	"fmt"
 	"io"
+	"time"
 
 	"github.com/mopeyjellyfish/hookr/runtime/logger"
 	"github.com/mopeyjellyfish/hookr/runtime/module"
+	"github.com/tetratelabs/wazero"
 )
 
 type Option func(*Runtime) error
@@ -71,3 +75,220 @@ func WithHostFns(fns ...HostFunc) Option {
 		return nil
 	}
 }
+
+// WithShutdownTimeout sets how long the plugin's shutdown function may run when the runtime is closed. It enables
+// WithInterruptOnContextDone.
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(e *Runtime) error {
+		e.shutdownTimeout = timeout
+		return WithInterruptOnContextDone()(e)
+	}
+}
+
+// WithStateChange sets a function which is called whenever the lifecycle state of the runtime changes.
+func WithStateChange(fn StateChangeFn) Option {
+	return func(e *Runtime) error {
+		e.onStateChange = fn
+		return nil
+	}
+}
+
+// WithInitTimeout sets how long the plugin's initialization functions may run, the plugin fails to load when
+// they take longer. It enables WithInterruptOnContextDone.
+func WithInitTimeout(timeout time.Duration) Option {
+	return func(e *Runtime) error {
+		e.initTimeout = timeout
+		return WithInterruptOnContextDone()(e)
+	}
+}
+
+// WithInterruptOnContextDone interrupts plugin code still running once the context of its call is done, so
+// cancellation and deadlines also stop plugins which never return to the host, such as one stuck in a loop. The
+// interrupted call fails with the context's error and the plugin is restarted, see Trap Recovery. Without it a
+// call only notices its context while the plugin calls the host. It makes calls slightly slower, so it is off by
+// default.
+func WithInterruptOnContextDone() Option {
+	return func(e *Runtime) error {
+		e.interrupt = true
+		e.newRuntime = e.configuredRuntime
+		return nil
+	}
+}
+
+// WithCircuitBreaker guards every plugin operation with its own circuit breaker, see Runtime.Invoke.
+// PluginFnByte and PluginFnSerial can override the configuration with WithPluginFnBreaker.
+func WithCircuitBreaker(cfg BreakerConfig) Option {
+	return func(e *Runtime) error {
+		e.breakerCfg = &cfg
+		return nil
+	}
+}
+
+// WithIsolation runs every call in a fresh plugin instance, instantiated from the compiled module, so no call
+// can observe the memory of another. This is slower than sharing an instance and re-runs the plugin's
+// initialization for every call.
+func WithIsolation() Option {
+	return func(e *Runtime) error {
+		e.isolated = true
+		return nil
+	}
+}
+
+// WithSnapshot captures the plugin's memory and globals once it is initialized. Instances created later, when
+// the plugin is restarted or calls are isolated, are restored from the snapshot instead of running the plugin's
+// initialization again. Every restored instance starts with the same state, including any random seeds. A reload
+// handing the plugin's state to the new version captures the snapshot once the state is imported.
+func WithSnapshot() Option {
+	return func(e *Runtime) error {
+		e.snapshotting = true
+		return nil
+	}
+}
+
+// WithClock sets the clock the plugin's WASI clocks and sleeps use instead of the system clock.
+func WithClock(clock Clock) Option {
+	return func(e *Runtime) error {
+		e.clock = clock
+		return nil
+	}
+}
+
+// WithDeterministic makes the plugin observe the same time and randomness on every run. Its random source is
+// seeded with seed and its clocks start at startTime, only moving when the plugin sleeps, which returns
+// immediately. Identical inputs then produce identical outputs.
+func WithDeterministic(seed int64, startTime time.Time) Option {
+	return func(e *Runtime) error {
+		e.rand = seededReader(seed)
+		e.clock = NewVirtualClock(startTime)
+		return nil
+	}
+}
+
+// WithRecorder records every invocation of the plugin and the host calls it makes, see Replay.
+func WithRecorder(recorder *Recorder) Option {
+	return func(e *Runtime) error {
+		e.recorder = recorder
+		return nil
+	}
+}
+
+// WithLinker registers the plugin with the Linker under the name, so it can
+// call the plugins it is linked to and be called by the plugins linked to it.
+// It enables WithInterruptOnContextDone, so hop timeouts stop the called plugin.
+func WithLinker(linker *Linker, name string) Option {
+	return func(e *Runtime) error {
+		if name == "" {
+			return errors.New("linked plugin needs a name")
+		}
+		e.linker = linker
+		e.linkName = name
+		return WithInterruptOnContextDone()(e)
+	}
+}
+
+// WithRateLimit limits the rate of all host calls of the plugin, calls exceeding it fail with a QuotaError.
+func WithRateLimit(limit RateLimit) Option {
+	return func(e *Runtime) error {
+		if limit.Rate <= 0 {
+			return errors.New("rate limit needs a positive rate")
+		}
+		e.rateLimit = newTokenBucket(limit)
+		return nil
+	}
+}
+
+// WithHostFnRateLimit limits the rate of the plugin's calls of the host function, calls exceeding it fail with a
+// QuotaError. It applies in addition to WithRateLimit.
+func WithHostFnRateLimit(operation string, limit RateLimit) Option {
+	return func(e *Runtime) error {
+		if limit.Rate <= 0 {
+			return fmt.Errorf("rate limit of host function %q needs a positive rate", operation)
+		}
+		if e.hostFnLimits == nil {
+			e.hostFnLimits = map[string]*tokenBucket{}
+		}
+		e.hostFnLimits[operation] = newTokenBucket(limit)
+		return nil
+	}
+}
+
+// WithQuota limits the host calls of every invocation of the plugin, calls exceeding it fail with a QuotaError.
+func WithQuota(quota Quota) Option {
+	return func(e *Runtime) error {
+		e.quota = quota
+		return nil
+	}
+}
+
+// WithLimits limits the sizes of plugin requests and responses and of host call payloads and responses, data
+// exceeding a limit fails with a *SizeError before it is copied.
+func WithLimits(limits Limits) Option {
+	return func(e *Runtime) error {
+		e.limits = limits
+		return nil
+	}
+}
+
+// WithCompilationCache shares compiled modules with the other runtimes using the cache, so a module loaded by
+// many runtimes is only compiled once. The cache must be closed by the caller once every runtime is closed.
+func WithCompilationCache(cache wazero.CompilationCache) Option {
+	return func(e *Runtime) error {
+		if cache == nil {
+			return errors.New("compilation cache cannot be nil")
+		}
+		e.cache = cache
+		e.newRuntime = e.configuredRuntime
+		return nil
+	}
+}
+
+// WithMemoryLimitPages limits the memory of the plugin to the number of 64 KiB pages, growing the memory beyond
+// the limit fails and modules requiring more memory fail to load.
+func WithMemoryLimitPages(pages uint32) Option {
+	return func(e *Runtime) error {
+		if pages == 0 || pages > MaxMemoryPages {
+			return fmt.Errorf("memory limit must be between 1 and %d pages", MaxMemoryPages)
+		}
+		e.memoryLimit = pages
+		e.newRuntime = e.configuredRuntime
+		return nil
+	}
+}
+
+// WithCallTimeout bounds every call of a plugin function, a call which does not return in time is interrupted
+// and fails as by a cancelled context. It enables WithInterruptOnContextDone.
+func WithCallTimeout(timeout time.Duration) Option {
+	return func(e *Runtime) error {
+		if timeout <= 0 {
+			return errors.New("call timeout must be positive")
+		}
+		e.callTimeout = timeout
+		return WithInterruptOnContextDone()(e)
+	}
+}
+
+// WithAllowedHostFns grants the plugin the host functions of the operations, calls of any other host function
+// fail with ErrNotGranted. By default every registered host function may be called. Reading the configuration
+// and calling linked plugins are governed by WithConfig and WithLinker instead.
+func WithAllowedHostFns(operations ...string) Option {
+	return func(e *Runtime) error {
+		if e.allowedHostFns == nil {
+			e.allowedHostFns = make(map[string]struct{}, len(operations))
+		}
+		for _, operation := range operations {
+			e.allowedHostFns[operation] = struct{}{}
+		}
+		return nil
+	}
+}
+
+// WithConfig sets the configuration the plugin reads with pdk.Config.
+func WithConfig(config map[string]string) Option {
+	return func(e *Runtime) error {
+		e.pluginConfig = make(map[string]string, len(config))
+		for key, value := range config {
+			e.pluginConfig[key] = value
+		}
+		return nil
+	}
+}
@@ -0,0 +1,139 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mopeyjellyfish/hookr/runtime/invoke"
+	"github.com/mopeyjellyfish/hookr/runtime/module"
+)
+
+// ErrQuotaExceeded is matched by the QuotaError of host calls refused by a rate limit or quota.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Limits which can refuse host calls, reported by QuotaError.
+const (
+	LimitRate      = "rate"
+	LimitHostCalls = "host calls"
+	LimitHostBytes = "host bytes"
+)
+
+// QuotaError is the error of a host call refused because the plugin exceeded a rate limit or quota. The plugin
+// receives it as the error of the host call, see pdk.QuotaError.
+type QuotaError struct {
+	// Limit is the exceeded limit: LimitRate, LimitHostCalls or LimitHostBytes.
+	Limit string
+
+	// Operation is the refused host call.
+	Operation string
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s: %s limit of host call %q", ErrQuotaExceeded, e.Limit, e.Operation)
+}
+
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// ErrorCode returns the code of the exceeded limit, so the plugin can tell it without parsing the message.
+func (e *QuotaError) ErrorCode() uint32 {
+	switch e.Limit {
+	case LimitHostCalls:
+		return module.CodeQuotaHostCalls
+	case LimitHostBytes:
+		return module.CodeQuotaHostBytes
+	}
+	return module.CodeQuotaRate
+}
+
+// RateLimit is a token bucket refilled with Rate tokens per second up to Burst, every host call takes a token.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// Quota limits the host calls of a single invocation, zero values are unlimited.
+type Quota struct {
+	// MaxHostCalls is the number of host calls an invocation may make.
+	MaxHostCalls int
+
+	// MaxHostBytes is the total size of the payloads of an invocation's host calls.
+	MaxHostBytes uint64
+}
+
+// tokenBucket implements a RateLimit. It is safe for concurrent use.
+type tokenBucket struct {
+	mu     sync.Mutex
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	return &tokenBucket{limit: limit, tokens: float64(limit.Burst), now: time.Now}
+}
+
+// allow takes a token, returning false when there is none.
+func (b *tokenBucket) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+		b.tokens = min(b.tokens, float64(b.limit.Burst))
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// refund returns a token taken by allow, for a call refused by another limit.
+func (b *tokenBucket) refund() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.tokens+1, float64(b.limit.Burst))
+}
+
+// limit is the module.Limiter of the runtime, enforcing its quota and rate limits. Only the allowed host calls count
+// towards the quota and take a token of the runtime's rate limit.
+func (e *Runtime) limit(ctx context.Context, operation string, payloadLen uint32) error {
+	ic := invoke.From(ctx)
+	if ic != nil {
+		switch {
+		case e.quota.MaxHostCalls > 0 && ic.HostCalls >= e.quota.MaxHostCalls:
+			return &QuotaError{Limit: LimitHostCalls, Operation: operation}
+		case e.quota.MaxHostBytes > 0 && ic.HostBytes+uint64(payloadLen) > e.quota.MaxHostBytes:
+			return &QuotaError{Limit: LimitHostBytes, Operation: operation}
+		}
+	}
+	if e.rateLimit != nil && !e.rateLimit.allow() {
+		return &QuotaError{Limit: LimitRate, Operation: operation}
+	}
+	if b := e.hostFnLimits[operation]; b != nil && !b.allow() {
+		if e.rateLimit != nil {
+			e.rateLimit.refund()
+		}
+		return &QuotaError{Limit: LimitRate, Operation: operation}
+	}
+	if ic != nil {
+		ic.HostCalls++
+		ic.HostBytes += uint64(payloadLen)
+	}
+	return nil
+}
+
+// limited reports whether host calls need to be checked by limit.
+func (e *Runtime) limited() bool {
+	return e.rateLimit != nil || len(e.hostFnLimits) > 0 || e.quota != Quota{}
+}
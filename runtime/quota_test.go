@@ -0,0 +1,133 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mopeyjellyfish/hookr/runtime/invoke"
+	"github.com/mopeyjellyfish/hookr/runtime/module"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newTokenBucket(RateLimit{Rate: 2, Burst: 2})
+	b.now = func() time.Time { return now }
+
+	require.True(t, b.allow())
+	require.True(t, b.allow())
+	require.False(t, b.allow(), "the burst is used up")
+
+	now = now.Add(500 * time.Millisecond)
+	require.True(t, b.allow(), "a token is refilled after 1/rate")
+	require.False(t, b.allow())
+
+	now = now.Add(time.Hour)
+	require.True(t, b.allow())
+	require.True(t, b.allow())
+	require.False(t, b.allow(), "tokens never exceed the burst")
+}
+
+func TestRateLimitRefused(t *testing.T) {
+	ctx := context.Background()
+	e := &Runtime{
+		rateLimit:    newTokenBucket(RateLimit{Rate: 0.001, Burst: 2}),
+		hostFnLimits: map[string]*tokenBucket{"throttled": newTokenBucket(RateLimit{Rate: 0.001, Burst: 1})},
+	}
+
+	require.NoError(t, e.limit(ctx, "throttled", 0))
+	for range 10 {
+		require.ErrorIs(t, e.limit(ctx, "throttled", 0), ErrQuotaExceeded)
+	}
+	require.NoError(t, e.limit(ctx, "other", 0), "calls refused by a host function's limit do not take a token of the runtime's")
+	require.ErrorIs(t, e.limit(ctx, "other", 0), ErrQuotaExceeded)
+}
+
+func TestRateLimit(t *testing.T) {
+	for name, file := range map[string]string{"Alloc": ABI_WASM, "Legacy": LEGACY_WASM} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			calls := 0
+			rt, err := New(ctx, WithFile(file),
+				WithHostFns(HostFnByte("hello", func(ctx context.Context, payload []byte) ([]byte, error) {
+					calls++
+					return reply(ctx, payload)
+				})),
+				WithHostFnRateLimit("hello", RateLimit{Rate: 0.001, Burst: 2}),
+			)
+			require.NoError(t, err)
+			defer rt.Close(ctx)
+
+			for range 2 {
+				_, err = rt.Invoke(ctx, "echo", []byte("a"))
+				require.NoError(t, err)
+			}
+			_, err = rt.Invoke(ctx, "echo", []byte("a"))
+			require.ErrorContains(t, err, `quota exceeded: rate limit of host call "hello"`, "the guest receives the quota error")
+			require.Equal(t, 2, calls, "refused calls do not reach the host function")
+
+			out, err := rt.Invoke(ctx, "noop", []byte("b"))
+			require.NoError(t, err, "invocations without host calls are not limited")
+			require.Equal(t, []byte("b"), out)
+		})
+	}
+}
+
+func TestQuota(t *testing.T) {
+	ctx := context.Background()
+	rt, err := New(ctx, WithFile(ABI_WASM),
+		WithHostFns(HostFnByte("hello", reply)),
+		WithQuota(Quota{MaxHostBytes: 4}),
+	)
+	require.NoError(t, err)
+	defer rt.Close(ctx)
+
+	_, err = rt.Invoke(ctx, "echo", []byte("1234"))
+	require.NoError(t, err)
+	_, err = rt.Invoke(ctx, "echo", []byte("12345"))
+	require.ErrorContains(t, err, `quota exceeded: host bytes limit of host call "hello"`)
+	_, err = rt.Invoke(ctx, "echo", []byte("1234"))
+	require.NoError(t, err, "quotas are per invocation")
+
+	// the host call count is per invocation too
+	rt.quota = Quota{MaxHostCalls: 2}
+	ic := &invoke.Context{}
+	ctx = invoke.New(ctx, ic)
+	require.NoError(t, rt.limit(ctx, "a", 0))
+	require.NoError(t, rt.limit(ctx, "b", 0))
+	err = rt.limit(ctx, "c", 0)
+	var quotaErr *QuotaError
+	require.True(t, errors.As(err, &quotaErr))
+	require.Equal(t, LimitHostCalls, quotaErr.Limit)
+	require.Equal(t, "c", quotaErr.Operation)
+	require.ErrorIs(t, err, ErrQuotaExceeded)
+	require.Equal(t, module.CodeQuotaHostCalls, module.ErrorCode(err))
+	require.Equal(t, 2, ic.HostCalls, "refused calls are not counted")
+	require.NoError(t, rt.limit(invoke.New(context.Background(), &invoke.Context{}), "a", 0))
+
+	// a refused call does not use up the quota of the following ones
+	rt.quota = Quota{MaxHostBytes: 4}
+	ic = &invoke.Context{}
+	ctx = invoke.New(context.Background(), ic)
+	require.ErrorIs(t, rt.limit(ctx, "a", 5), ErrQuotaExceeded)
+	require.NoError(t, rt.limit(ctx, "b", 4))
+	require.Equal(t, uint64(4), ic.HostBytes)
+}
+
+func TestRateLimitOptions(t *testing.T) {
+	ctx := context.Background()
+	_, err := New(ctx, WithFile(ABI_WASM), WithRateLimit(RateLimit{}))
+	require.Error(t, err, "expected error for a rate limit without a rate")
+	_, err = New(ctx, WithFile(ABI_WASM), WithHostFnRateLimit("hello", RateLimit{Rate: -1}))
+	require.Error(t, err, "expected error for a negative rate")
+
+	rt, err := New(ctx, WithFile(ABI_WASM), WithHostFns(HostFnByte("hello", reply)), WithRateLimit(RateLimit{Rate: 0.001}))
+	require.NoError(t, err)
+	defer rt.Close(ctx)
+	_, err = rt.Invoke(ctx, "echo", nil)
+	require.NoError(t, err)
+	_, err = rt.Invoke(ctx, "echo", nil)
+	require.ErrorContains(t, err, "quota exceeded: rate limit", "the burst defaults to a single call")
+}
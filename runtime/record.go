@@ -0,0 +1,142 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/mopeyjellyfish/hookr/runtime/module"
+)
+
+// RecordingVersion is the version of the recording format written by Recorder.
+const RecordingVersion = 1
+
+type (
+	// RecordingHeader is the first line of a recording. Config is the
+	// configuration the plugin reads with pdk.Config, see WithConfig.
+	RecordingHeader struct {
+		Version int               `json:"version"`
+		Module  string            `json:"module"` // SHA-256 of the plugin
+		Config  map[string]string `json:"config,omitempty"`
+	}
+
+	// RecordedHostCall is a host call made by the plugin with the host's answer.
+	// Code is the code the plugin saw with the error, see module.CodedError.
+	RecordedHostCall struct {
+		Operation string `json:"operation"`
+		Payload   []byte `json:"payload,omitempty"`
+		Response  []byte `json:"response,omitempty"`
+		Error     string `json:"error,omitempty"`
+		Code      uint32 `json:"code,omitempty"`
+	}
+
+	// RecordedInvocation is a call of the plugin with its outcome and the host
+	// calls it made. Init is set for the initialization functions of the plugin,
+	// which run when it is created or restarted. Batch is set for batches sent
+	// in a single call, the payload and the output are the encoded batch and
+	// results. Module is set by ReadRecording from the header preceding the
+	// invocation.
+	RecordedInvocation struct {
+		Module    string             `json:"-"`
+		Init      bool               `json:"init,omitempty"`
+		Batch     bool               `json:"batch,omitempty"`
+		Operation string             `json:"operation"`
+		Payload   []byte             `json:"payload,omitempty"`
+		Output    []byte             `json:"output,omitempty"`
+		Error     string             `json:"error,omitempty"`
+		HostCalls []RecordedHostCall `json:"host_calls,omitempty"`
+	}
+)
+
+// Recorder writes every invocation of a plugin and the host calls it makes to
+// a recording, one JSON object per line, which Replay re-runs. Streams and the
+// smoke tests of reloads are not recorded. A reload writes a new header with
+// the module of the new version, the invocations after it were made with that
+// module. It is safe for concurrent use.
+type Recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewRecorder returns a Recorder writing to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// Err returns the first error writing the recording.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Recorder returns the Recorder the plugin's calls are recorded with, see
+// WithRecorder, nil when they are not recorded.
+func (e *Runtime) Recorder() *Recorder {
+	return e.recorder
+}
+
+func (r *Recorder) write(v any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = r.enc.Encode(v)
+	}
+}
+
+// recordHeader writes the header of the module the runtime runs and the
+// plugin's configuration when it has a Recorder.
+func (e *Runtime) recordHeader() {
+	if e.recorder != nil {
+		e.recorder.write(RecordingHeader{Version: RecordingVersion, Module: e.moduleHash, Config: e.pluginConfig})
+	}
+}
+
+type recordingKey struct{}
+
+// withRecording returns ctx recording the host calls made by the plugin into rec.
+func withRecording(ctx context.Context, rec *RecordedInvocation) context.Context {
+	return context.WithValue(ctx, recordingKey{}, rec)
+}
+
+// recordHostCall adds the host call to the invocation recorded in ctx, if any.
+func recordHostCall(ctx context.Context, operation string, payload, response []byte, err error) {
+	rec, ok := ctx.Value(recordingKey{}).(*RecordedInvocation)
+	if !ok {
+		return
+	}
+	rec.HostCalls = append(rec.HostCalls, RecordedHostCall{
+		Operation: operation,
+		Payload:   bytes.Clone(payload),
+		Response:  bytes.Clone(response),
+		Error:     errString(err),
+		Code:      module.ErrorCode(err),
+	})
+}
+
+// record calls fn recording it as the invocation rec when the runtime has a
+// Recorder.
+func (e *Runtime) record(ctx context.Context, rec RecordedInvocation, fn func(context.Context) ([]byte, error)) ([]byte, error) {
+	if e.recorder == nil {
+		return fn(ctx)
+	}
+	rec.Payload = bytes.Clone(rec.Payload)
+	out, err := fn(withRecording(ctx, &rec))
+	if rec.Init && len(rec.HostCalls) == 0 {
+		return out, err // nothing to replay
+	}
+	rec.Output = bytes.Clone(out)
+	rec.Error = errString(err)
+	e.recorder.write(&rec)
+	return out, err
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,206 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mopeyjellyfish/hookr/runtime/snapshot"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// SmokeTest is a call made to a reloaded plugin before it replaces the previous
+// version, which is kept when the call fails or Check returns an error.
+type SmokeTest struct {
+	Operation string
+	Payload   []byte
+
+	// Check validates the output of the call, any output is accepted when nil.
+	Check func(output []byte) error
+}
+
+// ReloadError is returned when a new version of the plugin could not be
+// loaded, the runtime keeps running the previous version.
+type ReloadError struct {
+	Path string
+	Err  error
+}
+
+func (e *ReloadError) Error() string {
+	return fmt.Sprintf("failed to reload %s, keeping the previous version: %v", e.Path, e.Err)
+}
+
+func (e *ReloadError) Unwrap() error {
+	return e.Err
+}
+
+// version is the module a runtime runs, replaced by Reload.
+type version struct {
+	file            *File
+	compiled        wazero.CompiledModule
+	moduleHash      string
+	snapshotGlobals []uint32
+	snapshot        *snapshot.Snapshot
+
+	plugin       api.Module
+	pluginCall   api.Function
+	pluginInvoke api.Function
+	alloc        api.Function
+	free         api.Function
+	lease        uint32
+	leaseCap     uint32
+	used         bool
+}
+
+// version returns the module the runtime runs.
+// The caller must hold e.mu.
+func (e *Runtime) version() version {
+	return version{
+		file:            e.file,
+		compiled:        e.compiled,
+		moduleHash:      e.moduleHash,
+		snapshotGlobals: e.snapshotGlobals,
+		snapshot:        e.snapshot,
+		plugin:          e.plugin,
+		pluginCall:      e.pluginCall,
+		pluginInvoke:    e.pluginInvoke,
+		alloc:           e.alloc,
+		free:            e.free,
+		lease:           e.lease,
+		leaseCap:        e.leaseCap,
+		used:            e.used,
+	}
+}
+
+// setVersion makes the runtime run the module.
+// The caller must hold e.mu.
+func (e *Runtime) setVersion(v version) {
+	e.file = v.file
+	e.compiled = v.compiled
+	e.moduleHash = v.moduleHash
+	e.snapshotGlobals = v.snapshotGlobals
+	e.snapshot = v.snapshot
+	e.plugin = v.plugin
+	e.pluginCall = v.pluginCall
+	e.pluginInvoke = v.pluginInvoke
+	e.alloc = v.alloc
+	e.free = v.free
+	e.lease = v.lease
+	e.leaseCap = v.leaseCap
+	e.used = v.used
+}
+
+// close closes the instance and the compiled module of the version. The
+// compiled module is kept when other runs the same module, wazero shares the
+// compiled code of identical modules.
+func (v version) close(ctx context.Context, other version) {
+	if v.plugin != nil && !v.plugin.IsClosed() {
+		_ = v.plugin.Close(ctx)
+	}
+	if v.compiled != nil && v.moduleHash != other.moduleHash {
+		_ = v.compiled.Close(ctx)
+	}
+}
+
+// File returns the file of the running version of the plugin, nil when it was
+// not loaded from a file.
+func (e *Runtime) File() *File {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file
+}
+
+// Reload replaces the plugin with the module of the file, see Watch. The new
+// module is compiled while calls continue, it is swapped in once the in-flight
+// calls finished and the plugin is initialized again. The state of the running
+// version is handed to the new one when both support it, see pdk.OnExportState.
+// When the new module fails to compile, initialize, import the state or pass
+// the smoke test, if any, the previous version keeps running and a
+// *ReloadError is returned. Otherwise the previous version's shutdown function
+// is called before it is closed, see pdk.OnShutdown.
+func (e *Runtime) Reload(ctx context.Context, file *File, smoke *SmokeTest) error {
+	if e.r == nil {
+		return errNotInitialized
+	}
+	if file == nil {
+		return errors.New("file cannot be nil")
+	}
+	ctx, done, err := e.inflight.enter(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	next, err := e.compile(ctx, file)
+	if err != nil {
+		return &ReloadError{Path: file.Path, Err: err}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.plugin == nil {
+		next.close(e.ctx, e.version())
+		return errNotInitialized
+	}
+	prev := e.version()
+	e.setVersion(next)
+	e.recordHeader()
+	err = e.Instantiate()
+	if err == nil && prev.plugin != nil && !prev.plugin.IsClosed() {
+		err = e.migrateState(ctx, prev.plugin)
+	}
+	if err == nil && smoke != nil {
+		err = e.smokeTest(ctx, smoke)
+	}
+	if err != nil {
+		e.version().close(e.ctx, prev)
+		e.setVersion(prev)
+		e.recordHeader()
+		return &ReloadError{Path: file.Path, Err: err}
+	}
+	// the new version is running, a failing shutdown of the previous one is only logged
+	if err := e.shutdownPlugin(context.WithoutCancel(ctx), prev.plugin); err != nil && e.logger != nil {
+		e.logger(fmt.Sprintf("failed to shut down the previous version of %s: %v", file.Path, err))
+	}
+	prev.close(e.ctx, e.version())
+	return nil
+}
+
+// compile compiles the module of the file as a new version of the plugin.
+func (e *Runtime) compile(ctx context.Context, file *File) (version, error) {
+	d, err := file.GetData()
+	if err != nil {
+		return version{}, fmt.Errorf("failed to get data from file: %w", err)
+	}
+	next := version{file: file}
+	if next.moduleHash, err = (Sha256Hasher{}).Hash(d); err != nil {
+		return version{}, fmt.Errorf("failed to hash module: %w", err)
+	}
+	if e.snapshotting {
+		if d, next.snapshotGlobals, err = snapshot.Instrument(d); err != nil {
+			return version{}, fmt.Errorf("failed to prepare module for snapshots: %w", err)
+		}
+	}
+	if next.compiled, err = e.r.CompileModule(ctx, d); err != nil {
+		return version{}, fmt.Errorf("failed to compile module: %w", err)
+	}
+	return next, nil
+}
+
+// smokeTest calls the plugin with the smoke test, the call is not recorded.
+// The caller must hold e.mu.
+func (e *Runtime) smokeTest(ctx context.Context, smoke *SmokeTest) error {
+	out, err := e.call(ctx, smoke.Operation, smoke.Payload)
+	if err != nil {
+		return fmt.Errorf("smoke test failed: %w", err)
+	}
+	if smoke.Check != nil {
+		if err := smoke.Check(bytes.Clone(out)); err != nil {
+			return fmt.Errorf("smoke test failed: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newReloadable(t *testing.T, file string, opts ...Option) *Runtime {
+	t.Helper()
+	ctx := context.Background()
+	rt, err := New(ctx, append([]Option{WithFile(file), WithHostFns(HostFnByte("hello", reply))}, opts...)...)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = rt.Close(ctx)
+	})
+	return rt
+}
+
+func mustFile(t *testing.T, path string) *File {
+	t.Helper()
+	file, err := NewFile(path)
+	require.NoError(t, err)
+	return file
+}
+
+func TestReload(t *testing.T) {
+	ctx := context.Background()
+	rt := newReloadable(t, ABI_WASM)
+	require.NotNil(t, rt.pluginInvoke)
+
+	require.NoError(t, rt.Reload(ctx, mustFile(t, LEGACY_WASM), &SmokeTest{Operation: "noop", Payload: []byte("ok")}))
+	require.Nil(t, rt.pluginInvoke, "the legacy module is running")
+	out, err := rt.Invoke(ctx, "echo", []byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("re: a"), out)
+
+	require.NoError(t, rt.Close(ctx))
+	require.ErrorIs(t, rt.Reload(ctx, mustFile(t, ABI_WASM), nil), ErrClosed)
+}
+
+func TestReloadRollback(t *testing.T) {
+	ctx := context.Background()
+	rt := newReloadable(t, ABI_WASM)
+	_, err := rt.Invoke(ctx, "echo", []byte("a"))
+	require.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		file  string
+		smoke *SmokeTest
+		err   string
+	}{
+		"Invalid":     {file: INVALID_WASM, err: "failed to compile module"},
+		"Instantiate": {file: EMPTY_WASM, err: "didn't export function"},
+		"SmokeCall":   {file: LEGACY_WASM, smoke: &SmokeTest{Operation: "echo", Payload: []byte("fail")}, err: "smoke test failed: host failed"},
+		"SmokeCheck": {file: LEGACY_WASM, smoke: &SmokeTest{Operation: "noop", Check: func([]byte) error {
+			return errors.New("bad output")
+		}}, err: "smoke test failed: bad output"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := rt.Reload(ctx, mustFile(t, tc.file), tc.smoke)
+			var reloadErr *ReloadError
+			require.ErrorAs(t, err, &reloadErr)
+			require.Equal(t, tc.file, reloadErr.Path)
+			require.ErrorContains(t, err, tc.err)
+
+			require.NotNil(t, rt.pluginInvoke, "the previous version keeps running")
+			out, err := rt.Invoke(ctx, "echo", []byte("b"))
+			require.NoError(t, err)
+			require.Equal(t, []byte("re: b"), out)
+		})
+	}
+}
+
+func TestReloadConcurrent(t *testing.T) {
+	ctx := context.Background()
+	rt := newReloadable(t, ABI_WASM)
+
+	var wg sync.WaitGroup
+	for _, file := range []string{ABI_WASM, LEGACY_WASM, ABI_WASM} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, rt.Reload(ctx, mustFile(t, file), nil))
+		}()
+	}
+	wg.Wait()
+
+	out, err := rt.Invoke(ctx, "echo", []byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("re: a"), out)
+}
+
+func TestReloadShutsDownPrevious(t *testing.T) {
+	ctx := context.Background()
+	var logs []string
+	rt, err := New(ctx, WithFile(LIFECYCLE_WASM), WithLogger(func(msg string) { logs = append(logs, msg) }))
+	require.NoError(t, err)
+
+	require.NoError(t, rt.Reload(ctx, mustFile(t, LIFECYCLE_WASM), nil))
+	require.Equal(t, []string{"shutdown"}, logs, "the previous version is shut down")
+	require.NoError(t, rt.Close(ctx))
+	require.Equal(t, []string{"shutdown", "shutdown"}, logs)
+}
+
+func TestReloadWaitsForCalls(t *testing.T) {
+	ctx := context.Background()
+	release := make(chan struct{})
+	rt, err := New(ctx, WithFile(ABI_WASM), WithHostFns(HostFnByte("hello", func(ctx context.Context, payload []byte) ([]byte, error) {
+		<-release
+		return reply(ctx, payload)
+	})))
+	require.NoError(t, err)
+	defer rt.Close(ctx)
+
+	inflight := make(chan error, 1)
+	go func() {
+		_, err := rt.Invoke(ctx, "echo", nil)
+		inflight <- err
+	}()
+	busy(t, rt)
+
+	reloaded := make(chan error, 1)
+	go func() {
+		reloaded <- rt.Reload(ctx, mustFile(t, LEGACY_WASM), nil)
+	}()
+	select {
+	case <-reloaded:
+		t.Fatal("reloaded during an in-flight call")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	require.NoError(t, <-inflight)
+	require.NoError(t, <-reloaded)
+}
+
+func TestReloadSameModule(t *testing.T) {
+	ctx := context.Background()
+	rt := newReloadable(t, ABI_WASM)
+	require.NoError(t, rt.Reload(ctx, mustFile(t, ABI_WASM), nil))
+
+	rt.mu.Lock()
+	err := rt.restart()
+	rt.mu.Unlock()
+	require.NoError(t, err, "the compiled module is shared with the previous version")
+	_, err = rt.Invoke(ctx, "echo", []byte("a"))
+	require.NoError(t, err)
+}
+
+// replace replaces the file at path with the file at src, like a deployment.
+func replace(t *testing.T, path, src string) {
+	t.Helper()
+	data, err := os.ReadFile(src)
+	require.NoError(t, err)
+	tmp := path + ".tmp"
+	require.NoError(t, os.WriteFile(tmp, data, 0o600))
+	require.NoError(t, os.Rename(tmp, path))
+}
+
+func TestWatch(t *testing.T) {
+	for name, opts := range map[string][]WatchOption{
+		"Notify": nil,
+		"Poll":   {WithPollInterval(10 * time.Millisecond)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			path := filepath.Join(t.TempDir(), "plugin.wasm")
+			replace(t, path, ABI_WASM)
+			rt := newReloadable(t, path)
+
+			reloads := make(chan error, 10)
+			w, err := rt.Watch(append(opts, WithReloadFn(func(p string, err error) {
+				require.Equal(t, path, p)
+				reloads <- err
+			}))...)
+			require.NoError(t, err)
+			defer w.Close()
+			require.Equal(t, path, w.Path())
+
+			replace(t, path, LEGACY_WASM)
+			require.NoError(t, <-reloads)
+			out, err := rt.Invoke(ctx, "echo", []byte("a"))
+			require.NoError(t, err)
+			require.Equal(t, []byte("re: a"), out)
+			rt.mu.Lock()
+			require.Nil(t, rt.pluginInvoke, "the new version is running")
+			rt.mu.Unlock()
+
+			replace(t, path, INVALID_WASM)
+			var reloadErr *ReloadError
+			require.ErrorAs(t, <-reloads, &reloadErr)
+			_, err = rt.Invoke(ctx, "echo", []byte("b"))
+			require.NoError(t, err, "the previous version keeps running")
+
+			require.NoError(t, w.Close())
+			require.NoError(t, w.Close(), "closing twice is allowed")
+		})
+	}
+}
+
+func TestWatchVerifiesHash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plugin.wasm")
+	replace(t, path, ABI_WASM)
+	data, err := os.ReadFile(ABI_WASM)
+	require.NoError(t, err)
+	hash, err := Sha256Hasher{}.Hash(data)
+	require.NoError(t, err)
+	rt := newReloadable(t, path)
+	rt.file.Hash, rt.file.hasher = hash, Sha256Hasher{}
+
+	_, err = rt.Watch(WithPollInterval(10 * time.Millisecond))
+	require.ErrorContains(t, err, "WithReloadFileOptions", "the hash only matches the running version")
+
+	next, err := os.ReadFile(LEGACY_WASM)
+	require.NoError(t, err)
+	nextHash, err := Sha256Hasher{}.Hash(next)
+	require.NoError(t, err)
+	reloads := make(chan error, 1)
+	w, err := rt.Watch(
+		WithPollInterval(10*time.Millisecond),
+		WithReloadFileOptions(WithHash(nextHash), WithHasher(Sha256Hasher{})),
+		WithReloadFn(func(_ string, err error) {
+			reloads <- err
+		}),
+	)
+	require.NoError(t, err)
+	defer w.Close()
+
+	replace(t, path, SIMPLE_WASM)
+	require.ErrorContains(t, <-reloads, "hash does not match", "new versions must match the reload hash")
+	replace(t, path, LEGACY_WASM)
+	require.NoError(t, <-reloads, "a new version matching the reload hash should be reloaded")
+
+	_, err = rt.Watch(WithPollInterval(0))
+	require.Error(t, err, "expected error for a poll interval of 0")
+	_, err = (&Runtime{}).Watch()
+	require.Error(t, err, "expected error for a runtime without a file")
+}
+
+func TestWatchVerifiesSignature(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plugin.wasm")
+	replace(t, path, ABI_WASM)
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	rt := newReloadable(t, path)
+	rt.file.publicKey = publicKey
+
+	_, err = rt.Watch(WithPollInterval(10 * time.Millisecond))
+	require.ErrorContains(t, err, "WithReloadFileOptions", "the signature only matches the running version")
+
+	next, err := os.ReadFile(LEGACY_WASM)
+	require.NoError(t, err)
+	reloads := make(chan error, 1)
+	w, err := rt.Watch(
+		WithPollInterval(10*time.Millisecond),
+		WithReloadFileOptions(WithSignature(publicKey, ed25519.Sign(privateKey, next))),
+		WithReloadFn(func(_ string, err error) {
+			reloads <- err
+		}),
+	)
+	require.NoError(t, err)
+	defer w.Close()
+
+	replace(t, path, SIMPLE_WASM)
+	require.ErrorContains(t, <-reloads, "signature does not match", "new versions must be signed")
+	replace(t, path, LEGACY_WASM)
+	require.NoError(t, <-reloads, "a signed new version should be reloaded")
+}
+
+func TestWatchStopsWhenClosed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plugin.wasm")
+	replace(t, path, ABI_WASM)
+	rt := newReloadable(t, path)
+	w, err := rt.Watch(WithPollInterval(10 * time.Millisecond))
+	require.NoError(t, err)
+
+	require.NoError(t, rt.Close(context.Background()))
+	select {
+	case <-w.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watcher kept running after the runtime was closed")
+	}
+	require.NoError(t, w.Close())
+}
+
+func TestReloadRecording(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	rt := newReloadable(t, ABI_WASM, WithRecorder(NewRecorder(&buf)))
+	_, err := rt.Invoke(ctx, "echo", []byte("a"))
+	require.NoError(t, err)
+	prev := rt.moduleHash
+
+	require.NoError(t, rt.Reload(ctx, mustFile(t, LEGACY_WASM), &SmokeTest{Operation: "noop", Payload: []byte("ok")}))
+	_, err = rt.Invoke(ctx, "echo", []byte("b"))
+	require.NoError(t, err)
+	require.NoError(t, rt.Close(ctx))
+
+	header, invocations, err := ReadRecording(&buf)
+	require.NoError(t, err)
+	require.Equal(t, prev, header.Module)
+	require.Len(t, invocations, 2, "the smoke test should not be recorded")
+	require.Equal(t, prev, invocations[0].Module)
+	require.Equal(t, rt.moduleHash, invocations[1].Module, "the reload should record the new module")
+	require.NotEqual(t, prev, rt.moduleHash)
+}
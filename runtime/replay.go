@@ -0,0 +1,238 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/mopeyjellyfish/hookr/runtime/invoke"
+	"github.com/mopeyjellyfish/hookr/runtime/module"
+)
+
+// ErrUnrecordedHostCall is returned to the plugin during a replay for host calls
+// which are not in the recording.
+var ErrUnrecordedHostCall = errors.New("host call not in recording")
+
+// Divergence is a difference between a replayed invocation and its recording.
+type Divergence struct {
+	// Invocation is the index of the invocation in the recording, -1 when the
+	// divergence happened while the plugin was created.
+	Invocation int
+	Operation  string
+	Reason     string
+	Expected   string
+	Actual     string
+}
+
+func (d Divergence) String() string {
+	return fmt.Sprintf("invocation %d (%s): %s: expected %q, got %q", d.Invocation, d.Operation, d.Reason, d.Expected, d.Actual)
+}
+
+// ReplayReport is the outcome of a replay.
+type ReplayReport struct {
+	Header      RecordingHeader
+	Invocations int
+	Divergences []Divergence
+}
+
+// Diverged reports whether the replay differed from the recording.
+func (r *ReplayReport) Diverged() bool {
+	return len(r.Divergences) > 0
+}
+
+// recordingLine is a line of a recording, a header when Version is set.
+type recordingLine struct {
+	RecordingHeader
+	RecordedInvocation
+}
+
+// ReadRecording reads a recording written by a Recorder. The header is the
+// first of the recording, the headers written by reloads set the Module of the
+// invocations following them.
+func ReadRecording(r io.Reader) (*RecordingHeader, []RecordedInvocation, error) {
+	dec := json.NewDecoder(r)
+	header := &RecordingHeader{}
+	if err := dec.Decode(header); err != nil {
+		return nil, nil, fmt.Errorf("failed to read recording header: %w", err)
+	}
+	if header.Version != RecordingVersion {
+		return nil, nil, fmt.Errorf("unsupported recording version %d", header.Version)
+	}
+
+	module := header.Module
+	var invocations []RecordedInvocation
+	for {
+		var line recordingLine
+		if err := dec.Decode(&line); errors.Is(err, io.EOF) {
+			return header, invocations, nil
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("failed to read invocation %d: %w", len(invocations), err)
+		}
+		if line.Version != 0 {
+			if line.Version != RecordingVersion {
+				return nil, nil, fmt.Errorf("unsupported recording version %d", line.Version)
+			}
+			module = line.RecordingHeader.Module
+			continue
+		}
+		line.RecordedInvocation.Module = module
+		invocations = append(invocations, line.RecordedInvocation)
+	}
+}
+
+// Replay creates the plugin with the options and re-runs the recorded
+// invocations against it. Host calls are answered from the recording instead
+// of calling the host functions, every difference to the recording is
+// reported as a Divergence. An error is returned when the recording cannot be
+// read or the plugin cannot be created.
+func Replay(ctx context.Context, recording io.Reader, opts ...Option) (*ReplayReport, error) {
+	header, entries, err := ReadRecording(recording)
+	if err != nil {
+		return nil, err
+	}
+
+	rp := &replayer{report: &ReplayReport{Header: *header}, index: -1}
+	i := 0
+	for ; i < len(entries) && entries[i].Init; i++ { // initialization when the plugin is created
+		rp.queueInit(entries[i])
+	}
+
+	rt, err := New(ctx, append(slices.Clone(opts), withReplay(rp.handle))...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create plugin: %w", err)
+	}
+	defer func() {
+		_ = rt.Close(ctx)
+	}()
+	module := header.Module
+	if rt.moduleHash != module {
+		rp.diverged("module", module, rt.moduleHash)
+	}
+
+	for ; i < len(entries); i++ {
+		if entries[i].Init { // initialization when the plugin was restarted
+			rp.queueInit(entries[i])
+			continue
+		}
+		rp.start(&entries[i])
+		if entries[i].Module != module { // recorded after a reload
+			if module = entries[i].Module; rt.moduleHash != module {
+				rp.diverged("module", module, rt.moduleHash)
+			}
+		}
+		out, err := replayInvocation(ctx, rt, &entries[i])
+		rp.finish(out, err)
+	}
+	return rp.report, nil
+}
+
+// withReplay answers the host calls of the plugin with handler, ahead of the
+// configuration and the linker which answered them when recorded.
+func withReplay(handler module.CallHandler) Option {
+	return func(e *Runtime) error {
+		e.replay = handler
+		return nil
+	}
+}
+
+// replayInvocation calls the plugin the way the invocation was recorded.
+func replayInvocation(ctx context.Context, rt *Runtime, inv *RecordedInvocation) ([]byte, error) {
+	if !inv.Batch {
+		return rt.Invoke(ctx, inv.Operation, inv.Payload)
+	}
+	var out []byte
+	err := rt.withBatch(ctx, inv.Operation, inv.Payload, func(resp []byte) error {
+		out = bytes.Clone(resp)
+		return nil
+	})
+	return out, err
+}
+
+// replayer answers host calls from a recording. Invocations are replayed one
+// after another, so it needs no locking.
+type replayer struct {
+	report *ReplayReport
+
+	index     int // index of the current invocation
+	current   *RecordedInvocation
+	next      int // next host call of the current invocation
+	initCalls []RecordedHostCall
+}
+
+func (rp *replayer) queueInit(inv RecordedInvocation) {
+	rp.initCalls = append(rp.initCalls, inv.HostCalls...)
+}
+
+func (rp *replayer) start(inv *RecordedInvocation) {
+	rp.index++
+	rp.report.Invocations++
+	rp.current = inv
+	rp.next = 0
+}
+
+func (rp *replayer) finish(out []byte, err error) {
+	inv := rp.current
+	if rp.next < len(inv.HostCalls) {
+		rp.diverged("missing host call", inv.HostCalls[rp.next].Operation, "")
+	}
+	if !bytes.Equal(inv.Output, out) {
+		rp.diverged("output", string(inv.Output), string(out))
+	}
+	if got := errString(err); inv.Error != got {
+		rp.diverged("error", inv.Error, got)
+	}
+}
+
+func (rp *replayer) diverged(reason, expected, actual string) {
+	d := Divergence{Invocation: rp.index, Reason: reason, Expected: expected, Actual: actual}
+	if rp.current != nil {
+		d.Operation = rp.current.Operation
+	}
+	rp.report.Divergences = append(rp.report.Divergences, d)
+}
+
+// handle answers a host call of the plugin with the next recorded host call.
+func (rp *replayer) handle(ctx context.Context, operation string, payload []byte) ([]byte, error) {
+	var call RecordedHostCall
+	if ic := invoke.From(ctx); ic != nil && isInitStage(ic.Operation) {
+		if len(rp.initCalls) == 0 {
+			rp.diverged("unexpected host call during "+ic.Operation, "", operation)
+			return nil, ErrUnrecordedHostCall
+		}
+		call, rp.initCalls = rp.initCalls[0], rp.initCalls[1:]
+	} else {
+		if rp.current == nil || rp.next >= len(rp.current.HostCalls) {
+			rp.diverged("unexpected host call", "", operation)
+			return nil, ErrUnrecordedHostCall
+		}
+		call = rp.current.HostCalls[rp.next]
+		rp.next++
+	}
+
+	if call.Operation != operation {
+		rp.diverged("host call operation", call.Operation, operation)
+	} else if !bytes.Equal(call.Payload, payload) {
+		rp.diverged("host call "+operation+" payload", string(call.Payload), string(payload))
+	}
+	if call.Error != "" {
+		return nil, replayedError(call)
+	}
+	return call.Response, nil
+}
+
+// replayedError returns the error of the host call, carrying its code when the
+// plugin saw one so it can tell the error apart as it did when recorded.
+func replayedError(call RecordedHostCall) error {
+	if call.Code == module.CodeNone {
+		return errors.New(call.Error)
+	}
+	return &codedError{message: call.Error, code: call.Code}
+}
+
+func isInitStage(operation string) bool {
+	return operation == fnStart || operation == fnInitialize || operation == fnHookrInit
+}
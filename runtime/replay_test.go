@@ -0,0 +1,137 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mopeyjellyfish/hookr/runtime/module"
+	"github.com/mopeyjellyfish/hookr/testdata/api"
+	"github.com/stretchr/testify/require"
+)
+
+// record invokes the simple plugin and returns the recording.
+func record(t *testing.T) []byte {
+	t.Helper()
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	recorder := NewRecorder(&buf)
+	plugin, err := New(ctx,
+		WithFile(SIMPLE_WASM),
+		WithHostFns(HostFnSerial("hello", Hello)),
+		WithConfig(map[string]string{"tenant": "acme"}),
+		WithRecorder(recorder),
+	)
+	require.NoError(t, err, "failed to create module")
+
+	echo, err := PluginFnSerial[*api.EchoRequest, *api.EchoResponse](plugin, "echo")
+	require.NoError(t, err)
+	resp, err := echo.Call(ctx, &api.EchoRequest{Data: "hookr"})
+	require.NoError(t, err, "failed to call plugin")
+	require.Equal(t, "Hello hookr", resp.Data)
+	_, err = plugin.Invoke(ctx, "vowel", []byte("recording"))
+	require.NoError(t, err, "failed to invoke plugin")
+	_, err = plugin.Invoke(ctx, "nope", []byte{0x80})
+	require.Error(t, err)
+
+	require.NoError(t, plugin.Close(ctx), "failed to close module")
+	require.NoError(t, recorder.Err())
+	return buf.Bytes()
+}
+
+func TestRecord(t *testing.T) {
+	header, invocations, err := ReadRecording(bytes.NewReader(record(t)))
+	require.NoError(t, err, "failed to read recording")
+	require.Equal(t, RecordingVersion, header.Version)
+	require.Equal(t, map[string]string{"tenant": "acme"}, header.Config)
+	require.NotEmpty(t, header.Module)
+
+	require.Len(t, invocations, 3)
+	require.Equal(t, "echo", invocations[0].Operation)
+	require.Len(t, invocations[0].HostCalls, 1, "the host call should be recorded")
+	require.Equal(t, "hello", invocations[0].HostCalls[0].Operation)
+	require.Equal(t, "vowel", invocations[1].Operation)
+	require.Equal(t, "3", string(invocations[1].Output))
+	require.Empty(t, invocations[1].HostCalls)
+	require.Equal(t, "planned Failure", invocations[2].Error)
+}
+
+func TestReplay(t *testing.T) {
+	ctx := context.Background()
+	recording := record(t)
+
+	// no host functions are registered, host calls are answered from the recording.
+	report, err := Replay(ctx, bytes.NewReader(recording), WithFile(SIMPLE_WASM))
+	require.NoError(t, err, "failed to replay")
+	require.Equal(t, 3, report.Invocations)
+	require.False(t, report.Diverged(), "unexpected divergences: %v", report.Divergences)
+
+	// the host answered differently when the plugin was recorded.
+	tampered := bytes.Replace(recording, []byte(`"vowel"`), []byte(`"echoByte"`), 1)
+	report, err = Replay(ctx, bytes.NewReader(tampered), WithFile(SIMPLE_WASM))
+	require.NoError(t, err, "failed to replay")
+	require.True(t, report.Diverged())
+	require.Equal(t, 1, report.Divergences[0].Invocation)
+	require.Equal(t, "unexpected host call", report.Divergences[0].Reason)
+	require.Equal(t, "helloByte", report.Divergences[0].Actual)
+
+	// replaying against another plugin.
+	report, err = Replay(ctx, bytes.NewReader(recording), WithFile(STREAM_WASM))
+	require.NoError(t, err, "failed to replay")
+	require.Equal(t, -1, report.Divergences[0].Invocation)
+	require.Equal(t, "module", report.Divergences[0].Reason)
+
+	_, err = Replay(ctx, strings.NewReader(`{"version":99}`), WithFile(SIMPLE_WASM))
+	require.Error(t, err, "expected error for an unsupported version")
+}
+
+func TestReplayErrorCode(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	plugin, err := New(ctx,
+		WithFile(SIMPLE_WASM),
+		WithHostFns(HostFnSerial("hello", Hello)),
+		WithAllowedHostFns(), // the host call of echo is not granted
+		WithRecorder(NewRecorder(&buf)),
+	)
+	require.NoError(t, err, "failed to create module")
+	echo, err := PluginFnSerial[*api.EchoRequest, *api.EchoResponse](plugin, "echo")
+	require.NoError(t, err)
+	_, err = echo.Call(ctx, &api.EchoRequest{Data: "hookr"})
+	require.Error(t, err)
+	require.NoError(t, plugin.Close(ctx), "failed to close module")
+
+	_, invocations, err := ReadRecording(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err, "failed to read recording")
+	require.Len(t, invocations[0].HostCalls, 1)
+	call := invocations[0].HostCalls[0]
+	require.Equal(t, module.CodeNotGranted, call.Code, "the code should be recorded")
+
+	report, err := Replay(ctx, bytes.NewReader(buf.Bytes()), WithFile(SIMPLE_WASM))
+	require.NoError(t, err, "failed to replay")
+	require.False(t, report.Diverged(), "unexpected divergences: %v", report.Divergences)
+
+	replayed := replayedError(call)
+	require.EqualError(t, replayed, call.Error)
+	require.Equal(t, module.CodeNotGranted, module.ErrorCode(replayed), "the code should be replayed")
+	require.Equal(t, module.CodeNone, module.ErrorCode(replayedError(RecordedHostCall{Error: "plain"})))
+}
+
+func TestReplayConfig(t *testing.T) {
+	ctx := context.Background()
+	rp := &replayer{report: &ReplayReport{}, index: -1}
+	rt, err := New(ctx, WithFile(SIMPLE_WASM), WithConfig(map[string]string{"tenant": "other"}), withReplay(rp.handle))
+	require.NoError(t, err, "failed to create module")
+	defer rt.Close(ctx)
+
+	rp.start(&RecordedInvocation{Operation: "echo", HostCalls: []RecordedHostCall{
+		{Operation: ConfigFn, Payload: []byte("tenant"), Response: []byte("acme")},
+	}})
+	value, err := rt.handle(ctx, ConfigFn, []byte("tenant"))
+	require.NoError(t, err)
+	require.Equal(t, "acme", string(value), "config reads should be answered from the recording")
+	rp.finish(nil, nil)
+	require.False(t, rp.report.Diverged(), "unexpected divergences: %v", rp.report.Divergences)
+}
@@ -0,0 +1,108 @@
+package runtime
+
+import (
+	"context"
+	"sync"
+)
+
+// Response is the output of a plugin borrowed from its memory, see
+// InvokeBorrowed. The plugin cannot be called until the response is released.
+// The memory is owned by the plugin, which keeps its response alive until it
+// is called again, so it is not freed with hookr_free.
+type Response struct {
+	mu      sync.Mutex
+	data    []byte
+	err     error
+	once    sync.Once
+	release func()
+}
+
+// Bytes returns the response, a view into the plugin's memory which must not
+// be used after Release. It returns nil once the response is released.
+func (r *Response) Bytes() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.data
+}
+
+// Err returns ErrClosed when the Runtime was closed before the response was
+// released, closing releases it.
+func (r *Response) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Release gives the plugin back to the Runtime, after which Bytes returns nil
+// and the plugin may reuse the memory on its next call. It is safe to call
+// Release more than once.
+func (r *Response) Release() {
+	r.free(nil)
+}
+
+// free releases the response the first time it is called, err is returned by
+// Err afterwards.
+func (r *Response) free(err error) {
+	r.once.Do(func() {
+		r.mu.Lock()
+		r.data, r.err = nil, err
+		release := r.release
+		r.mu.Unlock()
+		release()
+	})
+}
+
+// InvokeBorrowed calls the plugin function like Invoke without copying the
+// response out of guest memory. The Runtime stays busy until the response is
+// released, so it must be released as soon as it has been read:
+//
+//	resp, err := rt.InvokeBorrowed(ctx, "render", payload)
+//	if err != nil {
+//		return err
+//	}
+//	defer resp.Release()
+//	_, err = w.Write(resp.Bytes())
+//
+// Closing the Runtime releases the response, see Response.Err.
+func (e *Runtime) InvokeBorrowed(ctx context.Context, operation string, payload []byte) (*Response, error) {
+	var resp *Response
+	err := guard(e.breaker(operation), func() error {
+		out, release, err := e.borrow(ctx, operation, payload)
+		if err != nil {
+			return err
+		}
+		resp = &Response{data: out}
+		resp.mu.Lock() // an abort frees the response once its release is set
+		defer resp.mu.Unlock()
+		stop := e.inflight.onAbort(func() {
+			resp.free(ErrClosed)
+		})
+		resp.release = func() {
+			stop()
+			release()
+		}
+		return nil
+	})
+	return resp, err
+}
+
+// borrow calls the plugin, holding e.mu until release is called. The call is
+// in-flight until then.
+func (e *Runtime) borrow(ctx context.Context, operation string, payload []byte) (out []byte, release func(), err error) {
+	ctx, done, err := e.inflight.enter(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	e.mu.Lock()
+	out, err = e.invokeLocked(ctx, operation, payload)
+	if err != nil {
+		e.mu.Unlock()
+		done()
+		return nil, nil, err
+	}
+	return out, func() {
+		e.mu.Unlock()
+		done()
+	}, nil
+}
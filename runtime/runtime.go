@@ -1,16 +1,21 @@
 package runtime
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/mopeyjellyfish/hookr/runtime/invoke"
 	"github.com/mopeyjellyfish/hookr/runtime/logger"
 	"github.com/mopeyjellyfish/hookr/runtime/module"
+	"github.com/mopeyjellyfish/hookr/runtime/snapshot"
 	"github.com/tetratelabs/wazero"
 	"github.com/tetratelabs/wazero/api"
 	"github.com/tetratelabs/wazero/imports/assemblyscript"
@@ -22,6 +27,12 @@ import (
 // on hookr.Runtime is called. The result is closed upon wapc.Module Close.
 type NewRuntime func(context.Context) (wazero.Runtime, error)
 
+// fnAlloc and fnFree are the allocator exported by guests supporting fnPluginInvoke.
+const (
+	fnAlloc = module.FnAlloc
+	fnFree  = module.FnFree
+)
+
 // functionStart is the name of the nullary function a module exports if it is a WASI Command Module.
 //
 // See https://github.com/WebAssembly/WASI/blob/snapshot-01/design/application-abi.md#current-unstable-abi
@@ -38,6 +49,13 @@ const fnHookrInit = "hookr_init"
 //	(func $__plugin_call (param $operation_len i32) (param $payload_len i32) (result (;errno;) i32))
 const fnPluginCall = "__plugin_call"
 
+// fnPluginInvoke is the callback of guests exporting module.FnAlloc, the host writes the operation followed by the
+// payload into a buffer it allocated with it, which stays owned by the host and is only valid during the call. The
+// response is returned packed with module.Pack:
+//
+//	(func $__plugin_invoke (param $ptr i32) (param $operation_len i32) (param $payload_len i32) (result i64))
+const fnPluginInvoke = "__plugin_invoke"
+
 type Runtime struct {
 	newRuntime  NewRuntime
 	ctx         context.Context
@@ -46,16 +64,59 @@ type Runtime struct {
 	stderr      io.Writer
 	stdout      io.Writer
 	rand        io.Reader
+	clock       Clock
 	callHandler module.CallHandler
+	replay      module.CallHandler // answers the host calls from a recording, see Replay
 
 	hostFns    CallFns
 	pluginCall api.Function
-	moduleName string
-	r          wazero.Runtime
-	config     wazero.ModuleConfig
-	hookr      api.Module
-	plugin     api.Module
-	compiled   wazero.CompiledModule
+	// pluginInvoke is set when the guest exports module.FnAlloc and fnPluginInvoke, it is used instead of pluginCall.
+	pluginInvoke api.Function
+	alloc        api.Function
+	free         api.Function
+	lease        uint32 // guest buffer reused for the requests of fnPluginInvoke
+	leaseCap     uint32
+	moduleName   string
+	r            wazero.Runtime
+	config       wazero.ModuleConfig
+	hookr        api.Module
+	plugin       api.Module
+	compiled     wazero.CompiledModule
+
+	// mu serializes calls into the plugin, a module instance is not safe for concurrent use.
+	mu sync.Mutex
+
+	state           State
+	stateMu         sync.Mutex
+	onStateChange   StateChangeFn
+	shutdownTimeout time.Duration
+	initTimeout     time.Duration
+	restarts        atomic.Uint64
+	breakerCfg      *BreakerConfig
+	breakersMu      sync.Mutex
+	breakers        map[string]*Breaker
+	isolated        bool
+	used            bool // whether a call used the current instance
+	snapshotting    bool
+	snapshotGlobals []uint32
+	snapshot        *snapshot.Snapshot
+	moduleHash      string
+	recorder        *Recorder
+	linker          *Linker
+	linkName        string
+	rateLimit       *tokenBucket
+	hostFnLimits    map[string]*tokenBucket
+	quota           Quota
+	limits          Limits
+	callTimeout     time.Duration
+	allowedHostFns  map[string]struct{}
+	pluginConfig    map[string]string
+	cache           wazero.CompilationCache
+	memoryLimit     uint32 // in pages
+	interrupt       bool
+	inflight        inflight
+	closed          sync.Once
+	closeErr        error
 }
 
 // Will initialize the wazero runtime
@@ -77,7 +138,11 @@ func (e *Runtime) InitHookr() error {
 	if e.r == nil {
 		return errors.New("runtime not initialized")
 	}
-	hookr, err := module.New(e.ctx, e.r, e.fnHandler, e.logger)
+	opts := []module.Option{module.WithLimits(e.limits)}
+	if e.limited() {
+		opts = append(opts, module.WithLimiter(e.limit))
+	}
+	hookr, err := module.New(e.ctx, e.r, e.fnHandler, e.logger, opts...)
 	if err != nil {
 		return err
 	}
@@ -86,6 +151,25 @@ func (e *Runtime) InitHookr() error {
 }
 
 func (e *Runtime) fnHandler(ctx context.Context, operation string, payload []byte) ([]byte, error) {
+	resp, err := e.handle(ctx, operation, payload)
+	recordHostCall(ctx, operation, payload, resp, err)
+	return resp, err
+}
+
+// handle calls the host function of the operation.
+func (e *Runtime) handle(ctx context.Context, operation string, payload []byte) ([]byte, error) {
+	if err := e.granted(operation); err != nil {
+		return nil, err
+	}
+	if e.replay != nil { // every host call was recorded, including config reads and linked calls
+		return e.replay(ctx, operation, payload)
+	}
+	if operation == PluginCallFn && e.linker != nil {
+		return e.linker.route(ctx, e.linkName, payload)
+	}
+	if operation == ConfigFn && e.pluginConfig != nil {
+		return e.configValue(ctx, payload)
+	}
 	if e.callHandler != nil {
 		return e.callHandler(ctx, operation, payload)
 	}
@@ -104,15 +188,30 @@ func (e *Runtime) RegisterFunction(name string, fn CallFn) {
 }
 
 // InitConfig initializes the wazero module config with the default settings.
+// When a Clock is configured the plugin's clocks and sleeps use it instead of the system clock.
 func (e *Runtime) InitConfig() {
 	cfg := wazero.NewModuleConfig().
 		WithStartFunctions().
 		WithStderr(e.stderr).
 		WithStdout(e.stdout).
-		WithRandSource(e.rand).
-		WithSysNanosleep().
-		WithSysNanotime().
-		WithSysWalltime()
+		WithRandSource(e.rand)
+	if e.clock != nil {
+		clock := e.clock
+		cfg = cfg.
+			WithWalltime(func() (int64, int32) {
+				now := clock.Now()
+				return now.Unix(), int32(now.Nanosecond())
+			}, sys.ClockResolution(time.Microsecond.Nanoseconds())).
+			WithNanotime(clock.Nanotime, sys.ClockResolution(1)).
+			WithNanosleep(func(ns int64) {
+				clock.Sleep(time.Duration(ns))
+			})
+	} else {
+		cfg = cfg.
+			WithSysNanosleep().
+			WithSysNanotime().
+			WithSysWalltime()
+	}
 	e.config = cfg
 }
 
@@ -133,8 +232,11 @@ func (e *Runtime) Init() error {
 }
 
 // MemorySize returns the size of the memory for this instance.
-// This is the size in bytes, not the number of pages.
+// This is the size in bytes, not the number of pages. It waits for a running
+// call, the plugin's memory may grow during one.
 func (e *Runtime) MemorySize() uint32 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	if e.plugin == nil {
 		return 0
 	}
@@ -156,6 +258,15 @@ func (e *Runtime) Compile() error {
 	if err != nil {
 		return fmt.Errorf("failed to get data from file: %w", err)
 	}
+	if e.moduleHash, err = (Sha256Hasher{}).Hash(d); err != nil {
+		return fmt.Errorf("failed to hash module: %w", err)
+	}
+	e.recordHeader()
+	if e.snapshotting {
+		if d, e.snapshotGlobals, err = snapshot.Instrument(d); err != nil {
+			return fmt.Errorf("failed to prepare module for snapshots: %w", err)
+		}
+	}
 	compiled, err := e.r.CompileModule(e.ctx, d)
 	if err != nil {
 		return fmt.Errorf("failed to compile module: %w", err)
@@ -164,8 +275,24 @@ func (e *Runtime) Compile() error {
 	return nil
 }
 
+// InitError is returned when the plugin fails to initialize. Stage is the name
+// of the initialization function which failed.
+type InitError struct {
+	Stage string
+	Err   error
+}
+
+func (e *InitError) Error() string {
+	return fmt.Sprintf("plugin initialization failed in %s: %v", e.Stage, e.Err)
+}
+
+func (e *InitError) Unwrap() error {
+	return e.Err
+}
+
 // Instantiate instantiates the compiled module. It must be called after the
 // module is compiled. It will also call the WASI and hookr start functions if
+// they are exported, any failure of those functions is returned as an *InitError.
 func (e *Runtime) Instantiate() error {
 	if e.r == nil {
 		return errors.New("runtime not initialized")
@@ -175,46 +302,148 @@ func (e *Runtime) Instantiate() error {
 		return fmt.Errorf("failed to instantiate module: %w", err)
 	}
 
-	// Call any WASI or hookr start functions on instantiate.
-	funcs := []string{fnStart, fnInitialize, fnHookrInit}
-	for _, f := range funcs {
-		exportedFunc := module.ExportedFunction(f)
-		if exportedFunc != nil {
-			ic := invoke.Context{Operation: f, PluginReq: nil}
-			ictx := invoke.New(e.ctx, &ic)
-			if _, err := exportedFunc.Call(ictx); err != nil {
-				if exitErr, ok := err.(*sys.ExitError); ok {
-					return fmt.Errorf("error calling %s: %w", f, exitErr)
-				}
-			}
-		}
+	if err := e.initOrRestore(module); err != nil {
+		_ = module.Close(e.ctx)
+		return err
 	}
 
 	e.plugin = module
 
-	if e.pluginCall = module.ExportedFunction(fnPluginCall); e.pluginCall == nil {
+	e.pluginCall = module.ExportedFunction(fnPluginCall)
+	e.pluginInvoke, e.free, e.lease, e.leaseCap = nil, nil, 0, 0
+	if e.alloc = module.ExportedFunction(fnAlloc); e.alloc != nil {
+		e.pluginInvoke = module.ExportedFunction(fnPluginInvoke)
+		e.free = module.ExportedFunction(fnFree)
+	}
+	if e.pluginCall == nil && e.pluginInvoke == nil {
 		_ = e.plugin.Close(e.ctx)
+		e.plugin = nil
 		return fmt.Errorf("module %s didn't export function %s", e.moduleName, fnPluginCall)
 	}
 
 	return nil
 }
 
+// initialize calls any WASI or hookr start functions exported by the module,
+// all of them together must finish within the init timeout when one is set.
+func (e *Runtime) initialize(module api.Module) error {
+	ctx := e.ctx
+	if e.initTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.initTimeout)
+		defer cancel()
+	}
+
+	funcs := []string{fnStart, fnInitialize, fnHookrInit}
+	for _, f := range funcs {
+		exportedFunc := module.ExportedFunction(f)
+		if exportedFunc == nil {
+			continue
+		}
+		if _, err := e.record(ctx, RecordedInvocation{Init: true, Operation: f}, func(ctx context.Context) ([]byte, error) {
+			return nil, initStage(ctx, exportedFunc, f)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// initStage calls the initialization function of the stage.
+func initStage(ctx context.Context, fn api.Function, stage string) error {
+	ic := invoke.Context{Operation: stage, PluginReq: nil}
+	if _, err := fn.Call(invoke.New(ctx, &ic)); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = fmt.Errorf("%w: %w", ctxErr, err)
+		}
+		return &InitError{Stage: stage, Err: err}
+	}
+	if ic.Err != nil {
+		return &InitError{Stage: stage, Err: ic.Err}
+	}
+	if ic.PluginErr != "" { // the plugin reported the failure with pdk.InitError
+		return &InitError{Stage: stage, Err: errors.New(ic.PluginErr)}
+	}
+	return nil
+}
+
 // Invoke calls the plugin function with the given operation and payload.
+// The response is copied out of guest memory and owned by the caller, see
+// InvokeBorrowed to avoid the copy. When circuit breakers are configured with WithCircuitBreaker, ErrCircuitOpen
+// is returned without calling the plugin while the operation's circuit is open.
 func (e *Runtime) Invoke(ctx context.Context, operation string, payload []byte) ([]byte, error) {
-	if e.plugin == nil {
-		return nil, errors.New("plugin not initialized")
+	return e.invokeWith(ctx, e.breaker(operation), operation, payload)
+}
+
+// invokeWith calls the plugin function guarded by the circuit breaker b, if any.
+func (e *Runtime) invokeWith(ctx context.Context, b *Breaker, operation string, payload []byte) ([]byte, error) {
+	var out []byte
+	err := guard(b, func() error {
+		var err error
+		out, err = e.invoke(ctx, operation, payload)
+		return err
+	})
+	return out, err
+}
+
+// guard calls fn through the circuit breaker b, if any.
+func guard(b *Breaker, fn func() error) error {
+	if b == nil {
+		return fn()
+	}
+	return b.Do(fn)
+}
+
+// invoke calls the plugin and copies the response out of guest memory.
+func (e *Runtime) invoke(ctx context.Context, operation string, payload []byte) ([]byte, error) {
+	out, release, err := e.borrow(ctx, operation, payload)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return bytes.Clone(out), nil
+}
+
+// invokeLocked calls the plugin, the response is a view into guest memory.
+// The caller must hold e.mu.
+func (e *Runtime) invokeLocked(ctx context.Context, operation string, payload []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil { // cancelled while waiting for the plugin
+		return nil, err
+	}
+	if err := e.instance(); err != nil {
+		return nil, err
 	}
 
+	return e.record(ctx, RecordedInvocation{Operation: operation, Payload: payload}, func(ctx context.Context) ([]byte, error) {
+		return e.call(ctx, operation, payload)
+	})
+}
+
+// call performs the plugin call of an invocation, the response is a view into
+// guest memory valid until the plugin is called again.
+// The caller must hold e.mu.
+func (e *Runtime) call(ctx context.Context, operation string, payload []byte) ([]byte, error) {
+	if err := e.limits.Check(LimitPluginRequest, uint64(len(payload))); err != nil {
+		return nil, err
+	}
+	ctx, cancel := e.withCallTimeout(ctx)
+	defer cancel()
 	ic := invoke.Context{Operation: operation, PluginReq: payload}
 	ctx = invoke.New(ctx, &ic)
 
+	if e.pluginInvoke != nil {
+		return e.callInvoke(ctx, &ic, operation, payload)
+	}
+
 	results, err := e.pluginCall.Call(ctx, uint64(len(operation)), uint64(len(payload)))
 	if err != nil {
-		return nil, fmt.Errorf("error while making %s call: %w", operation, err)
+		return nil, e.callError(operation, err)
+	}
+	if ic.Err != nil {
+		return nil, ic.Err
 	}
 	if ic.PluginErr != "" { // guestErr is not nil if the guest called "__plugin_error".
-		return nil, errors.New(ic.PluginErr)
+		return nil, pluginError(&ic)
 	}
 
 	result := results[0]
@@ -227,7 +456,96 @@ func (e *Runtime) Invoke(ctx context.Context, operation string, payload []byte)
 	return nil, fmt.Errorf("call to %q was unsuccessful", operation)
 }
 
-func (e *Runtime) Close(ctx context.Context) error {
+// InvokeStream calls the streaming plugin function with the given operation,
+// the plugin reads its input from r and writes its output in chunks so neither
+// has to fit in guest memory at once.
+//
+// The plugin runs while the returned reader is consumed, the Runtime is busy
+// until the reader has been drained or closed. Errors from the plugin are
+// returned when reading.
+func (e *Runtime) InvokeStream(ctx context.Context, operation string, r io.Reader) (io.ReadCloser, error) {
+	if r == nil {
+		return nil, errors.New("reader cannot be nil")
+	}
+
+	ctx, done, err := e.inflight.enter(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	if e.plugin == nil {
+		e.mu.Unlock()
+		done()
+		return nil, errNotInitialized
+	}
+	pr, pw := io.Pipe()
+	// a plugin blocked writing to an unread stream is released when closed
+	stop := e.inflight.onAbort(func() {
+		pw.CloseWithError(ErrClosed)
+	})
+	go func() {
+		defer done()
+		defer e.mu.Unlock()
+		defer stop()
+		pw.CloseWithError(e.stream(ctx, operation, r, pw))
+	}()
+	return pr, nil
+}
+
+// stream performs the plugin call of a streaming invocation. It is not
+// recorded, recording would have to buffer the whole stream.
+func (e *Runtime) stream(ctx context.Context, operation string, r io.Reader, w io.Writer) error {
+	if err := e.instance(); err != nil {
+		return err
+	}
+	if e.pluginCall == nil {
+		return fmt.Errorf("module %s didn't export function %s for streaming", e.moduleName, fnPluginCall)
+	}
+
+	ctx, cancel := e.withCallTimeout(ctx)
+	defer cancel()
+	ic := invoke.Context{Operation: operation, StreamIn: r, StreamOut: w}
+	ctx = invoke.New(ctx, &ic)
+
+	results, err := e.pluginCall.Call(ctx, uint64(len(operation)), 0)
+	if err != nil {
+		return e.callError(operation, err)
+	}
+	if ic.Err != nil {
+		return ic.Err
+	}
+	if ic.PluginErr != "" {
+		return pluginError(&ic)
+	}
+	if results[0] != 1 {
+		return fmt.Errorf("call to %q was unsuccessful", operation)
+	}
+	return nil
+}
+
+// withCallTimeout bounds a plugin call by the call timeout, if any.
+func (e *Runtime) withCallTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if e.callTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, e.callTimeout)
+}
+
+// close calls the plugin's shutdown function when the calls drained, giving it
+// until the shutdown timeout to flush any state, and then closes the plugin and
+// the wazero runtime. An error from the shutdown function is returned once
+// everything is closed.
+func (e *Runtime) close(ctx context.Context, drained bool) error {
+	if e.linker != nil {
+		e.linker.unregister(e.linkName, e)
+	}
+	var shutdownErr error
+	if drained {
+		shutdownErr = e.shutdown(ctx)
+	}
+	e.setState(StateClosed)
+
 	if e.plugin != nil {
 		if err := e.plugin.Close(ctx); err != nil {
 			return fmt.Errorf("error closing plugin: %w", err)
@@ -246,13 +564,31 @@ func (e *Runtime) Close(ctx context.Context) error {
 		}
 	}
 
-	return nil
+	return shutdownErr
 }
 
 // DefaultRuntime implements NewRuntime by returning a wazero runtime with WASI
 // and AssemblyScript host functions instantiated.
 func DefaultRuntime(ctx context.Context) (wazero.Runtime, error) {
-	r := wazero.NewRuntime(ctx)
+	return newDefaultRuntime(ctx, wazero.NewRuntimeConfig())
+}
+
+// configuredRuntime implements NewRuntime like DefaultRuntime, applying the
+// compilation cache, the memory limit and the interruption of the runtime.
+func (e *Runtime) configuredRuntime(ctx context.Context) (wazero.Runtime, error) {
+	config := wazero.NewRuntimeConfig().WithCloseOnContextDone(e.interrupt)
+	if e.cache != nil {
+		config = config.WithCompilationCache(e.cache)
+	}
+	if e.memoryLimit > 0 {
+		config = config.WithMemoryLimitPages(e.memoryLimit)
+	}
+	return newDefaultRuntime(ctx, config)
+}
+
+// newDefaultRuntime implements DefaultRuntime with the config.
+func newDefaultRuntime(ctx context.Context, config wazero.RuntimeConfig) (wazero.Runtime, error) {
+	r := wazero.NewRuntimeWithConfig(ctx, config)
 
 	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
 		_ = r.Close(ctx)
@@ -277,6 +613,8 @@ func New(ctx context.Context, opts ...Option) (*Runtime, error) {
 		stdout:     os.Stdout,
 		rand:       rand.Reader,
 		logger:     logger.Default,
+
+		shutdownTimeout: DefaultShutdownTimeout,
 	}
 
 	for _, opt := range opts {
@@ -286,16 +624,27 @@ func New(ctx context.Context, opts ...Option) (*Runtime, error) {
 	}
 
 	if err := e.Init(); err != nil {
+		_ = e.Close(ctx)
 		return nil, err
 	}
 
 	if err := e.Compile(); err != nil {
+		_ = e.Close(ctx)
 		return nil, err
 	}
 
 	if err := e.Instantiate(); err != nil {
+		_ = e.Close(ctx)
 		return nil, err
 	}
+	e.setState(StateReady)
+
+	if e.linker != nil {
+		if err := e.linker.register(e.linkName, e); err != nil {
+			_ = e.Close(ctx)
+			return nil, err
+		}
+	}
 
 	return e, nil
 }
@@ -1,22 +1,27 @@
 package runtime
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"errors"
+	"io"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/mopeyjellyfish/hookr/runtime/logger"
 	"github.com/mopeyjellyfish/hookr/testdata/api"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/tetratelabs/wazero"
 )
 
 const (
 	SIMPLE_WASM  = "../testdata/simple/bin/simple.wasm"
 	INVALID_WASM = "../testdata/invalid/invalidformat.wasm"
 	EMPTY_WASM   = "../testdata/empty/bin/empty.wasm"
+	STREAM_WASM  = "../testdata/stream/bin/stream.wasm"
 )
 
 func Hello(ctx context.Context, input *api.HelloRequest) (*api.HelloResponse, error) {
@@ -213,6 +218,9 @@ func TestUninitializedHookr(t *testing.T) {
 	_, err = e.Invoke(context.Background(), "echo", nil)
 	require.Error(t, err, "expected error when invoking on uninitialized engine")
 
+	_, err = e.InvokeStream(context.Background(), "upper", strings.NewReader("data"))
+	require.Error(t, err, "expected error when streaming on uninitialized engine")
+
 	err = e.Init()
 	require.Error(t, err, "expected error when initializing uninitialized engine")
 
@@ -333,6 +341,20 @@ func TestHookrOpts(t *testing.T) {
 	require.NotNil(t, plugin, "plugin should not be nil")
 }
 
+func TestHookrCompilationCache(t *testing.T) {
+	ctx := context.Background()
+	cache := wazero.NewCompilationCache()
+	defer cache.Close(ctx)
+
+	for range 2 {
+		plugin, err := New(ctx, WithFile(SIMPLE_WASM), WithCompilationCache(cache))
+		require.NoError(t, err, "failed to create module")
+		require.NoError(t, plugin.Close(ctx))
+	}
+	_, err := New(ctx, WithFile(SIMPLE_WASM), WithCompilationCache(nil))
+	require.Error(t, err, "expected error for a nil cache")
+}
+
 func TestHookrBadHash(t *testing.T) {
 	ctx := context.Background()
 	plugin, err := New(ctx, WithFile(SIMPLE_WASM, WithHash("123")))
@@ -397,3 +419,132 @@ func TestPluginFnCalls(t *testing.T) {
 	require.Error(t, err, "expected error when calling plugin function with nil input")
 	require.Nil(t, resp, "expected nil response when calling plugin function with nil input")
 }
+
+// callEcho calls echo with the generic types of a caller which cannot name them.
+func callEcho[In Marshaler, Out Unmarshaler](ctx context.Context, rt *Runtime, input In, newOut func() Out) (Out, error) {
+	fn, err := PluginFnSerialWith[In](rt, "echo", newOut)
+	if err != nil {
+		var zero Out
+		return zero, err
+	}
+	return fn.Call(ctx, input)
+}
+
+func TestPluginFnWith(t *testing.T) {
+	ctx := context.Background()
+	p, err := New(ctx, WithFile(SIMPLE_WASM), WithHostFns(HostFnSerial("hello", Hello)))
+	require.NoError(t, err, "failed to create module")
+	defer func() {
+		require.NoError(t, p.Close(ctx), "failed to close module")
+	}()
+
+	resp, err := callEcho(ctx, p, &api.EchoRequest{Data: "hookr"}, func() *api.EchoResponse { return &api.EchoResponse{} })
+	require.NoError(t, err, "failed to call plugin function")
+	require.Equal(t, "Hello hookr", resp.Data)
+
+	_, err = PluginFnSerialWith[*api.EchoRequest, *api.EchoResponse](p, "echo", nil)
+	require.Error(t, err, "expected error when creating plugin function without newOut")
+}
+
+func TestHookrStream(t *testing.T) {
+	ctx := context.Background()
+	plugin, err := New(ctx, WithFile(STREAM_WASM))
+	require.NoError(t, err, "failed to create module")
+	defer func() {
+		err := plugin.Close(ctx)
+		require.NoError(t, err, "failed to close module")
+	}()
+
+	_, err = plugin.InvokeStream(ctx, "upper", nil)
+	require.Error(t, err, "expected error when streaming a nil reader")
+
+	// 64 MiB is far larger than the plugin's single page of memory.
+	const size = 64 << 20
+	chunk := bytes.Repeat([]byte("hookr"), 1024)
+	input := io.LimitReader(&repeatReader{data: chunk}, size)
+
+	out, err := plugin.InvokeStream(ctx, "upper", input)
+	require.NoError(t, err, "failed to invoke stream")
+	defer func() {
+		require.NoError(t, out.Close(), "failed to close stream")
+	}()
+
+	expected := bytes.ToUpper(chunk)
+	buf := make([]byte, len(chunk))
+	var total int
+	for {
+		n, err := io.ReadFull(out, buf)
+		total += n
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		require.NoError(t, err, "failed to read stream")
+		require.Equal(t, expected, buf, "stream output should be upper cased")
+	}
+	require.Equal(t, size, total, "stream output should be the same size as the input")
+	require.Equal(t, uint32(65536), plugin.MemorySize(), "memory should not grow with the stream")
+}
+
+func TestHookrStreamClosed(t *testing.T) {
+	ctx := context.Background()
+	plugin, err := New(ctx, WithFile(STREAM_WASM))
+	require.NoError(t, err, "failed to create module")
+	defer func() {
+		err := plugin.Close(ctx)
+		require.NoError(t, err, "failed to close module")
+	}()
+
+	out, err := plugin.InvokeStream(ctx, "upper", &repeatReader{data: []byte("endless")})
+	require.NoError(t, err, "failed to invoke stream")
+	_, err = io.ReadFull(out, make([]byte, 16))
+	require.NoError(t, err, "failed to read stream")
+	require.NoError(t, out.Close(), "failed to close stream")
+
+	// closing the reader must release the plugin for the next call.
+	out, err = plugin.InvokeStream(ctx, "upper", strings.NewReader("again"))
+	require.NoError(t, err, "failed to invoke stream")
+	data, err := io.ReadAll(out)
+	require.NoError(t, err, "failed to read stream")
+	require.Equal(t, "AGAIN", string(data))
+}
+
+func TestHookrStreamError(t *testing.T) {
+	ctx := context.Background()
+	plugin, err := New(ctx, WithFile(STREAM_WASM))
+	require.NoError(t, err, "failed to create module")
+	defer func() {
+		err := plugin.Close(ctx)
+		require.NoError(t, err, "failed to close module")
+	}()
+
+	readErr := errors.New("planned failure")
+	out, err := plugin.InvokeStream(ctx, "upper", io.MultiReader(strings.NewReader("partial"), &errReader{err: readErr}))
+	require.NoError(t, err, "failed to invoke stream")
+	data, err := io.ReadAll(out)
+	require.Error(t, err, "expected the read error to be reported by the plugin")
+	require.Equal(t, "PARTIAL", string(data))
+}
+
+// repeatReader endlessly repeats data.
+type repeatReader struct {
+	data []byte
+	off  int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], r.data[r.off:])
+		r.off = (r.off + c) % len(r.data)
+		n += c
+	}
+	return n, nil
+}
+
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
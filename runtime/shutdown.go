@@ -0,0 +1,139 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by calls of a runtime which is shut down or closed.
+var ErrClosed = errors.New("runtime closed")
+
+// abortTimeout bounds the wait for the cancelled calls of a runtime which is
+// shut down or closed, calls blocked in a host function which ignores its
+// context are abandoned after it.
+const abortTimeout = time.Second
+
+// inflight tracks the in-flight calls of a runtime, so it can be shut down
+// once they finished and cancel those which do not finish in time.
+type inflight struct {
+	mu      sync.Mutex
+	closing bool
+	calls   sync.WaitGroup
+	abort   context.Context // done once the remaining calls are cancelled
+	cancel  context.CancelFunc
+}
+
+// enter starts a call, returning ErrClosed once the runtime is shutting down.
+// The returned context is cancelled when the call is aborted, done must be
+// called when the call finished.
+func (f *inflight) enter(ctx context.Context) (context.Context, func(), error) {
+	f.mu.Lock()
+	if f.closing {
+		f.mu.Unlock()
+		return nil, nil, ErrClosed
+	}
+	f.init()
+	f.calls.Add(1)
+	abort := f.abort
+	f.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(abort, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+		f.calls.Done()
+	}, nil
+}
+
+// init creates the abort context. The caller must hold f.mu.
+func (f *inflight) init() {
+	if f.abort == nil {
+		f.abort, f.cancel = context.WithCancel(context.Background())
+	}
+}
+
+// onAbort calls fn in its own goroutine when the in-flight calls are
+// cancelled, unless stop is called first.
+func (f *inflight) onAbort(fn func()) (stop func() bool) {
+	f.mu.Lock()
+	f.init()
+	abort := f.abort
+	f.mu.Unlock()
+	return context.AfterFunc(abort, fn)
+}
+
+// drain stops accepting calls and waits for the in-flight ones until ctx is
+// done, the remaining calls are then cancelled and waited for until
+// abortTimeout. It returns an error wrapping ctx.Err() when they did not
+// return in time.
+func (f *inflight) drain(ctx context.Context) error {
+	f.mu.Lock()
+	f.closing = true
+	f.init()
+	cancel := f.cancel
+	f.mu.Unlock()
+	defer cancel()
+
+	drained := make(chan struct{})
+	go func() {
+		f.calls.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+	}
+
+	cancel()
+	timer := time.NewTimer(abortTimeout)
+	defer timer.Stop()
+	select {
+	case <-drained:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("in-flight calls did not return after being cancelled: %w", ctx.Err())
+	}
+}
+
+// Shutdown gracefully closes the runtime. New calls fail with ErrClosed while
+// the in-flight calls are given until ctx is done to finish, the remaining
+// calls are cancelled before the runtime is closed as by Close:
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+//	defer cancel()
+//	err := rt.Shutdown(ctx)
+func (e *Runtime) Shutdown(ctx context.Context) error {
+	err := e.inflight.drain(ctx)
+	return errors.Join(err, e.closeOnce(context.WithoutCancel(ctx), err == nil))
+}
+
+// Close cancels the in-flight calls and closes the runtime once they returned,
+// see Shutdown for waiting for them to finish. New calls fail with ErrClosed,
+// borrowed responses and streams are released and fail with ErrClosed too.
+// Calls which do not return within a second of being cancelled, such as those
+// blocked in a host function ignoring its context, are abandoned: the runtime
+// is closed anyway and an error is returned.
+// The plugin's shutdown function is given until the shutdown timeout to flush
+// any state, an error from it is returned once everything is closed. Closing
+// a closed runtime returns the result of the first Close.
+func (e *Runtime) Close(ctx context.Context) error {
+	done, cancel := context.WithCancel(ctx)
+	cancel()
+	err := e.inflight.drain(done)
+	return errors.Join(err, e.closeOnce(ctx, err == nil))
+}
+
+// closeOnce closes the runtime the first time it is called, later calls wait
+// for it and return its result. The plugin's shutdown function is only called
+// when the calls drained, an abandoned call still holds the plugin.
+func (e *Runtime) closeOnce(ctx context.Context, drained bool) error {
+	e.closed.Do(func() {
+		e.closeErr = e.close(ctx, drained)
+	})
+	return e.closeErr
+}
@@ -0,0 +1,151 @@
+package runtime
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// busy waits until a call holds the plugin.
+func busy(t *testing.T, rt *Runtime) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		if rt.mu.TryLock() {
+			rt.mu.Unlock()
+			return false
+		}
+		return true
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestShutdownDrains(t *testing.T) {
+	ctx := context.Background()
+	release := make(chan struct{})
+	rt, err := New(ctx, WithFile(ABI_WASM), WithHostFns(HostFnByte("hello", func(ctx context.Context, payload []byte) ([]byte, error) {
+		<-release
+		return reply(ctx, payload)
+	})))
+	require.NoError(t, err)
+
+	type result struct {
+		out []byte
+		err error
+	}
+	inflight := make(chan result, 1)
+	go func() {
+		out, err := rt.Invoke(ctx, "echo", []byte("a"))
+		inflight <- result{out, err}
+	}()
+	busy(t, rt)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		shutdown <- rt.Shutdown(ctx)
+	}()
+	require.Eventually(t, func() bool {
+		_, err := rt.Invoke(ctx, "noop", nil)
+		return err == ErrClosed
+	}, 5*time.Second, time.Millisecond, "new calls are refused while draining")
+
+	close(release)
+	res := <-inflight
+	require.NoError(t, res.err, "the in-flight call finishes")
+	require.Equal(t, []byte("re: a"), res.out)
+	require.NoError(t, <-shutdown)
+	require.Equal(t, StateClosed, rt.State())
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	ctx := context.Background()
+	rt, err := New(ctx, WithFile(TRAP_WASM), WithInterruptOnContextDone())
+	require.NoError(t, err)
+
+	inflight := make(chan error, 1)
+	go func() {
+		_, err := rt.Invoke(ctx, "loop", nil)
+		inflight <- err
+	}()
+	busy(t, rt)
+
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	require.NoError(t, rt.Shutdown(timeout))
+	require.Less(t, time.Since(start), 5*time.Second, "the remaining calls are cancelled")
+	require.ErrorIs(t, <-inflight, context.Canceled)
+}
+
+func TestCloseIdempotent(t *testing.T) {
+	ctx := context.Background()
+	rt, err := New(ctx, WithFile(ABI_WASM), WithHostFns(HostFnByte("hello", reply)))
+	require.NoError(t, err)
+
+	require.NoError(t, rt.Close(ctx))
+	require.NoError(t, rt.Close(ctx), "closing twice is allowed")
+	require.NoError(t, rt.Shutdown(ctx))
+
+	_, err = rt.Invoke(ctx, "noop", nil)
+	require.ErrorIs(t, err, ErrClosed)
+	_, err = rt.InvokeBorrowed(ctx, "noop", nil)
+	require.ErrorIs(t, err, ErrClosed)
+	_, err = rt.InvokeStream(ctx, "noop", strings.NewReader(""))
+	require.ErrorIs(t, err, ErrClosed)
+	require.ErrorIs(t, rt.Health(ctx), ErrClosed)
+	for _, res := range rt.InvokeBatch(ctx, "noop", [][]byte{nil}) {
+		require.ErrorIs(t, res.Err, ErrClosed)
+	}
+}
+
+func TestCloseReleasesBorrowed(t *testing.T) {
+	ctx := context.Background()
+	rt, err := New(ctx, WithFile(STREAM_WASM))
+	require.NoError(t, err)
+
+	resp, err := rt.InvokeBorrowed(ctx, "upper", []byte("never released"))
+	require.NoError(t, err)
+	start := time.Now()
+	require.NoError(t, rt.Close(ctx))
+	require.Less(t, time.Since(start), abortTimeout, "the response is released")
+	require.ErrorIs(t, resp.Err(), ErrClosed)
+	require.Nil(t, resp.Bytes())
+	resp.Release()
+
+	rt, err = New(ctx, WithFile(STREAM_WASM))
+	require.NoError(t, err)
+	out, err := rt.InvokeStream(ctx, "upper", &repeatReader{data: []byte("never read")})
+	require.NoError(t, err)
+	start = time.Now()
+	require.NoError(t, rt.Close(ctx))
+	require.Less(t, time.Since(start), abortTimeout, "the stream is released")
+	_, err = io.ReadAll(out)
+	require.ErrorIs(t, err, ErrClosed)
+}
+
+func TestCloseAbandonsBlockedCalls(t *testing.T) {
+	ctx := context.Background()
+	unblock := make(chan struct{})
+	defer close(unblock)
+	rt, err := New(ctx, WithFile(ABI_WASM), WithHostFns(HostFnByte("hello", func(ctx context.Context, payload []byte) ([]byte, error) {
+		<-unblock // ignores ctx
+		return reply(ctx, payload)
+	})))
+	require.NoError(t, err)
+
+	go func() {
+		_, _ = rt.Invoke(ctx, "echo", []byte("a"))
+	}()
+	busy(t, rt)
+
+	start := time.Now()
+	err = rt.Close(ctx)
+	require.ErrorIs(t, err, context.Canceled, "the blocked call is abandoned")
+	require.Less(t, time.Since(start), 5*abortTimeout)
+	require.Equal(t, StateClosed, rt.State())
+	require.ErrorIs(t, rt.Close(ctx), context.Canceled, "closing again abandons the call again")
+}
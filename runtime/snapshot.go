@@ -0,0 +1,45 @@
+package runtime
+
+import (
+	"fmt"
+
+	"github.com/mopeyjellyfish/hookr/runtime/snapshot"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// Snapshot returns the state of the plugin captured after its initialization,
+// nil unless the runtime was created with WithSnapshot.
+func (e *Runtime) Snapshot() *snapshot.Snapshot {
+	return e.snapshot
+}
+
+// initOrRestore initializes a new instance of the plugin. Once a snapshot was
+// captured instances are restored from it instead of running the plugin's
+// initialization again.
+func (e *Runtime) initOrRestore(module api.Module) error {
+	if e.snapshot != nil {
+		if err := e.snapshot.Restore(module); err != nil {
+			return fmt.Errorf("failed to restore snapshot: %w", err)
+		}
+		return nil
+	}
+
+	if err := e.initialize(module); err != nil {
+		return err
+	}
+	return e.captureSnapshot(module)
+}
+
+// captureSnapshot captures the state of the instance as the snapshot later
+// instances are restored from, when the runtime takes snapshots.
+func (e *Runtime) captureSnapshot(module api.Module) error {
+	if !e.snapshotting {
+		return nil
+	}
+	s, err := snapshot.Capture(module, e.snapshotGlobals)
+	if err != nil {
+		return fmt.Errorf("failed to capture snapshot: %w", err)
+	}
+	e.snapshot = s
+	return nil
+}
@@ -0,0 +1,395 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/mopeyjellyfish/hookr/runtime/memory"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// Section ids of the WebAssembly binary format.
+const (
+	sectionCustom    byte = 0
+	sectionImport    byte = 2
+	sectionMemory    byte = 5
+	sectionGlobal    byte = 6
+	sectionExport    byte = 7
+	sectionStart     byte = 8
+	sectionData      byte = 11
+	sectionDataCount byte = 12
+)
+
+// External kinds of imports and exports.
+const (
+	kindFunc   byte = 0
+	kindTable  byte = 1
+	kindMemory byte = 2
+	kindGlobal byte = 3
+	kindTag    byte = 4
+)
+
+// Value types of globals.
+const (
+	typeI32 byte = 0x7f
+	typeI64 byte = 0x7e
+	typeF32 byte = 0x7d
+	typeF64 byte = 0x7c
+)
+
+var magic = []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+
+var errUnexpectedEOF = errors.New("unexpected end of module")
+
+type section struct {
+	id      byte
+	content []byte
+}
+
+// global is a global defined by the module.
+type global struct {
+	index    uint32 // index in the global index space, including imported globals
+	valType  byte
+	mutable  bool
+	initExpr []byte // including the end opcode
+}
+
+// module is a WebAssembly binary split into the sections which are rewritten.
+type module struct {
+	sections       []section
+	importedGlobal uint32
+	importedMemory bool
+	globals        []global
+}
+
+func parse(bin []byte) (*module, error) {
+	if !bytes.HasPrefix(bin, magic) {
+		return nil, errors.New("not a WebAssembly 1.0 binary")
+	}
+	m := &module{}
+	r := &reader{b: bin[len(magic):]}
+	for !r.done() {
+		id, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		content, err := r.vec()
+		if err != nil {
+			return nil, fmt.Errorf("section %d: %w", id, err)
+		}
+		m.sections = append(m.sections, section{id: id, content: content})
+
+		switch id {
+		case sectionImport:
+			err = m.parseImports(content)
+		case sectionGlobal:
+			err = m.parseGlobals(content)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("section %d: %w", id, err)
+		}
+	}
+	return m, nil
+}
+
+func (m *module) parseImports(content []byte) error {
+	r := &reader{b: content}
+	count, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for ; count > 0; count-- {
+		if _, err := r.vec(); err != nil { // module
+			return err
+		}
+		if _, err := r.vec(); err != nil { // name
+			return err
+		}
+		kind, err := r.byte()
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case kindFunc:
+			_, err = r.u32()
+		case kindTable:
+			if _, err = r.byte(); err == nil {
+				err = r.limits()
+			}
+		case kindMemory:
+			m.importedMemory = true
+			err = r.limits()
+		case kindGlobal:
+			m.importedGlobal++
+			_, err = r.bytes(2) // value type and mutability
+		case kindTag:
+			if _, err = r.byte(); err == nil {
+				_, err = r.u32()
+			}
+		default:
+			return fmt.Errorf("unknown import kind %d", kind)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *module) parseGlobals(content []byte) error {
+	r := &reader{b: content}
+	count, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < count; i++ {
+		typ, err := r.bytes(2)
+		if err != nil {
+			return err
+		}
+		expr, err := r.constExpr()
+		if err != nil {
+			return fmt.Errorf("global %d: %w", m.importedGlobal+i, err)
+		}
+		m.globals = append(m.globals, global{
+			index:    m.importedGlobal + i,
+			valType:  typ[0],
+			mutable:  typ[1] == 1,
+			initExpr: expr,
+		})
+	}
+	return nil
+}
+
+// section returns the index of the first section with the id, -1 if there is none.
+func (m *module) section(id byte) int {
+	for i, s := range m.sections {
+		if s.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// setSection replaces the section with the id, or inserts it in order when
+// the module does not have one. A nil content removes the section.
+func (m *module) setSection(id byte, content []byte) {
+	if i := m.section(id); i >= 0 {
+		if content == nil {
+			m.sections = append(m.sections[:i], m.sections[i+1:]...)
+		} else {
+			m.sections[i].content = content
+		}
+		return
+	}
+	if content == nil {
+		return
+	}
+	i := len(m.sections)
+	for j, s := range m.sections {
+		if s.id != sectionCustom && order(s.id) > order(id) {
+			i = j
+			break
+		}
+	}
+	m.sections = append(m.sections[:i], append([]section{{id: id, content: content}}, m.sections[i:]...)...)
+}
+
+// order returns the position of a section, the data count section comes
+// before the code section.
+func order(id byte) int {
+	if id == sectionDataCount {
+		return 10*2 - 1
+	}
+	return int(id) * 2
+}
+
+func (m *module) bytes() []byte {
+	b := append([]byte{}, magic...)
+	for _, s := range m.sections {
+		b = append(b, s.id)
+		b = appendVec(b, s.content)
+	}
+	return b
+}
+
+// reader decodes the primitives of the WebAssembly binary format.
+type reader struct {
+	b   []byte
+	pos int
+}
+
+func (r *reader) done() bool {
+	return r.pos >= len(r.b)
+}
+
+func (r *reader) byte() (byte, error) {
+	if r.done() {
+		return 0, errUnexpectedEOF
+	}
+	b := r.b[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *reader) bytes(n int) ([]byte, error) {
+	if n < 0 || len(r.b)-r.pos < n {
+		return nil, errUnexpectedEOF
+	}
+	b := r.b[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *reader) u32() (uint32, error) {
+	v, err := r.uleb(32)
+	return api.DecodeU32(v), err
+}
+
+// uleb reads an unsigned LEB128 integer of at most bits bits.
+func (r *reader) uleb(bits uint) (uint64, error) {
+	var v uint64
+	for shift := uint(0); shift < bits+7; shift += 7 {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		v |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return 0, errors.New("integer too large")
+}
+
+// skipLEB skips a signed or unsigned LEB128 integer.
+func (r *reader) skipLEB() error {
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if b&0x80 == 0 {
+			return nil
+		}
+	}
+}
+
+func (r *reader) vec() ([]byte, error) {
+	n, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+	return r.bytes(int(n))
+}
+
+func (r *reader) limits() error {
+	flags, err := r.byte()
+	if err != nil {
+		return err
+	}
+	if _, err := r.uleb(64); err != nil {
+		return err
+	}
+	if flags&1 == 1 {
+		_, err = r.uleb(64)
+	}
+	return err
+}
+
+// constExpr reads a constant expression, including its end opcode.
+func (r *reader) constExpr() ([]byte, error) {
+	start := r.pos
+	for {
+		op, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case 0x0b: // end
+			return r.b[start:r.pos], nil
+		case 0x41, 0x42, 0x23, 0xd2: // i32.const, i64.const, global.get, ref.func
+			err = r.skipLEB()
+		case 0x43: // f32.const
+			_, err = r.bytes(4)
+		case 0x44: // f64.const
+			_, err = r.bytes(8)
+		case 0xd0: // ref.null
+			_, err = r.byte()
+		case 0x6a, 0x6b, 0x6c, 0x7c, 0x7d, 0x7e: // extended constant arithmetic
+		case 0xfd: // v128.const
+			if err = r.skipLEB(); err == nil {
+				_, err = r.bytes(16)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported constant expression opcode 0x%x", op)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func appendU32(b []byte, v uint32) []byte {
+	return appendULEB(b, uint64(v))
+}
+
+func appendULEB(b []byte, v uint64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			b = append(b, c|0x80)
+			continue
+		}
+		return append(b, c)
+	}
+}
+
+func appendSLEB(b []byte, v int64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func appendVec(b []byte, v []byte) []byte {
+	size, err := memory.Uint32FromInt(len(v))
+	if err != nil {
+		panic(err) // vectors of a module are limited to 4GiB
+	}
+	b = appendU32(b, size)
+	return append(b, v...)
+}
+
+// constInit returns the constant expression initializing a global of the type with the value.
+func constInit(valType byte, v uint64) ([]byte, error) {
+	switch valType {
+	case typeI32:
+		return append(appendSLEB([]byte{0x41}, int64(api.DecodeI32(v))), 0x0b), nil
+	case typeI64:
+		return append(appendSLEB([]byte{0x42}, int64(v)), 0x0b), nil
+	case typeF32:
+		return append(binary.LittleEndian.AppendUint32([]byte{0x43}, api.DecodeU32(v)), 0x0b), nil
+	case typeF64:
+		return append(binary.LittleEndian.AppendUint64([]byte{0x44}, v), 0x0b), nil
+	default:
+		return nil, fmt.Errorf("unsupported global type 0x%x", valType)
+	}
+}
+
+// pages returns the number of 64KiB pages holding size bytes.
+func pages(size int) (uint32, error) {
+	p := (size + 65535) / 65536
+	if p > math.MaxUint32 {
+		return 0, errors.New("memory too large")
+	}
+	return uint32(p), nil
+}
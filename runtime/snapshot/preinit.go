@@ -0,0 +1,267 @@
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mopeyjellyfish/hookr/runtime/memory"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// InitFunctions are the exports a pre-initialized module no longer exports,
+// the state they set up is part of the snapshot.
+var InitFunctions = []string{"_start", "_initialize", "hookr_init"}
+
+// zeroGap is the number of zero bytes which end a data segment, shorter runs
+// of zeros are cheaper to keep in the segment than to start a new one.
+const zeroGap = 64
+
+// Preinitialize returns the module starting in the state of the snapshot. Its
+// globals are initialized to their snapshot values, its memory holds the
+// snapshot memory and the initialization functions are no longer exported.
+func Preinitialize(bin []byte, s *Snapshot) ([]byte, error) {
+	m, err := parse(bin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse module: %w", err)
+	}
+	if m.importedMemory && len(s.Memory) > 0 {
+		return nil, errors.New("modules importing their memory cannot be pre-initialized")
+	}
+
+	if err := m.setGlobals(s.Globals); err != nil {
+		return nil, err
+	}
+	if err := m.setMemory(s.Memory); err != nil {
+		return nil, err
+	}
+	if err := m.dropExports(InitFunctions); err != nil {
+		return nil, err
+	}
+	m.setSection(sectionStart, nil)
+	return m.bytes(), nil
+}
+
+// setGlobals initializes the globals to their values.
+func (m *module) setGlobals(values []Global) error {
+	if len(m.globals) == 0 {
+		return nil
+	}
+	inits := make(map[uint32]uint64, len(values))
+	for _, g := range values {
+		inits[g.Index] = g.Value
+	}
+
+	count, err := memory.Uint32FromInt(len(m.globals))
+	if err != nil {
+		return err
+	}
+	b := appendU32(nil, count)
+	for _, g := range m.globals {
+		expr := g.initExpr
+		if v, ok := inits[g.index]; ok {
+			var err error
+			if expr, err = constInit(g.valType, v); err != nil {
+				return fmt.Errorf("global %d: %w", g.index, err)
+			}
+		}
+		mutable := byte(0)
+		if g.mutable {
+			mutable = 1
+		}
+		b = append(b, g.valType, mutable)
+		b = append(b, expr...)
+	}
+	m.setSection(sectionGlobal, b)
+	return nil
+}
+
+// setMemory sizes the memory for the data and replaces the data segments
+// with ones holding the data.
+func (m *module) setMemory(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	i := m.section(sectionMemory)
+	if i < 0 {
+		return errors.New("module has no memory")
+	}
+	r := &reader{b: m.sections[i].content}
+	count, err := r.u32()
+	if err != nil {
+		return fmt.Errorf("failed to parse memory: %w", err)
+	}
+	flags, err := r.byte()
+	if err != nil {
+		return fmt.Errorf("failed to parse memory: %w", err)
+	}
+	if count == 0 || flags&^3 != 0 {
+		return fmt.Errorf("unsupported memory with flags 0x%x", flags)
+	}
+	if _, err := r.uleb(32); err != nil {
+		return fmt.Errorf("failed to parse memory: %w", err)
+	}
+	want, err := pages(len(data))
+	if err != nil {
+		return err
+	}
+	limits := appendU32([]byte{flags}, want)
+	if flags&1 == 1 {
+		maximum, err := r.u32()
+		if err != nil {
+			return fmt.Errorf("failed to parse memory: %w", err)
+		}
+		if maximum < want {
+			return fmt.Errorf("memory of %d pages exceeds the maximum of %d", want, maximum)
+		}
+		limits = appendU32(limits, maximum)
+	}
+	b := appendU32(nil, count)
+	b = append(b, limits...)
+	m.sections[i].content = append(b, r.b[r.pos:]...) // other memories are unchanged
+
+	return m.setData(data)
+}
+
+// setData replaces the active data segments with segments holding the data.
+// Modules with a data count section may refer to segments by index, their
+// active segments become passive so the indexes stay valid.
+func (m *module) setData(data []byte) error {
+	_, keep := m.sectionContent(sectionDataCount)
+
+	var segments [][]byte
+	if content, ok := m.sectionContent(sectionData); ok && keep {
+		r := &reader{b: content}
+		count, err := r.u32()
+		if err != nil {
+			return fmt.Errorf("failed to parse data: %w", err)
+		}
+		for ; count > 0; count-- {
+			seg, err := r.passiveSegment()
+			if err != nil {
+				return fmt.Errorf("failed to parse data: %w", err)
+			}
+			segments = append(segments, seg)
+		}
+	}
+
+	for start := 0; start < len(data); {
+		if data[start] == 0 {
+			start++
+			continue
+		}
+		end, zeros := start, 0
+		for end < len(data) && zeros < zeroGap {
+			if data[end] == 0 {
+				zeros++
+			} else {
+				zeros = 0
+			}
+			end++
+		}
+		end -= zeros
+
+		offset, err := memory.Uint32FromInt(start)
+		if err != nil {
+			return err
+		}
+		seg := []byte{0} // active segment of memory 0
+		seg = appendSLEB(append(seg, 0x41), int64(api.DecodeI32(uint64(offset))))
+		seg = appendVec(append(seg, 0x0b), data[start:end])
+		segments = append(segments, seg)
+		start = end
+	}
+
+	count, err := memory.Uint32FromInt(len(segments))
+	if err != nil {
+		return err
+	}
+	b := appendU32(nil, count)
+	for _, seg := range segments {
+		b = append(b, seg...)
+	}
+	m.setSection(sectionData, b)
+	if keep {
+		m.setSection(sectionDataCount, appendU32(nil, count))
+	}
+	return nil
+}
+
+// passiveSegment reads a data segment and returns it encoded as a passive segment.
+func (r *reader) passiveSegment() ([]byte, error) {
+	flags, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+	switch flags {
+	case 0: // active segment of memory 0
+		_, err = r.constExpr()
+	case 1: // passive segment
+	case 2: // active segment of an explicit memory
+		if _, err = r.u32(); err == nil {
+			_, err = r.constExpr()
+		}
+	default:
+		return nil, fmt.Errorf("unknown data segment kind %d", flags)
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := r.vec()
+	if err != nil {
+		return nil, err
+	}
+	return appendVec([]byte{1}, data), nil
+}
+
+// dropExports removes the named exports.
+func (m *module) dropExports(names []string) error {
+	content, ok := m.sectionContent(sectionExport)
+	if !ok {
+		return nil
+	}
+	r := &reader{b: content}
+	count, err := r.u32()
+	if err != nil {
+		return fmt.Errorf("failed to parse exports: %w", err)
+	}
+
+	var kept uint32
+	var b []byte
+	for ; count > 0; count-- {
+		start := r.pos
+		name, err := r.vec()
+		if err != nil {
+			return fmt.Errorf("failed to parse exports: %w", err)
+		}
+		if _, err := r.byte(); err != nil {
+			return fmt.Errorf("failed to parse exports: %w", err)
+		}
+		if _, err := r.u32(); err != nil {
+			return fmt.Errorf("failed to parse exports: %w", err)
+		}
+		if contains(names, string(name)) {
+			continue
+		}
+		b = append(b, r.b[start:r.pos]...)
+		kept++
+	}
+	m.setSection(sectionExport, append(appendU32(nil, kept), b...))
+	return nil
+}
+
+func (m *module) sectionContent(id byte) ([]byte, bool) {
+	i := m.section(id)
+	if i < 0 {
+		return nil, false
+	}
+	return m.sections[i].content, true
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
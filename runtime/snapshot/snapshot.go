@@ -0,0 +1,129 @@
+// Package snapshot captures the memory and globals of an initialized plugin
+// instance so further instances can be restored from it instead of running
+// the plugin's initialization again.
+//
+// Globals which are not exported cannot be read through wazero, Instrument
+// rewrites a module to export every mutable global it defines before it is
+// compiled:
+//
+//	bin, globals, err := snapshot.Instrument(wasm)
+//	// compile bin, instantiate it and run its initialization
+//	snap, err := snapshot.Capture(mod, globals)
+//	// instantiate bin again without running its initialization
+//	err = snap.Restore(fresh)
+//
+// Preinitialize writes a snapshot out as a module which starts in the
+// initialized state, so any WebAssembly runtime benefits from it.
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// Global is the value of a mutable global defined by the module.
+type Global struct {
+	Index uint32 // index in the global index space of the module
+	Value uint64
+}
+
+// Snapshot is the state of an initialized plugin instance.
+type Snapshot struct {
+	Memory  []byte
+	Globals []Global
+}
+
+// GlobalExport is the name Instrument exports the global with the index as.
+func GlobalExport(index uint32) string {
+	return fmt.Sprintf("__hookr_global_%d", index)
+}
+
+// Instrument returns the module exporting every mutable global it defines,
+// see GlobalExport, and the indexes of those globals.
+func Instrument(bin []byte) ([]byte, []uint32, error) {
+	m, err := parse(bin)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse module: %w", err)
+	}
+
+	var exports []byte
+	var count uint32
+	if i := m.section(sectionExport); i >= 0 {
+		r := &reader{b: m.sections[i].content}
+		if count, err = r.u32(); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse exports: %w", err)
+		}
+		exports = append([]byte{}, r.b[r.pos:]...)
+	}
+
+	var indexes []uint32
+	for _, g := range m.globals {
+		if !g.mutable {
+			continue
+		}
+		indexes = append(indexes, g.index)
+		exports = appendVec(exports, []byte(GlobalExport(g.index)))
+		exports = append(exports, kindGlobal)
+		exports = appendU32(exports, g.index)
+		count++
+	}
+	if len(indexes) == 0 {
+		return bin, nil, nil
+	}
+	m.setSection(sectionExport, append(appendU32(nil, count), exports...))
+	return m.bytes(), indexes, nil
+}
+
+// Capture reads the memory and the globals of an instance of a module
+// rewritten by Instrument.
+func Capture(mod api.Module, globals []uint32) (*Snapshot, error) {
+	s := &Snapshot{}
+	if mem := mod.Memory(); mem != nil {
+		data, ok := mem.Read(0, mem.Size())
+		if !ok {
+			return nil, errors.New("failed to read memory")
+		}
+		s.Memory = append([]byte{}, data...)
+	}
+	for _, index := range globals {
+		g := mod.ExportedGlobal(GlobalExport(index))
+		if g == nil {
+			return nil, fmt.Errorf("global %d is not exported", index)
+		}
+		s.Globals = append(s.Globals, Global{Index: index, Value: g.Get()})
+	}
+	return s, nil
+}
+
+// Restore writes the snapshot into a fresh instance of a module rewritten by
+// Instrument, growing its memory when needed.
+func (s *Snapshot) Restore(mod api.Module) error {
+	if len(s.Memory) > 0 {
+		mem := mod.Memory()
+		if mem == nil {
+			return errors.New("module has no memory")
+		}
+		want, err := pages(len(s.Memory))
+		if err != nil {
+			return err
+		}
+		if have := mem.Size() / 65536; have < want {
+			if _, ok := mem.Grow(want - have); !ok {
+				return fmt.Errorf("failed to grow memory to %d pages", want)
+			}
+		}
+		if !mem.Write(0, s.Memory) {
+			return errors.New("failed to write memory")
+		}
+	}
+	for _, g := range s.Globals {
+		global, ok := mod.ExportedGlobal(GlobalExport(g.Index)).(api.MutableGlobal)
+		if !ok {
+			return fmt.Errorf("global %d is not exported as mutable", g.Index)
+		}
+		global.Set(g.Value)
+	}
+	return nil
+}
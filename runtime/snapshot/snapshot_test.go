@@ -0,0 +1,68 @@
+package snapshot
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstrument(t *testing.T) {
+	_, _, err := Instrument([]byte("not wasm"))
+	require.Error(t, err, "expected error for an invalid module")
+
+	empty := append([]byte{}, magic...)
+	bin, globals, err := Instrument(empty)
+	require.NoError(t, err)
+	require.Equal(t, empty, bin, "modules without mutable globals should be unchanged")
+	require.Empty(t, globals)
+
+	// (global (mut i32) (i32.const 7)) (global i64 (i64.const 1))
+	globalSection := []byte{2, typeI32, 1, 0x41, 7, 0x0b, typeI64, 0, 0x42, 1, 0x0b}
+	mod := append(append([]byte{}, magic...), sectionGlobal, byte(len(globalSection)))
+	mod = append(mod, globalSection...)
+	bin, globals, err = Instrument(mod)
+	require.NoError(t, err)
+	require.Equal(t, []uint32{0}, globals, "only mutable globals should be exported")
+
+	m, err := parse(bin)
+	require.NoError(t, err)
+	exports, ok := m.sectionContent(sectionExport)
+	require.True(t, ok, "expected an export section")
+	name := GlobalExport(0)
+	require.Equal(t, append(append([]byte{1, byte(len(name))}, name...), kindGlobal, 0), exports)
+}
+
+func TestConstInit(t *testing.T) {
+	tests := []struct {
+		valType byte
+		value   uint64
+	}{
+		{typeI32, 0},
+		{typeI32, math.MaxUint32}, // -1
+		{typeI32, 1 << 31},
+		{typeI64, math.MaxUint64},
+		{typeI64, 1 << 40},
+		{typeF32, uint64(math.Float32bits(1.5))},
+		{typeF64, math.Float64bits(-2.25)},
+	}
+	for _, tt := range tests {
+		expr, err := constInit(tt.valType, tt.value)
+		require.NoError(t, err)
+		r := &reader{b: expr}
+		parsed, err := r.constExpr()
+		require.NoError(t, err)
+		require.Equal(t, expr, parsed, "expected a single constant expression")
+	}
+
+	_, err := constInit(0x7b, 0)
+	require.Error(t, err, "expected error for v128 globals")
+}
+
+func TestAppendSLEB(t *testing.T) {
+	require.Equal(t, []byte{0x00}, appendSLEB(nil, 0))
+	require.Equal(t, []byte{0x7f}, appendSLEB(nil, -1))
+	require.Equal(t, []byte{0x3f}, appendSLEB(nil, 63))
+	require.Equal(t, []byte{0xc0, 0x00}, appendSLEB(nil, 64))
+	require.Equal(t, []byte{0x80, 0x7f}, appendSLEB(nil, -128))
+}
@@ -0,0 +1,101 @@
+package runtime
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mopeyjellyfish/hookr/runtime/snapshot"
+	"github.com/stretchr/testify/require"
+)
+
+const SNAPSHOT_WASM = "../testdata/snapshot/bin/snapshot.wasm"
+
+// initialized is the state the snapshot plugin responds with after hookr_init ran.
+var initialized = []byte{42, 'a', 'b', 'c', 1}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+
+	inits := 0
+	plugin, err := New(ctx,
+		WithFile(SNAPSHOT_WASM),
+		WithSnapshot(),
+		WithIsolation(),
+		WithLogger(func(string) { inits++ }),
+	)
+	require.NoError(t, err, "failed to create module")
+	defer func() {
+		require.NoError(t, plugin.Close(ctx), "failed to close module")
+	}()
+
+	require.NotNil(t, plugin.Snapshot())
+	require.Len(t, plugin.Snapshot().Memory, 2*65536, "the snapshot should include grown memory")
+
+	for range 3 {
+		out, err := plugin.Invoke(ctx, "write", nil)
+		require.NoError(t, err, "failed to invoke plugin")
+		require.Equal(t, initialized, out, "every instance should be restored from the snapshot")
+	}
+	require.Equal(t, 1, inits, "hookr_init should only run once")
+}
+
+func TestWithoutSnapshot(t *testing.T) {
+	ctx := context.Background()
+	plugin, err := New(ctx, WithFile(SNAPSHOT_WASM), WithLogger(func(string) {}))
+	require.NoError(t, err, "failed to create module")
+	defer func() {
+		require.NoError(t, plugin.Close(ctx), "failed to close module")
+	}()
+	require.Nil(t, plugin.Snapshot())
+
+	out, err := plugin.Invoke(ctx, "write", nil)
+	require.NoError(t, err, "failed to invoke plugin")
+	require.Equal(t, initialized, out)
+	out, err = plugin.Invoke(ctx, "read", nil)
+	require.NoError(t, err, "failed to invoke plugin")
+	require.Equal(t, []byte{0, 'X', 'b', 'c', 0}, out, "a shared instance should keep its state")
+}
+
+func TestPreinitialize(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		file string
+		op   string
+		in   []byte
+		want []byte
+	}{
+		{"snapshot", SNAPSHOT_WASM, "read", nil, initialized},
+		{"simple", SIMPLE_WASM, "vowel", []byte("hookr"), []byte("2")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bin, err := os.ReadFile(tt.file)
+			require.NoError(t, err)
+
+			plugin, err := New(ctx, WithFile(tt.file), WithSnapshot(), WithLogger(func(string) {}))
+			require.NoError(t, err, "failed to create module")
+			pre, err := snapshot.Preinitialize(bin, plugin.Snapshot())
+			require.NoError(t, err, "failed to pre-initialize module")
+			require.NoError(t, plugin.Close(ctx), "failed to close module")
+
+			file := filepath.Join(t.TempDir(), "pre.wasm")
+			require.NoError(t, os.WriteFile(file, pre, 0o600))
+
+			inits := 0
+			plugin, err = New(ctx, WithFile(file), WithLogger(func(string) { inits++ }))
+			require.NoError(t, err, "failed to create pre-initialized module")
+			defer func() {
+				require.NoError(t, plugin.Close(ctx), "failed to close module")
+			}()
+			require.Equal(t, 0, inits, "a pre-initialized module should not be initialized again")
+
+			out, err := plugin.Invoke(ctx, tt.op, tt.in)
+			require.NoError(t, err, "failed to invoke pre-initialized module")
+			require.Equal(t, tt.want, out)
+		})
+	}
+}
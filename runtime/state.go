@@ -0,0 +1,112 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mopeyjellyfish/hookr/runtime/invoke"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// fnExportState is called on the running version of a plugin when it is reloaded, see pdk.OnExportState. The plugin
+// responds with "__plugin_response", a msgpack array of the version tag and the state, or nothing when it has none:
+//
+//	(func $hookr_export_state (result (;ok;) i32))
+const fnExportState = "hookr_export_state"
+
+// fnImportState hands the exported state to the new version of a plugin, see pdk.OnImportState. The plugin reads the
+// version tag and the state with "__plugin_request", rejecting the state by returning false:
+//
+//	(func $hookr_import_state (param $version_len i32) (param $state_len i32) (result (;ok;) i32))
+const fnImportState = "hookr_import_state"
+
+// StateError is returned by Reload when the state of the plugin could not be
+// handed to the new version, which is then not swapped in.
+type StateError struct {
+	// Version is the version tag of the state, empty when it was not exported.
+	Version string
+	Err     error
+}
+
+func (e *StateError) Error() string {
+	if e.Version == "" {
+		return fmt.Sprintf("failed to export plugin state: %v", e.Err)
+	}
+	return fmt.Sprintf("plugin state %q rejected: %v", e.Version, e.Err)
+}
+
+func (e *StateError) Unwrap() error {
+	return e.Err
+}
+
+// migrateState hands the state of the previous instance of the plugin to the
+// current one, when the previous exports it and the current imports it. The
+// snapshot is captured again once the state is imported, so instances restored
+// later, see WithSnapshot, start with it instead of the initialized state.
+// The caller must hold e.mu.
+func (e *Runtime) migrateState(ctx context.Context, prev api.Module) error {
+	exportFn := prev.ExportedFunction(fnExportState)
+	importFn := e.plugin.ExportedFunction(fnImportState)
+	if exportFn == nil || importFn == nil {
+		return nil
+	}
+
+	version, state, err := exportState(ctx, exportFn)
+	if err != nil {
+		return &StateError{Err: err}
+	}
+	if version == "" && state == nil {
+		return nil // the plugin has no state
+	}
+
+	ic := invoke.Context{Operation: version, PluginReq: state}
+	results, err := importFn.Call(invoke.New(ctx, &ic), uint64(len(version)), uint64(len(state)))
+	switch {
+	case err != nil:
+		err = fmt.Errorf("error calling %s: %w", fnImportState, err)
+	case results[0] == 1:
+		return e.captureSnapshot(e.plugin)
+	case ic.PluginErr != "":
+		err = errors.New(ic.PluginErr)
+	default:
+		err = errors.New("state not imported")
+	}
+	return &StateError{Version: version, Err: err}
+}
+
+// exportState calls the plugin's export function, the state is copied out of
+// the plugin's memory.
+func exportState(ctx context.Context, fn api.Function) (version string, state []byte, err error) {
+	ic := invoke.Context{Operation: fnExportState}
+	results, err := fn.Call(invoke.New(ctx, &ic))
+	switch {
+	case err != nil:
+		return "", nil, fmt.Errorf("error calling %s: %w", fnExportState, err)
+	case ic.Err != nil:
+		return "", nil, ic.Err
+	case results[0] != 1 && ic.PluginErr != "":
+		return "", nil, errors.New(ic.PluginErr)
+	case results[0] != 1:
+		return "", nil, errors.New("state not exported")
+	case ic.PluginResp == nil:
+		return "", nil, nil
+	}
+
+	b := ic.PluginResp
+	size, b, err := msgp.ReadArrayHeaderBytes(b)
+	if err == nil && size != 2 {
+		err = fmt.Errorf("expected 2 elements, got %d", size)
+	}
+	if err == nil {
+		version, b, err = msgp.ReadStringBytes(b)
+	}
+	if err == nil {
+		state, _, err = msgp.ReadBytesBytes(b, []byte{})
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid plugin state: %w", err)
+	}
+	return version, state, nil
+}
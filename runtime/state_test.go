@@ -0,0 +1,60 @@
+package runtime
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	STATE_WASM    = "../testdata/state/bin/state.wasm"
+	STATE_V2_WASM = "../testdata/state/bin/v2.wasm"
+)
+
+func count(t *testing.T, rt *Runtime) uint32 {
+	t.Helper()
+	out, err := rt.Invoke(context.Background(), "count", nil)
+	require.NoError(t, err)
+	require.Len(t, out, 4)
+	return binary.LittleEndian.Uint32(out)
+}
+
+func TestReloadMigratesState(t *testing.T) {
+	ctx := context.Background()
+	rt := newReloadable(t, STATE_WASM)
+	require.Equal(t, uint32(1), count(t, rt))
+	require.Equal(t, uint32(2), count(t, rt))
+
+	require.NoError(t, rt.Reload(ctx, mustFile(t, STATE_WASM), nil))
+	require.Equal(t, uint32(3), count(t, rt), "the count is handed to the new version")
+
+	err := rt.Reload(ctx, mustFile(t, STATE_V2_WASM), nil)
+	var reloadErr *ReloadError
+	require.ErrorAs(t, err, &reloadErr)
+	var stateErr *StateError
+	require.ErrorAs(t, err, &stateErr)
+	require.Equal(t, "v1", stateErr.Version)
+	require.ErrorContains(t, err, `plugin state "v1" rejected: incompatible state`)
+	require.Equal(t, uint32(4), count(t, rt), "the previous version keeps running")
+
+	require.NoError(t, rt.Reload(ctx, mustFile(t, LEGACY_WASM), nil), "plugins without state reload")
+	out, err := rt.Invoke(ctx, "echo", []byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("re: a"), out)
+}
+
+func TestReloadMigratesStateSnapshot(t *testing.T) {
+	ctx := context.Background()
+	rt := newReloadable(t, STATE_WASM, WithSnapshot())
+	require.Equal(t, uint32(1), count(t, rt))
+	require.Equal(t, uint32(2), count(t, rt))
+
+	require.NoError(t, rt.Reload(ctx, mustFile(t, STATE_WASM), nil))
+	rt.mu.Lock()
+	err := rt.restart()
+	rt.mu.Unlock()
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), count(t, rt), "instances restored after the reload start with the imported state")
+}
@@ -0,0 +1,95 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// ErrTrap is returned when the plugin trapped, for example by executing unreachable,
+// accessing memory out of bounds or overflowing its stack. Use errors.As with a
+// *TrapError for the guest stack.
+var ErrTrap = errors.New("plugin trapped")
+
+// wasmStackTrace separates the message of a wazero error from the guest stack.
+const wasmStackTrace = "\nwasm stack trace:\n"
+
+// TrapError describes a trap of the plugin. The plugin instance which trapped
+// is discarded and a fresh instance is started for the next call.
+type TrapError struct {
+	Operation string
+	Stack     []string // guest stack, innermost frame first, when wazero provided one
+	Err       error
+}
+
+func (e *TrapError) Error() string {
+	msg, _, _ := strings.Cut(e.Err.Error(), wasmStackTrace)
+	return fmt.Sprintf("plugin trapped in %q: %s", e.Operation, msg)
+}
+
+func (e *TrapError) Unwrap() []error {
+	return []error{ErrTrap, e.Err}
+}
+
+// newTrapError returns a *TrapError when err is a trap of the guest, nil otherwise.
+// A call which ended with an exit, including one interrupted by a done context,
+// did not trap; any other failure of the call did.
+func newTrapError(operation string, err error) *TrapError {
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) {
+		return nil
+	}
+	trapErr := &TrapError{Operation: operation, Err: err}
+	if _, trace, ok := strings.Cut(err.Error(), wasmStackTrace); ok {
+		trace, _, _ = strings.Cut(trace, "\n\n") // drop the Go stack of host function panics
+		trapErr.Stack = strings.Split(trace, "\n")
+		for i := range trapErr.Stack {
+			trapErr.Stack[i] = strings.TrimPrefix(trapErr.Stack[i], "\t")
+		}
+	}
+	return trapErr
+}
+
+// Restarts returns how often the plugin was restarted after it trapped or was
+// interrupted.
+func (e *Runtime) Restarts() uint64 {
+	return e.restarts.Load()
+}
+
+// callError handles the error of a failed plugin call. A trap is returned as a
+// *TrapError, the plugin is restarted when it trapped or can no longer be used.
+// The caller must hold e.mu.
+func (e *Runtime) callError(operation string, err error) error {
+	trapErr := newTrapError(operation, err)
+	if trapErr != nil || e.plugin.IsClosed() {
+		if restartErr := e.restart(); restartErr != nil {
+			err = errors.Join(err, restartErr)
+			if trapErr != nil {
+				trapErr.Err = err
+			}
+		}
+	}
+	if trapErr != nil {
+		return trapErr
+	}
+	return fmt.Errorf("error while making %s call: %w", operation, err)
+}
+
+// restart discards the current plugin instance and instantiates a fresh one from
+// the compiled module, running the plugin's initialization again. The runtime
+// is degraded while the plugin cannot be restarted.
+// The caller must hold e.mu.
+func (e *Runtime) restart() error {
+	if e.State() == StateClosed {
+		return errors.New("runtime closed")
+	}
+	if err := e.reinstantiate(); err != nil {
+		e.setState(StateDegraded)
+		return fmt.Errorf("failed to restart plugin: %w", err)
+	}
+	e.restarts.Add(1)
+	e.setState(StateReady)
+	return nil
+}
@@ -0,0 +1,158 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const TRAP_WASM = "../testdata/trap/bin/trap.wasm"
+
+func TestTrapRecovery(t *testing.T) {
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	inits := 0
+	plugin, err := New(ctx,
+		WithFile(TRAP_WASM),
+		WithLogger(func(msg string) {
+			mu.Lock()
+			defer mu.Unlock()
+			inits++
+		}),
+	)
+	require.NoError(t, err, "failed to create module")
+	defer func() {
+		require.NoError(t, plugin.Close(ctx), "failed to close module")
+	}()
+
+	out, err := plugin.Invoke(ctx, "count", nil)
+	require.NoError(t, err, "failed to invoke plugin")
+	require.Equal(t, []byte{1}, out)
+	out, err = plugin.Invoke(ctx, "count", nil)
+	require.NoError(t, err, "failed to invoke plugin")
+	require.Equal(t, []byte{2}, out)
+
+	for i, op := range []string{"unreachable", "oob", "stack"} {
+		_, err = plugin.Invoke(ctx, op, nil)
+		require.ErrorIs(t, err, ErrTrap, "expected %s to trap", op)
+		var trapErr *TrapError
+		require.ErrorAs(t, err, &trapErr)
+		require.Equal(t, op, trapErr.Operation)
+		if op != "stack" { // wazero does not always trace stack overflows
+			require.NotEmpty(t, trapErr.Stack, "expected the guest stack")
+		}
+		require.Equal(t, uint64(i+1), plugin.Restarts())
+
+		out, err = plugin.Invoke(ctx, "count", nil)
+		require.NoError(t, err, "plugin should have been restarted after %s", op)
+		require.Equal(t, []byte{1}, out, "the restarted plugin should start from a fresh state")
+	}
+	require.Equal(t, StateReady, plugin.State())
+
+	mu.Lock()
+	require.Equal(t, 4, inits, "hookr_init should run for every restart")
+	mu.Unlock()
+
+	_, err = plugin.Invoke(ctx, "nope", nil)
+	require.NoError(t, err, "failed to invoke plugin")
+	require.Equal(t, uint64(3), plugin.Restarts(), "calls which do not trap should not restart the plugin")
+}
+
+func TestTrapHostPanic(t *testing.T) {
+	ctx := context.Background()
+	plugin, err := New(ctx,
+		WithFile(SIMPLE_WASM),
+		WithHostFns(HostFnByte("helloByte", func(ctx context.Context, payload []byte) ([]byte, error) {
+			panic(errors.New("host exploded"))
+		})),
+	)
+	require.NoError(t, err, "failed to create module")
+	defer func() {
+		require.NoError(t, plugin.Close(ctx), "failed to close module")
+	}()
+
+	_, err = plugin.Invoke(ctx, "echoByte", []byte("hookr"))
+	require.ErrorIs(t, err, ErrTrap)
+	require.ErrorContains(t, err, "host exploded")
+	require.Equal(t, uint64(1), plugin.Restarts())
+
+	out, err := plugin.Invoke(ctx, "vowel", []byte("hookr"))
+	require.NoError(t, err, "plugin should have been restarted")
+	require.Equal(t, "2", string(out))
+}
+
+func TestRestartAfterTimeout(t *testing.T) {
+	ctx := context.Background()
+	plugin, err := New(ctx, WithFile(TRAP_WASM), WithInterruptOnContextDone())
+	require.NoError(t, err, "failed to create module")
+	defer func() {
+		require.NoError(t, plugin.Close(ctx), "failed to close module")
+	}()
+
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = plugin.Invoke(timeout, "loop", nil)
+	require.Error(t, err, "expected the call to be interrupted")
+	require.NotErrorIs(t, err, ErrTrap, "a timeout is not a trap")
+	require.Equal(t, uint64(1), plugin.Restarts(), "the interrupted plugin should be restarted")
+
+	out, err := plugin.Invoke(ctx, "count", nil)
+	require.NoError(t, err, "failed to invoke plugin")
+	require.Equal(t, []byte{1}, out)
+}
+
+func TestTrapConcurrent(t *testing.T) {
+	ctx := context.Background()
+	for name, opts := range map[string][]Option{
+		"Shared":   nil,
+		"Isolated": {WithIsolation()}, // every call replaces the plugin instance
+	} {
+		t.Run(name, func(t *testing.T) {
+			plugin, err := New(ctx, append([]Option{WithFile(TRAP_WASM), WithLogger(func(string) {})}, opts...)...)
+			require.NoError(t, err, "failed to create module")
+			defer func() {
+				require.NoError(t, plugin.Close(ctx), "failed to close module")
+			}()
+
+			const calls = 20
+			var wg sync.WaitGroup
+			wg.Add(3)
+			go func() { // every trap replaces the plugin instance
+				defer wg.Done()
+				for range calls {
+					_, err := plugin.Invoke(ctx, "unreachable", nil)
+					assert.ErrorIs(t, err, ErrTrap)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for range calls {
+					_, err := plugin.Invoke(ctx, "count", nil)
+					assert.NoError(t, err, "failed to invoke plugin")
+					for _, result := range plugin.InvokeBatch(ctx, "count", [][]byte{nil, nil}) {
+						assert.NoError(t, result.Err, "failed to invoke batch")
+					}
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for range calls {
+					assert.NotZero(t, plugin.MemorySize())
+					assert.NoError(t, plugin.Health(ctx), "plugin should be healthy")
+					resp, err := plugin.InvokeBorrowed(ctx, "count", nil)
+					if assert.NoError(t, err, "failed to invoke plugin") {
+						resp.Release()
+					}
+				}
+			}()
+			wg.Wait()
+			require.Equal(t, uint64(calls), plugin.Restarts())
+		})
+	}
+}
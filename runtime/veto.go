@@ -0,0 +1,34 @@
+package runtime
+
+import (
+	"errors"
+
+	"github.com/mopeyjellyfish/hookr/runtime/invoke"
+	"github.com/mopeyjellyfish/hookr/runtime/module"
+)
+
+// ErrVetoed is matched by the VetoError of an invocation the plugin vetoed.
+var ErrVetoed = errors.New("vetoed")
+
+// VetoError is returned when the plugin vetoed the invocation with pdk.Veto,
+// for example to stop an event from reaching the next handlers of a hook.
+type VetoError struct {
+	Reason string
+}
+
+func (e *VetoError) Error() string {
+	return "vetoed: " + e.Reason
+}
+
+func (e *VetoError) Is(target error) bool {
+	return target == ErrVetoed
+}
+
+// pluginError returns the error the plugin reported for the invocation, typed
+// by its error code.
+func pluginError(ic *invoke.Context) error {
+	if ic.PluginErrCode == module.CodeVeto {
+		return &VetoError{Reason: ic.PluginErr}
+	}
+	return errors.New(ic.PluginErr)
+}
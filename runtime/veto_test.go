@@ -0,0 +1,30 @@
+package runtime
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVeto(t *testing.T) {
+	ctx := context.Background()
+	rt, err := New(ctx,
+		WithFile(ABI_WASM),
+		WithHostFns(HostFnByte("hello", reply)),
+		WithCircuitBreaker(BreakerConfig{Failures: 1, Cooldown: time.Hour}),
+	)
+	require.NoError(t, err)
+	defer rt.Close(ctx)
+
+	_, err = rt.Invoke(ctx, "veto", []byte("not today"))
+	require.ErrorIs(t, err, ErrVetoed)
+	var vetoErr *VetoError
+	require.ErrorAs(t, err, &vetoErr)
+	require.Equal(t, "not today", vetoErr.Reason)
+	require.Equal(t, BreakerClosed, rt.BreakerState("veto"), "vetoes should not trip the circuit breaker")
+
+	_, err = rt.Invoke(ctx, "echo", []byte("fail"))
+	require.NotErrorIs(t, err, ErrVetoed, "other errors are not vetoes")
+}
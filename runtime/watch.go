@@ -0,0 +1,246 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultPollInterval is how often a Watcher polling for changes checks the file.
+const DefaultPollInterval = time.Second
+
+// settleDelay is how long a Watcher waits after a change was noticed before
+// reading the file, so a file being written is reloaded once it is complete.
+const settleDelay = 100 * time.Millisecond
+
+// ReloadFn is called after a Watcher tried to reload the plugin, err is nil
+// when the new version is running.
+type ReloadFn func(path string, err error)
+
+// Watcher reloads the plugin of a Runtime when its file changes, see Runtime.Watch.
+type Watcher struct {
+	rt       *Runtime
+	path     string
+	fileOpts []FileOption
+	verify   bool // fileOpts were set with WithReloadFileOptions
+	smoke    *SmokeTest
+	onReload ReloadFn
+	interval time.Duration
+	poll     bool
+
+	last   []byte // the module running, to ignore changes which leave it the same
+	cancel context.CancelFunc
+	done   chan struct{}
+	once   sync.Once
+}
+
+// WatchOption configures a Watcher.
+type WatchOption func(*Watcher)
+
+// WithPollInterval makes the Watcher poll the file for changes at the interval
+// instead of being notified of them by the operating system.
+func WithPollInterval(interval time.Duration) WatchOption {
+	return func(w *Watcher) {
+		w.poll = true
+		w.interval = interval
+	}
+}
+
+// WithSmokeTest makes the Watcher call the new version of the plugin before it
+// replaces the running one, see Runtime.Reload.
+func WithSmokeTest(smoke SmokeTest) WatchOption {
+	return func(w *Watcher) {
+		w.smoke = &smoke
+	}
+}
+
+// WithReloadFn sets the function called after each reload, by default failed
+// reloads are written to the runtime's logger.
+func WithReloadFn(fn ReloadFn) WatchOption {
+	return func(w *Watcher) {
+		w.onReload = fn
+	}
+}
+
+// WithReloadFileOptions sets the options verifying new versions of the file,
+// such as their hash or signature. By default new versions are not verified,
+// it is required to watch a file created with a hash or a signature as these
+// only match the running version.
+func WithReloadFileOptions(opts ...FileOption) WatchOption {
+	return func(w *Watcher) {
+		w.fileOpts = opts
+		w.verify = true
+	}
+}
+
+// Watch reloads the plugin whenever its file changes until the Watcher is
+// closed or the runtime is closed. Changes are noticed through the operating
+// system where supported, by polling the file otherwise. A new version which
+// fails to load or to pass the smoke test is not swapped in, the previous one
+// keeps running:
+//
+//	w, err := rt.Watch(runtime.WithSmokeTest(runtime.SmokeTest{Operation: "ping"}))
+//	defer w.Close()
+func (e *Runtime) Watch(opts ...WatchOption) (*Watcher, error) {
+	if e.file == nil {
+		return nil, errors.New("plugin not loaded from a file")
+	}
+	w := &Watcher{
+		rt:       e,
+		path:     e.file.Path,
+		interval: DefaultPollInterval,
+		last:     e.file.data,
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if w.interval <= 0 {
+		return nil, errors.New("poll interval must be positive")
+	}
+	if !w.verify && (e.file.Hash != "" || e.file.publicKey != nil) {
+		return nil, errors.New("plugin file is verified, set how new versions are verified with WithReloadFileOptions")
+	}
+	if w.onReload == nil {
+		log := e.logger
+		w.onReload = func(path string, err error) {
+			if err != nil && log != nil {
+				log(err.Error())
+			}
+		}
+	}
+
+	var changes <-chan struct{}
+	var stop func()
+	if !w.poll {
+		changes, stop = notify(w.path)
+	}
+	if changes == nil {
+		changes, stop = w.pollChanges()
+	}
+
+	stop = sync.OnceFunc(stop)
+	ctx, cancel := context.WithCancel(context.Background())
+	closed := e.inflight.onAbort(func() { // the runtime is closed
+		cancel()
+		stop()
+	})
+	w.cancel = func() {
+		closed()
+		cancel()
+		stop()
+	}
+	go w.run(ctx, changes)
+	return w, nil
+}
+
+// Close stops watching the file, it waits for a reload in progress.
+func (w *Watcher) Close() error {
+	w.once.Do(w.cancel)
+	<-w.done
+	return nil
+}
+
+// Path returns the path of the watched file.
+func (w *Watcher) Path() string {
+	return w.path
+}
+
+// run reloads the plugin on every change until ctx is done.
+func (w *Watcher) run(ctx context.Context, changes <-chan struct{}) {
+	defer close(w.done)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-changes:
+			if !ok {
+				return
+			}
+		}
+
+		// let the file settle, coalescing the changes of a single write
+		timer := time.NewTimer(settleDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	drain:
+		for {
+			select {
+			case <-changes:
+			default:
+				break drain
+			}
+		}
+
+		if err := w.reload(ctx); errors.Is(err, ErrClosed) {
+			return
+		}
+	}
+}
+
+// reload reloads the plugin when the file holds a new module.
+func (w *Watcher) reload(ctx context.Context) error {
+	data, err := os.ReadFile(w.path)
+	if err != nil || bytes.Equal(data, w.last) {
+		return nil // removed while being replaced, or unchanged
+	}
+
+	file, err := NewFile(w.path, w.fileOpts...)
+	if err == nil {
+		err = w.rt.Reload(ctx, file, w.smoke)
+	} else {
+		err = &ReloadError{Path: w.path, Err: err}
+	}
+	if errors.Is(err, ErrClosed) {
+		return err
+	}
+	if err == nil {
+		w.last = file.data
+	}
+	w.onReload(w.path, err)
+	return err
+}
+
+// pollChanges reports a change whenever the size or modification time of the
+// file changed since it was last polled.
+func (w *Watcher) pollChanges() (<-chan struct{}, func()) {
+	changes := make(chan struct{}, 1)
+	stop := make(chan struct{})
+	stat := func() (time.Time, int64) {
+		info, err := os.Stat(w.path)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return info.ModTime(), info.Size()
+	}
+
+	modTime, size := stat()
+	go func() {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			t, s := stat()
+			if t.Equal(modTime) && s == size {
+				continue
+			}
+			modTime, size = t, s
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changes, func() { close(stop) }
+}
@@ -0,0 +1,71 @@
+//go:build linux
+
+package runtime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// watchMask are the inotify events of a file being written, or replaced by
+// moving another file over it.
+const watchMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE
+
+// notify reports the changes of the file at path with inotify. The directory is
+// watched, so files replaced by a rename are noticed. It returns a nil channel
+// when inotify is unavailable.
+func notify(path string) (<-chan struct{}, func()) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, nil
+	}
+	dir, name := filepath.Split(filepath.Clean(path))
+	if dir == "" {
+		dir = "."
+	}
+	if _, err := syscall.InotifyAddWatch(fd, dir, watchMask); err != nil {
+		_ = syscall.Close(fd)
+		return nil, nil
+	}
+
+	// the non-blocking descriptor is read through the runtime's poller, so
+	// closing the file stops a pending read
+	f := os.NewFile(uintptr(fd), "inotify")
+	changes := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, 16*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			if changed(buf[:n], name) {
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return changes, func() { _ = f.Close() }
+}
+
+// changed reports whether the inotify events are of the named file.
+func changed(events []byte, name string) bool {
+	for len(events) >= syscall.SizeofInotifyEvent {
+		// struct inotify_event { int wd; uint32_t mask; uint32_t cookie; uint32_t len; char name[]; }
+		nameLen := uint64(binary.NativeEndian.Uint32(events[12:16]))
+		if nameLen > uint64(len(events)-syscall.SizeofInotifyEvent) {
+			return false
+		}
+		eventName := events[syscall.SizeofInotifyEvent:][:nameLen]
+		if string(bytes.TrimRight(eventName, "\x00")) == name {
+			return true
+		}
+		events = events[syscall.SizeofInotifyEvent+len(eventName):]
+	}
+	return false
+}
@@ -0,0 +1,22 @@
+//go:build linux
+
+package runtime
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInotifyEvents(t *testing.T) {
+	event := func(name string, size int) []byte {
+		b := make([]byte, 16+size)
+		b[12] = byte(size)
+		copy(b[16:], name)
+		return b
+	}
+	events := append(event("other.wasm", 16), event("plugin.wasm", 16)...)
+	require.True(t, changed(events, "plugin.wasm"))
+	require.False(t, changed(events, "plugin"))
+	require.False(t, changed(event("plugin.wasm", 16)[:20], "plugin.wasm"), "truncated events are ignored")
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package runtime
+
+// notify is unavailable on this platform, the file is polled for changes.
+func notify(string) (<-chan struct{}, func()) {
+	return nil, nil
+}
@@ -0,0 +1,102 @@
+;; legacy is the abi plugin using the request and response functions of the
+;; host instead of an allocator, for comparison. Its __plugin_batch sends the
+;; encoded batch to the host the same way and responds with the answer.
+(module
+  (import "hookr" "__plugin_request" (func $plugin_request (param i32 i32)))
+  (import "hookr" "__plugin_response" (func $plugin_response (param i32 i32)))
+  (import "hookr" "__plugin_error" (func $plugin_error (param i32 i32)))
+  (import "hookr" "__host_call" (func $host_call (param i32 i32 i32 i32) (result i32)))
+  (import "hookr" "__host_response_len" (func $host_response_len (result i32)))
+  (import "hookr" "__host_response" (func $host_response (param i32)))
+  (import "hookr" "__host_error_len" (func $host_error_len (result i32)))
+  (import "hookr" "__host_error" (func $host_error (param i32)))
+
+  (memory (export "memory") 1)
+
+  (data (i32.const 0) "hello")
+
+  ;; ensure grows the memory to hold $end bytes
+  (func $ensure (param $end i32)
+    block $done
+      loop $grow
+        local.get $end
+        memory.size
+        i32.const 16
+        i32.shl
+        i32.le_u
+        br_if $done
+        i32.const 1
+        memory.grow
+        drop
+        br $grow
+      end
+    end
+  )
+
+  ;; the operation is read to 1024, the payload to 4096 followed by the response
+  (func $call (export "__plugin_call") (param $op_len i32) (param $payload_len i32) (result i32)
+    (local $resp i32)
+    (local $resp_len i32)
+    i32.const 4096
+    local.get $payload_len
+    i32.add
+    local.tee $resp
+    call $ensure
+    i32.const 1024
+    i32.const 4096
+    call $plugin_request
+
+    ;; 'n' responds with the payload
+    local.get $op_len
+    if
+      i32.const 1024
+      i32.load8_u
+      i32.const 110
+      i32.eq
+      if
+        i32.const 4096
+        local.get $payload_len
+        call $plugin_response
+        i32.const 1
+        return
+      end
+    end
+
+    i32.const 0
+    i32.const 5
+    i32.const 4096
+    local.get $payload_len
+    call $host_call
+    i32.eqz
+    if
+      call $host_error_len
+      local.set $resp_len
+      local.get $resp
+      local.get $resp_len
+      i32.add
+      call $ensure
+      local.get $resp
+      call $host_error
+      local.get $resp
+      local.get $resp_len
+      call $plugin_error
+      i32.const 0
+      return
+    end
+
+    call $host_response_len
+    local.set $resp_len
+    local.get $resp
+    local.get $resp_len
+    i32.add
+    call $ensure
+    local.get $resp
+    call $host_response
+    local.get $resp
+    local.get $resp_len
+    call $plugin_response
+    i32.const 1
+  )
+
+  (export "__plugin_batch" (func $call))
+)
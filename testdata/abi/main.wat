@@ -0,0 +1,124 @@
+;; abi is a plugin using the allocator ABI: the host writes requests into a
+;; buffer it allocated with hookr_alloc, and host responses into the scratch
+;; buffer at 1024 or, when they do not fit, into a buffer allocated with
+;; hookr_alloc. Every operation calls the host function "hello" with the payload
+;; and responds with the host response without copying it, operations starting
+;; with "n" respond with the payload and those starting with "v" veto with the
+;; payload as the reason.
+(module
+  (import "hookr" "__host_invoke" (func $host_invoke (param i32 i32 i32 i32 i32 i32) (result i64)))
+  (import "hookr" "__host_error_len" (func $host_error_len (result i32)))
+  (import "hookr" "__host_error" (func $host_error (param i32)))
+  (import "hookr" "__plugin_error" (func $plugin_error (param i32 i32)))
+  (import "hookr" "__plugin_error_code" (func $plugin_error_code (param i32)))
+
+  (memory (export "memory") 1)
+
+  ;; buffers are bump allocated from $heap and never freed
+  (global $heap (mut i32) (i32.const 4096))
+
+  (data (i32.const 0) "hello")
+
+  (func $alloc (export "hookr_alloc") (param $size i32) (result i32)
+    (local $ptr i32)
+    global.get $heap
+    local.set $ptr
+    ;; align to 8 bytes
+    local.get $ptr
+    local.get $size
+    i32.add
+    i32.const 7
+    i32.add
+    i32.const -8
+    i32.and
+    global.set $heap
+    block $done
+      loop $grow
+        global.get $heap
+        memory.size
+        i32.const 16
+        i32.shl
+        i32.le_u
+        br_if $done
+        i32.const 1
+        memory.grow
+        drop
+        br $grow
+      end
+    end
+    local.get $ptr
+  )
+
+  (func (export "hookr_free") (param $ptr i32) (param $size i32))
+
+  (func (export "__plugin_invoke") (param $ptr i32) (param $op_len i32) (param $payload_len i32) (result i64)
+    (local $payload i32)
+    (local $result i64)
+    (local $err i32)
+    (local $err_len i32)
+    local.get $ptr
+    local.get $op_len
+    i32.add
+    local.set $payload
+
+    ;; 'n' responds with the payload
+    local.get $op_len
+    if
+      local.get $ptr
+      i32.load8_u
+      i32.const 110
+      i32.eq
+      if
+        local.get $payload
+        i64.extend_i32_u
+        i64.const 32
+        i64.shl
+        local.get $payload_len
+        i64.extend_i32_u
+        i64.or
+        return
+      end
+
+      ;; 'v' vetoes
+      local.get $ptr
+      i32.load8_u
+      i32.const 118
+      i32.eq
+      if
+        i32.const 1
+        call $plugin_error_code
+        local.get $payload
+        local.get $payload_len
+        call $plugin_error
+        i64.const -1
+        return
+      end
+    end
+
+    i32.const 0
+    i32.const 5
+    local.get $payload
+    local.get $payload_len
+    i32.const 1024
+    i32.const 3072
+    call $host_invoke
+    local.set $result
+
+    local.get $result
+    i64.const -1
+    i64.eq
+    if
+      call $host_error_len
+      local.set $err_len
+      local.get $err_len
+      call $alloc
+      local.set $err
+      local.get $err
+      call $host_error
+      local.get $err
+      local.get $err_len
+      call $plugin_error
+    end
+    local.get $result
+  )
+)
@@ -0,0 +1,70 @@
+;; clock is a plugin which responds with what it observes of the host:
+;; [walltime u64, monotonic time u64, 8 random bytes], all little endian.
+;; An operation starting with "s" sleeps for a second before responding.
+(module
+  (import "wasi_snapshot_preview1" "clock_time_get" (func $clock_time_get (param i32 i64 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "random_get" (func $random_get (param i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "poll_oneoff" (func $poll_oneoff (param i32 i32 i32 i32) (result i32)))
+  (import "hookr" "__plugin_request" (func $plugin_request (param i32 i32)))
+  (import "hookr" "__plugin_response" (func $plugin_response (param i32 i32)))
+
+  (memory (export "memory") 1)
+
+  (func (export "__plugin_call") (param $op_len i32) (param $payload_len i32) (result i32)
+    i32.const 1024
+    i32.const 2048
+    call $plugin_request
+
+    ;; 's'
+    i32.const 1024
+    i32.load8_u
+    i32.const 115
+    i32.eq
+    if
+      ;; clock subscription at 256 for a relative timeout of one second on the monotonic clock
+      i32.const 256
+      i64.const 0
+      i64.store
+      i32.const 264
+      i32.const 0
+      i32.store8
+      i32.const 272
+      i32.const 1
+      i32.store
+      i32.const 280
+      i64.const 1000000000
+      i64.store
+      i32.const 288
+      i64.const 0
+      i64.store
+      i32.const 296
+      i32.const 0
+      i32.store
+      i32.const 256
+      i32.const 512
+      i32.const 1
+      i32.const 600
+      call $poll_oneoff
+      drop
+    end
+
+    i32.const 0
+    i64.const 0
+    i32.const 16
+    call $clock_time_get
+    drop
+    i32.const 1
+    i64.const 0
+    i32.const 24
+    call $clock_time_get
+    drop
+    i32.const 32
+    i32.const 8
+    call $random_get
+    drop
+
+    i32.const 16
+    i32.const 24
+    call $plugin_response
+    i32.const 1)
+)
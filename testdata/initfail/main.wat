@@ -0,0 +1,40 @@
+;; initfail is a plugin whose hookr_init fails depending on the first random byte
+;; provided by the host, which lets tests choose the failure with a rand source:
+;;   0     traps with unreachable
+;;   1     reports an error through __plugin_error
+;;   other initializes successfully
+(module
+  (import "wasi_snapshot_preview1" "random_get" (func $random_get (param i32 i32) (result i32)))
+  (import "hookr" "__plugin_error" (func $plugin_error (param i32 i32)))
+
+  (memory (export "memory") 1)
+
+  (data (i32.const 0) "config missing")
+
+  (func (export "hookr_init")
+    (local $b i32)
+    i32.const 64
+    i32.const 1
+    call $random_get
+    drop
+
+    i32.const 64
+    i32.load8_u
+    local.tee $b
+    i32.eqz
+    if
+      unreachable
+    end
+
+    local.get $b
+    i32.const 1
+    i32.eq
+    if
+      i32.const 0
+      i32.const 14
+      call $plugin_error
+    end)
+
+  (func (export "__plugin_call") (param $op_len i32) (param $payload_len i32) (result i32)
+    i32.const 1)
+)
@@ -0,0 +1,78 @@
+;; lifecycle is a plugin exporting the hookr_health and hookr_shutdown functions.
+;; The first byte of the operation passed to __plugin_call changes its behaviour:
+;;   "healthy"   reports healthy from hookr_health
+;;   "unhealthy" reports unhealthy from hookr_health
+;;   "block"     makes hookr_shutdown loop forever
+(module
+  (import "hookr" "__plugin_request" (func $plugin_request (param i32 i32)))
+  (import "hookr" "__plugin_error" (func $plugin_error (param i32 i32)))
+  (import "hookr" "__log" (func $log (param i32 i32)))
+
+  (memory (export "memory") 1)
+
+  (global $healthy (mut i32) (i32.const 1))
+  (global $block (mut i32) (i32.const 0))
+
+  (data (i32.const 0) "database unavailable")
+  (data (i32.const 32) "shutdown")
+
+  (func (export "__plugin_call") (param $op_len i32) (param $payload_len i32) (result i32)
+    (local $op i32)
+    i32.const 1024
+    i32.const 2048
+    call $plugin_request
+
+    i32.const 1024
+    i32.load8_u
+    local.set $op
+
+    ;; 'h'
+    local.get $op
+    i32.const 104
+    i32.eq
+    if
+      i32.const 1
+      global.set $healthy
+    end
+
+    ;; 'u'
+    local.get $op
+    i32.const 117
+    i32.eq
+    if
+      i32.const 0
+      global.set $healthy
+    end
+
+    ;; 'b'
+    local.get $op
+    i32.const 98
+    i32.eq
+    if
+      i32.const 1
+      global.set $block
+    end
+    i32.const 1)
+
+  (func (export "hookr_health") (result i32)
+    global.get $healthy
+    if
+      i32.const 1
+      return
+    end
+    i32.const 0
+    i32.const 20
+    call $plugin_error
+    i32.const 0)
+
+  (func (export "hookr_shutdown")
+    i32.const 32
+    i32.const 8
+    call $log
+    global.get $block
+    if
+      loop $forever
+        br $forever
+      end
+    end)
+)
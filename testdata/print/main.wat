@@ -0,0 +1,41 @@
+;; print is a plugin which writes the payload passed to __plugin_call to
+;; stdout, or to stderr when the operation starts with "e".
+(module
+  (import "wasi_snapshot_preview1" "fd_write" (func $fd_write (param i32 i32 i32 i32) (result i32)))
+  (import "hookr" "__plugin_request" (func $plugin_request (param i32 i32)))
+
+  (memory (export "memory") 1)
+
+  (func (export "__plugin_call") (param $op_len i32) (param $payload_len i32) (result i32)
+    (local $fd i32)
+    i32.const 1024
+    i32.const 2048
+    call $plugin_request
+
+    i32.const 1
+    local.set $fd
+    ;; 'e'
+    i32.const 1024
+    i32.load8_u
+    i32.const 101
+    i32.eq
+    if
+      i32.const 2
+      local.set $fd
+    end
+
+    ;; iovec at 16 pointing at the payload
+    i32.const 16
+    i32.const 2048
+    i32.store
+    i32.const 20
+    local.get $payload_len
+    i32.store
+
+    local.get $fd
+    i32.const 16
+    i32.const 1
+    i32.const 32
+    call $fd_write
+    i32.eqz)
+)
@@ -0,0 +1,79 @@
+;; snapshot is a plugin whose hookr_init builds state in memory and globals:
+;; it grows memory by a page, writes the table "abc" to the start of the new
+;; page and sets its globals. Every call responds with the state as
+;; [seed, table..., wide >> 40], an operation starting with "w" then clobbers
+;; the state. hookr_init logs "init" every time it runs.
+(module
+  (import "hookr" "__plugin_request" (func $plugin_request (param i32 i32)))
+  (import "hookr" "__plugin_response" (func $plugin_response (param i32 i32)))
+  (import "hookr" "__log" (func $log (param i32 i32)))
+
+  (memory (export "memory") 1)
+
+  (global $seed (mut i32) (i32.const 0))
+  (global $wide (mut i64) (i64.const 0))
+
+  (data (i32.const 0) "init")
+
+  (func (export "hookr_init")
+    i32.const 0
+    i32.const 4
+    call $log
+
+    i32.const 1
+    memory.grow
+    drop
+
+    i32.const 42
+    global.set $seed
+    i64.const 1099511627776
+    global.set $wide
+
+    i32.const 65536
+    i32.const 97
+    i32.store8
+    i32.const 65537
+    i32.const 98
+    i32.store8
+    i32.const 65538
+    i32.const 99
+    i32.store8)
+
+  (func (export "__plugin_call") (param $op_len i32) (param $payload_len i32) (result i32)
+    i32.const 1024
+    i32.const 2048
+    call $plugin_request
+
+    i32.const 16
+    global.get $seed
+    i32.store8
+    i32.const 17
+    i32.const 65536
+    i32.const 3
+    memory.copy
+    i32.const 20
+    global.get $wide
+    i64.const 40
+    i64.shr_u
+    i32.wrap_i64
+    i32.store8
+    i32.const 16
+    i32.const 5
+    call $plugin_response
+
+    ;; 'w'
+    i32.const 1024
+    i32.load8_u
+    i32.const 119
+    i32.eq
+    if
+      i32.const 0
+      global.set $seed
+      i64.const 0
+      global.set $wide
+      i32.const 65536
+      i32.const 88
+      i32.store8
+    end
+    i32.const 1)
+)
@@ -0,0 +1,75 @@
+;; v2 is the next version of the state plugin, its state is tagged "v2" and
+;; state tagged "v1" is rejected, so it cannot replace the first version.
+(module
+  (import "hookr" "__plugin_request" (func $plugin_request (param i32 i32)))
+  (import "hookr" "__plugin_response" (func $plugin_response (param i32 i32)))
+  (import "hookr" "__plugin_error" (func $plugin_error (param i32 i32)))
+
+  (memory (export "memory") 1)
+
+  (global $count (mut i32) (i32.const 0))
+
+  ;; the exported state: the msgpack array ["v2", bin(count)]
+  (data (i32.const 64) "\92\a2v2\c4\04")
+  (data (i32.const 256) "incompatible state")
+
+  (func (export "__plugin_call") (param $op_len i32) (param $payload_len i32) (result i32)
+    global.get $count
+    i32.const 1
+    i32.add
+    global.set $count
+    i32.const 200
+    global.get $count
+    i32.store
+    i32.const 200
+    i32.const 4
+    call $plugin_response
+    i32.const 1
+  )
+
+  (func (export "hookr_export_state") (result i32)
+    i32.const 70
+    global.get $count
+    i32.store
+    i32.const 64
+    i32.const 10
+    call $plugin_response
+    i32.const 1
+  )
+
+  ;; the version is read to 128, the state to 136
+  (func (export "hookr_import_state") (param $version_len i32) (param $state_len i32) (result i32)
+    local.get $version_len
+    i32.const 2
+    i32.ne
+    local.get $state_len
+    i32.const 4
+    i32.ne
+    i32.or
+    if
+      i32.const 256
+      i32.const 18
+      call $plugin_error
+      i32.const 0
+      return
+    end
+    i32.const 128
+    i32.const 136
+    call $plugin_request
+    i32.const 128
+    i32.load16_u
+    i32.const 0x3276 ;; "v2"
+    i32.ne
+    if
+      i32.const 256
+      i32.const 18
+      call $plugin_error
+      i32.const 0
+      return
+    end
+    i32.const 136
+    i32.load
+    global.set $count
+    i32.const 1
+  )
+)
@@ -0,0 +1,96 @@
+;; stream is a streaming plugin which upper cases its input.
+;; It reads chunks of up to 4096 bytes into a fixed buffer, so memory use does
+;; not depend on the size of the stream.
+(module
+  (import "hookr" "__plugin_request" (func $plugin_request (param i32 i32)))
+  (import "hookr" "__plugin_error" (func $plugin_error (param i32 i32)))
+  (import "hookr" "__stream_read" (func $stream_read (param i32 i32) (result i32)))
+  (import "hookr" "__stream_write" (func $stream_write (param i32 i32) (result i32)))
+
+  (memory (export "memory") 1)
+
+  (data (i32.const 0) "stream write failed")
+  (data (i32.const 32) "stream read failed")
+
+  (func (export "__plugin_call") (param $op_len i32) (param $payload_len i32) (result i32)
+    (local $n i32) (local $i i32) (local $c i32)
+    ;; the operation is written to offset 512, there is no payload for streams
+    i32.const 512
+    i32.const 0
+    call $plugin_request
+
+    block $done
+      loop $chunk
+        i32.const 1024
+        i32.const 4096
+        call $stream_read
+        local.tee $n
+        i32.eqz
+        br_if $done
+
+        local.get $n
+        i32.const 0
+        i32.lt_s
+        if
+          i32.const 32
+          i32.const 18
+          call $plugin_error
+          i32.const 0
+          return
+        end
+
+        ;; upper case a-z in place
+        i32.const 0
+        local.set $i
+        block $upper_done
+          loop $upper
+            local.get $i
+            local.get $n
+            i32.ge_u
+            br_if $upper_done
+
+            local.get $i
+            i32.const 1024
+            i32.add
+            i32.load8_u
+            local.set $c
+
+            local.get $c
+            i32.const 97
+            i32.sub
+            i32.const 26
+            i32.lt_u
+            if
+              local.get $i
+              i32.const 1024
+              i32.add
+              local.get $c
+              i32.const 32
+              i32.sub
+              i32.store8
+            end
+
+            local.get $i
+            i32.const 1
+            i32.add
+            local.set $i
+            br $upper
+          end
+        end
+
+        i32.const 1024
+        local.get $n
+        call $stream_write
+        i32.eqz
+        if
+          i32.const 0
+          i32.const 19
+          call $plugin_error
+          i32.const 0
+          return
+        end
+        br $chunk
+      end
+    end
+    i32.const 1)
+)
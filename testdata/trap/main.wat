@@ -0,0 +1,93 @@
+;; trap is a plugin which traps on request. The first byte of the operation
+;; passed to __plugin_call changes its behaviour:
+;;   "count"       responds with the number of calls since the plugin was initialized
+;;   "unreachable" executes unreachable
+;;   "oob"         loads from outside of its memory
+;;   "stack"       recurses until the stack overflows
+;;   "loop"        loops forever
+;; hookr_init logs "init" every time the plugin is initialized.
+(module
+  (import "hookr" "__plugin_request" (func $plugin_request (param i32 i32)))
+  (import "hookr" "__plugin_response" (func $plugin_response (param i32 i32)))
+  (import "hookr" "__log" (func $log (param i32 i32)))
+
+  (memory (export "memory") 1)
+
+  (global $calls (mut i32) (i32.const 0))
+
+  (data (i32.const 0) "init")
+
+  (func $recurse (param $n i32) (result i32)
+    local.get $n
+    i32.const 1
+    i32.add
+    call $recurse)
+
+  (func (export "hookr_init")
+    i32.const 0
+    i32.const 4
+    call $log)
+
+  (func (export "__plugin_call") (param $op_len i32) (param $payload_len i32) (result i32)
+    (local $op i32)
+    i32.const 1024
+    i32.const 2048
+    call $plugin_request
+
+    global.get $calls
+    i32.const 1
+    i32.add
+    global.set $calls
+
+    i32.const 1024
+    i32.load8_u
+    local.set $op
+
+    ;; 'u'
+    local.get $op
+    i32.const 117
+    i32.eq
+    if
+      unreachable
+    end
+
+    ;; 'o'
+    local.get $op
+    i32.const 111
+    i32.eq
+    if
+      i32.const -16
+      i32.load
+      return
+    end
+
+    ;; 's'
+    local.get $op
+    i32.const 115
+    i32.eq
+    if
+      i32.const 0
+      call $recurse
+      return
+    end
+
+    ;; 'l'
+    local.get $op
+    i32.const 108
+    i32.eq
+    if
+      loop $forever
+        br $forever
+      end
+    end
+
+    ;; responds with the number of calls as a single byte
+    i32.const 16
+    global.get $calls
+    i32.store8
+    i32.const 16
+    i32.const 1
+    call $plugin_response
+
+    i32.const 1)
+)